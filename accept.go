@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	minAcceptBackoff   = 5 * time.Millisecond // 接受连接失败后第一次等待的时间
+	maxAcceptBackoff   = time.Second          // 接受连接失败后等待时间的上限
+	acceptFailureLimit = 30 * time.Second     // 持续失败超过该时间时认为本地监听不可用
+)
+
+// acceptBackoff 接受本地连接连续失败时的等待策略，如文件描述符耗尽(EMFILE)时等待已有的连接释放
+type acceptBackoff struct {
+	delay      time.Duration
+	firstError time.Time
+}
+
+// next 返回下一次接受连接前等待的时间，持续失败超过acceptFailureLimit时返回false
+func (b *acceptBackoff) next() (time.Duration, bool) {
+	if b.delay == 0 {
+		b.delay = minAcceptBackoff
+		b.firstError = time.Now()
+	} else if b.delay *= 2; b.delay > maxAcceptBackoff {
+		b.delay = maxAcceptBackoff
+	}
+	return b.delay, time.Since(b.firstError) < acceptFailureLimit
+}
+
+func (b *acceptBackoff) reset() {
+	b.delay = 0
+}
+
+// handleAcceptError 处理接受本地连接的错误，返回false时停止接受连接
+// 调用Stop关闭监听时直接返回，临时的错误等待后重试，监听意外关闭或持续失败时触发EventAcceptFailed并停止隧道
+func (s *SshTunnel) handleAcceptError(listener net.Listener, backoff *acceptBackoff, err error) bool {
+	if s.willClose.Load() {
+		return false
+	}
+	if !errors.Is(err, net.ErrClosed) {
+		delay, ok := backoff.next()
+		if ok {
+			s.log.Infof(fmt.Sprintf("[!] Error accepting local SSH tunnel connection: %s, retrying in %s", err.Error(), delay))
+			select {
+			case <-s.stopped:
+				return false
+			case <-time.After(delay):
+			}
+			return true
+		}
+	}
+	err = fmt.Errorf("local endpoint %s stopped accepting connections: %w", listener.Addr(), err)
+	s.log.Infof(fmt.Sprintf("[!] %s", err.Error()))
+	s.emit(Event{Type: EventAcceptFailed, Err: err})
+	s.setState(StateStopped, err)
+	go s.Stop()
+	return false
+}
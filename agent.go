@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"sync"
+)
+
+// agentSource 本地的ssh-agent，连接在第一次使用时建立，出错后重新连接
+type agentSource struct {
+	socket string // ssh-agent的地址，为空时使用系统默认的ssh-agent
+	mu     sync.Mutex
+	conn   io.ReadWriteCloser
+	client agent.ExtendedAgent
+}
+
+func newAgentSource(socket string) *agentSource {
+	return &agentSource{socket: socket}
+}
+
+// agent 获取到ssh-agent的连接
+func (a *agentSource) agent() (agent.ExtendedAgent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client != nil {
+		return a.client, nil
+	}
+	conn, err := dialAgent(a.socket)
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh agent failed, err: %w", err)
+	}
+	a.conn = conn
+	a.client = agent.NewClient(conn)
+	return a.client, nil
+}
+
+// signers 获取ssh-agent中的密钥，用于公钥认证
+func (a *agentSource) signers() ([]ssh.Signer, error) {
+	client, err := a.agent()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := client.Signers()
+	if err != nil {
+		// ssh-agent可能已经重启，下次使用时重新连接
+		a.close()
+		return nil, fmt.Errorf("list ssh agent keys failed, err: %w", err)
+	}
+	return signers, nil
+}
+
+// close 关闭到ssh-agent的连接
+func (a *agentSource) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn != nil {
+		a.conn.Close()
+	}
+	a.conn = nil
+	a.client = nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+var errNoAgent = errors.New("ssh agent is not available, SSH_AUTH_SOCK is not set")
+
+// dialAgent 连接到unix socket上的ssh-agent
+func dialAgent(socket string) (io.ReadWriteCloser, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return nil, errNoAgent
+	}
+	return net.Dial("unix", socket)
+}
@@ -0,0 +1,182 @@
+//go:build windows
+
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+// AgentPageant 作为AgentSocket时使用Pageant作为ssh-agent
+const AgentPageant = "pageant"
+
+// openSSHAgentPipe windows自带的OpenSSH ssh-agent服务监听的命名管道
+const openSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+const (
+	pageantMaxMessage = 8192       // Pageant共享内存的大小，也是单个消息的最大长度
+	pageantCopyDataID = 0x804e50ba // WM_COPYDATA中标识Pageant请求的dwData
+	wmCopyData        = 0x004a
+)
+
+var (
+	errPageantNotRunning = errors.New("pageant is not running")
+	errPageantFailed     = errors.New("pageant request failed")
+
+	user32          = windows.NewLazySystemDLL("user32.dll")
+	procFindWindow  = user32.NewProc("FindWindowW")
+	procSendMessage = user32.NewProc("SendMessageW")
+
+	pageantRequestSeq uint32
+)
+
+// dialAgent 连接到ssh-agent，socket为命名管道或AgentPageant，为空时依次尝试SSH_AUTH_SOCK、OpenSSH的命名管道和Pageant
+func dialAgent(socket string) (io.ReadWriteCloser, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == AgentPageant {
+		return dialPageant()
+	}
+	if socket != "" {
+		return os.OpenFile(socket, os.O_RDWR, 0)
+	}
+	pipe, err := os.OpenFile(openSSHAgentPipe, os.O_RDWR, 0)
+	if err == nil {
+		return pipe, nil
+	}
+	conn, pageantErr := dialPageant()
+	if pageantErr != nil {
+		return nil, fmt.Errorf("open %s failed, err: %v, and %w", openSSHAgentPipe, err, pageantErr)
+	}
+	return conn, nil
+}
+
+// pageantConn 通过WM_COPYDATA与Pageant交互的ssh-agent连接，每写入一个完整的请求后，从Pageant读取对应的响应
+type pageantConn struct {
+	request  []byte
+	response []byte
+}
+
+func dialPageant() (io.ReadWriteCloser, error) {
+	if findPageantWindow() == 0 {
+		return nil, errPageantNotRunning
+	}
+	return &pageantConn{}, nil
+}
+
+func (c *pageantConn) Write(p []byte) (int, error) {
+	c.request = append(c.request, p...)
+	for len(c.request) >= 4 {
+		size := 4 + int(binary.BigEndian.Uint32(c.request))
+		if size > pageantMaxMessage {
+			c.request = nil
+			return 0, fmt.Errorf("pageant request too large: %d bytes", size)
+		}
+		if len(c.request) < size {
+			break
+		}
+		response, err := queryPageant(c.request[:size])
+		c.request = c.request[size:]
+		if err != nil {
+			return 0, err
+		}
+		c.response = append(c.response, response...)
+	}
+	return len(p), nil
+}
+
+func (c *pageantConn) Read(p []byte) (int, error) {
+	if len(c.response) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.response)
+	c.response = c.response[n:]
+	return n, nil
+}
+
+func (c *pageantConn) Close() error {
+	c.request = nil
+	c.response = nil
+	return nil
+}
+
+func findPageantWindow() uintptr {
+	name, _ := windows.UTF16PtrFromString("Pageant")
+	hwnd, _, _ := procFindWindow.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(name)))
+	return hwnd
+}
+
+// copyDataStruct windows的COPYDATASTRUCT
+type copyDataStruct struct {
+	dwData uintptr
+	cbData uint32
+	lpData uintptr
+}
+
+// queryPageant 将请求写入共享内存，通过WM_COPYDATA通知Pageant处理，再从共享内存中读取响应
+func queryPageant(request []byte) ([]byte, error) {
+	hwnd := findPageantWindow()
+	if hwnd == 0 {
+		return nil, errPageantNotRunning
+	}
+
+	// Pageant只接受与自身属于同一用户的共享内存
+	token := windows.GetCurrentProcessToken()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("get current user failed, err: %w", err)
+	}
+	sid := user.User.Sid.String()
+	sd, err := windows.SecurityDescriptorFromString(fmt.Sprintf("O:%sD:P(A;;GA;;;%s)", sid, sid))
+	if err != nil {
+		return nil, fmt.Errorf("build security descriptor failed, err: %w", err)
+	}
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+
+	mapName := fmt.Sprintf("PageantRequest%08x%08x", windows.GetCurrentProcessId(), atomic.AddUint32(&pageantRequestSeq, 1))
+	mapNamePtr, err := windows.BytePtrFromString(mapName)
+	if err != nil {
+		return nil, err
+	}
+	mapNameUTF16, err := windows.UTF16PtrFromString(mapName)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := windows.CreateFileMapping(windows.InvalidHandle, sa, windows.PAGE_READWRITE, 0, pageantMaxMessage, mapNameUTF16)
+	if err != nil {
+		return nil, fmt.Errorf("create pageant shared memory failed, err: %w", err)
+	}
+	defer windows.CloseHandle(mapping)
+	addr, err := windows.MapViewOfFile(mapping, windows.FILE_MAP_WRITE, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("map pageant shared memory failed, err: %w", err)
+	}
+	defer windows.UnmapViewOfFile(addr)
+	shared := unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&addr))), pageantMaxMessage)
+	copy(shared, request)
+
+	cds := copyDataStruct{
+		dwData: pageantCopyDataID,
+		cbData: uint32(len(mapName) + 1),
+		lpData: uintptr(unsafe.Pointer(mapNamePtr)),
+	}
+	result, _, _ := procSendMessage.Call(hwnd, wmCopyData, 0, uintptr(unsafe.Pointer(&cds)))
+	if result == 0 {
+		return nil, errPageantFailed
+	}
+	size := 4 + int(binary.BigEndian.Uint32(shared))
+	if size > pageantMaxMessage {
+		return nil, errPageantFailed
+	}
+	response := make([]byte, size)
+	copy(response, shared)
+	return response, nil
+}
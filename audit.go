@@ -0,0 +1,155 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 转发连接结束的原因
+const (
+	AuditReasonLocalClosed  = "local-closed"   // 本地连接先关闭
+	AuditReasonRemoteClosed = "remote-closed"  // 远端连接先关闭
+	AuditReasonError        = "error"          // 转发时发生错误，如ssh连接断开
+	AuditReasonStopped      = "tunnel-stopped" // 隧道被停止
+)
+
+// 发送审计记录的http请求默认的超时时间
+var defaultAuditHTTPTimeout = 10 * time.Second
+
+// AuditRecord 一个转发连接的审计记录
+type AuditRecord struct {
+	Tunnel        string            `json:"tunnel"`
+	TunnelID      string            `json:"tunnel_id"`
+	Labels        map[string]string `json:"labels,omitempty"` // 隧道的标签
+	User          string            `json:"user"`             // 隧道认证的账号
+	Client        string            `json:"client"`           // 本地连接的来源地址
+	Bastion       string            `json:"bastion"`          // 转发经过的ssh服务端地址
+	Target        string            `json:"target"`           // 最终连接的远端地址
+	Start         time.Time         `json:"start"`
+	End           time.Time         `json:"end"`
+	BytesSent     int64             `json:"bytes_sent"`     // 本地发往远端的字节数
+	BytesReceived int64             `json:"bytes_received"` // 远端发往本地的字节数
+	Reason        string            `json:"reason"`         // 结束的原因，见AuditReasonXXX
+	Error         string            `json:"error,omitempty"`
+}
+
+// AuditSink 审计记录的输出，会在转发连接的协程中同步调用
+type AuditSink interface {
+	WriteAudit(record *AuditRecord) error
+}
+
+// AuditSinkFunc 使普通函数满足AuditSink接口
+type AuditSinkFunc func(record *AuditRecord) error
+
+func (f AuditSinkFunc) WriteAudit(record *AuditRecord) error {
+	return f(record)
+}
+
+// FileAuditSink 以每行一个json的格式追加写入文件
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink 以追加的方式打开审计文件，文件不存在时创建
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file failed, err: %w", err)
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+func (f *FileAuditSink) WriteAudit(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	return err
+}
+
+func (f *FileAuditSink) Close() error {
+	return f.file.Close()
+}
+
+// HTTPAuditSink 将每条审计记录以json POST到指定的地址，如日志收集服务
+type HTTPAuditSink struct {
+	URL    string
+	Header http.Header  // 请求附加的头部，如Authorization
+	Client *http.Client // 为空时使用超时时间为10s的客户端
+}
+
+// NewHTTPAuditSink 创建POST审计记录到url的输出
+func NewHTTPAuditSink(url string) *HTTPAuditSink {
+	return &HTTPAuditSink{URL: url, Client: &http.Client{Timeout: defaultAuditHTTPTimeout}}
+}
+
+func (h *HTTPAuditSink) WriteAudit(record *AuditRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range h.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultAuditHTTPTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit endpoint returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// newAuditRecord 创建转发连接的审计记录，未配置AuditSink时返回的记录不会输出
+func (s *SshTunnel) newAuditRecord(localConn net.Conn, serverConn *ssh.Client, target string, latency *ConnLatency) *AuditRecord {
+	record := &AuditRecord{
+		Tunnel:   s.name,
+		TunnelID: s.id,
+		Labels:   s.labels,
+		Client:   localConn.RemoteAddr().String(),
+		Target:   target,
+		Start:    time.Now(),
+	}
+	// 按路由规则不经过隧道的连接没有ssh连接
+	if serverConn != nil {
+		record.User = serverConn.User()
+		record.Bastion = serverConn.RemoteAddr().String()
+	}
+	if latency != nil {
+		record.Start = latency.Accepted
+	}
+	return record
+}
+
+// audit 输出转发连接结束时的审计记录，输出失败时只记录日志
+func (s *SshTunnel) audit(record *AuditRecord) {
+	if s.auditSink == nil {
+		return
+	}
+	record.End = time.Now()
+	if err := s.auditSink.WriteAudit(record); err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error writing audit record: %s", err.Error()))
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows || plan9
+
+package tunnel
+
+import (
+	"errors"
+)
+
+// SyslogAuditSink 将审计记录以json写入syslog，当前平台不支持
+type SyslogAuditSink struct{}
+
+// NewSyslogAuditSink 当前平台没有syslog，总是返回错误
+func NewSyslogAuditSink(network, raddr, tag string) (*SyslogAuditSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (s *SyslogAuditSink) WriteAudit(record *AuditRecord) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+func (s *SyslogAuditSink) Close() error {
+	return nil
+}
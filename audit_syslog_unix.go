@@ -0,0 +1,35 @@
+//go:build !(windows || plan9)
+
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogAuditSink 将审计记录以json写入syslog，facility为LOG_AUTH
+type SyslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAuditSink 连接到syslog服务，network和raddr为空时使用本机的syslog
+func NewSyslogAuditSink(network, raddr, tag string) (*SyslogAuditSink, error) {
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog failed, err: %w", err)
+	}
+	return &SyslogAuditSink{writer: writer}, nil
+}
+
+func (s *SyslogAuditSink) WriteAudit(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(line))
+}
+
+func (s *SyslogAuditSink) Close() error {
+	return s.writer.Close()
+}
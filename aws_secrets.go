@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSSecretsCredentialProvider 从AWS Secrets Manager获取隧道的凭据
+// secret的内容为json对象时读取其中的username、password、private_key字段，否则整个secret作为密码
+type AWSSecretsCredentialProvider struct {
+	SecretID     string // secret的名称或ARN
+	VersionStage string // secret的版本阶段，为空时为AWSCURRENT
+	Region       string // 为空时使用环境变量AWS_REGION
+	Username     string // secret中没有username时使用的账号
+
+	// 访问AWS的凭据，为空时使用环境变量AWS_ACCESS_KEY_ID、AWS_SECRET_ACCESS_KEY、AWS_SESSION_TOKEN
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	Endpoint   string       // 自定义的服务地址，如VPC endpoint，为空时使用区域默认的地址
+	HTTPClient *http.Client // 为空时使用超时时间为30s的客户端
+}
+
+func (a *AWSSecretsCredentialProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	region := firstNonEmpty(a.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	accessKeyID := firstNonEmpty(a.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID"))
+	secretAccessKey := firstNonEmpty(a.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	sessionToken := a.SessionToken
+	if a.AccessKeyID == "" {
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if region == "" || accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("aws region or access key is not configured")
+	}
+
+	input := map[string]string{"SecretId": a.SecretID}
+	if a.VersionStage != "" {
+		input["VersionStage"] = a.VersionStage
+	}
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+	signAWSRequestV4(req, payload, region, "secretsmanager", accessKeyID, secretAccessKey, time.Now())
+
+	client := a.HTTPClient
+	if client == nil {
+		client = credentialHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request aws secrets manager failed, err: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("get aws secret %s failed, status: %s, body: %s", a.SecretID, resp.Status, string(body))
+	}
+	output := struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+	}{}
+	if err = json.Unmarshal(body, &output); err != nil {
+		return nil, fmt.Errorf("decode aws secrets manager response failed, err: %w", err)
+	}
+	secret := []byte(output.SecretString)
+	if output.SecretString == "" {
+		secret = output.SecretBinary
+	}
+	credentials := &Credentials{Username: a.Username}
+	if err = parseSecretCredentials(secret, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// signAWSRequestV4 按AWS Signature Version 4为请求签名，签名包括host及请求中已设置的所有头部
+func signAWSRequestV4(req *http.Request, payload []byte, region, service, accessKeyID, secretAccessKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := sha256Hex(payload)
+
+	// 参与签名的头部，按名称排序
+	signedHeaders := []string{"host"}
+	for name := range req.Header {
+		if header := strings.ToLower(name); header != "authorization" && header != "host" {
+			signedHeaders = append(signedHeaders, header)
+		}
+	}
+	sort.Strings(signedHeaders)
+	var canonicalHeaders strings.Builder
+	for _, header := range signedHeaders {
+		value := strings.Join(req.Header.Values(header), ",")
+		if header == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(header + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// AWS Signature Version 4测试集(aws-sig-v4-test-suite)使用的凭据及时间
+const (
+	sigV4TestAccessKeyID     = "AKIDEXAMPLE"
+	sigV4TestSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	sigV4TestRegion          = "us-east-1"
+	sigV4TestService         = "service"
+)
+
+func TestSignAWSRequestV4TestSuite(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		method string
+		want   string
+	}{
+		{
+			name:   "get-vanilla",
+			method: http.MethodGet,
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "post-vanilla",
+			method: http.MethodPost,
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signAWSRequestV4(req, nil, sigV4TestRegion, sigV4TestService, sigV4TestAccessKeyID, sigV4TestSecretAccessKey, now)
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want %q", got, "20150830T123600Z")
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// 见AWS文档中派生签名密钥的示例
+func TestAWSSigningKeyDerivation(t *testing.T) {
+	key := hmacSHA256([]byte("AWS4"+sigV4TestSecretAccessKey), "20120215")
+	key = hmacSHA256(key, "us-east-1")
+	key = hmacSHA256(key, "iam")
+	key = hmacSHA256(key, "aws4_request")
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got := hex.EncodeToString(key); got != want {
+		t.Fatalf("signing key = %s, want %s", got, want)
+	}
+}
+
+func TestSignAWSRequestV4SignsRequestHeaders(t *testing.T) {
+	payload := []byte(`{"SecretId":"bastion"}`)
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager.us-east-1.amazonaws.com/", strings.NewReader(string(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	req.Header.Set("X-Amz-Security-Token", "token")
+	signAWSRequestV4(req, payload, "us-east-1", "secretsmanager", sigV4TestAccessKeyID, sigV4TestSecretAccessKey, time.Now())
+	want := "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target,"
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, want) {
+		t.Fatalf("Authorization = %s, want it to contain %s", got, want)
+	}
+}
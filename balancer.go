@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// 多个远端地址时的负载均衡策略
+const (
+	LoadBalanceRoundRobin = "round-robin" // 轮询，默认策略
+	LoadBalanceLeastConn  = "least-conn"  // 选择当前活跃连接最少的远端地址
+)
+
+// remoteBalancer 在多个远端地址之间分发连接
+type remoteBalancer struct {
+	mu       sync.Mutex
+	strategy string
+	targets  []string
+	active   []int // 每个远端地址当前活跃的连接数
+	next     int   // 轮询时下一个使用的远端地址
+}
+
+func newRemoteBalancer(strategy string, targets []string) (*remoteBalancer, error) {
+	switch strategy {
+	case "":
+		strategy = LoadBalanceRoundRobin
+	case LoadBalanceRoundRobin, LoadBalanceLeastConn:
+	default:
+		return nil, fmt.Errorf("not supported load balance strategy: %s", strategy)
+	}
+	return &remoteBalancer{
+		strategy: strategy,
+		targets:  targets,
+		active:   make([]int, len(targets)),
+	}, nil
+}
+
+// pick 按策略选择一个远端地址，使用完毕后需要调用release
+func (b *remoteBalancer) pick() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	index := b.next
+	if b.strategy == LoadBalanceLeastConn {
+		// 活跃连接数相同时仍然轮询，避免总是选择第一个
+		for i := range b.targets {
+			candidate := (b.next + i) % len(b.targets)
+			if b.active[candidate] < b.active[index] {
+				index = candidate
+			}
+		}
+	}
+	b.next = (index + 1) % len(b.targets)
+	b.active[index]++
+	return index
+}
+
+func (b *remoteBalancer) release(index int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.active[index]--
+}
+
+// releaseOnCloseConn 连接关闭时释放负载均衡中占用的连接数
+type releaseOnCloseConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *releaseOnCloseConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// BandwidthLimiter 限制通过隧道建立的连接的总带宽，可以由多个隧道共用，如同一个租户的所有隧道
+// 两个方向的流量合并计算，最多积累1s的突发流量，可以并发使用
+type BandwidthLimiter struct {
+	mu   sync.Mutex
+	rate int64     // 每秒最多转发的字节数，为0时不限制
+	next time.Time // 已经分配的流量全部发送完的时间
+}
+
+// NewBandwidthLimiter 创建每秒最多转发bytesPerSecond字节的限速器，为0时不限制
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	return &BandwidthLimiter{rate: bytesPerSecond}
+}
+
+// SetLimit 修改限速，对正在转发的连接立即生效
+func (l *BandwidthLimiter) SetLimit(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = bytesPerSecond
+}
+
+// Limit 获取当前的限速
+func (l *BandwidthLimiter) Limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// chunk 每次读写的最大字节数，限制单次读写的大小使共用限速器的连接交替获得带宽
+func (l *BandwidthLimiter) chunk(size int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit := int(l.rate / 10); l.rate > 0 && size > limit {
+		return max(limit, 1)
+	}
+	return size
+}
+
+// wait 分配size字节的流量，等待到可以发送为止
+func (l *BandwidthLimiter) wait(size int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if earliest := now.Add(-time.Second); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	l.next = l.next.Add(time.Duration(size) * time.Second / time.Duration(l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// limitedConn 按限速器读写的连接
+type limitedConn struct {
+	net.Conn
+	limiter *BandwidthLimiter
+}
+
+// withBandwidthLimit 为连接限速，limiter为空时返回原始的连接
+func withBandwidthLimit(conn net.Conn, limiter *BandwidthLimiter) net.Conn {
+	if limiter == nil {
+		return conn
+	}
+	return &limitedConn{Conn: conn, limiter: limiter}
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p[:c.limiter.chunk(len(p))])
+	if n > 0 {
+		c.limiter.wait(n)
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:c.limiter.chunk(len(p))]
+		c.limiter.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
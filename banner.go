@@ -0,0 +1,35 @@
+package tunnel
+
+import (
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// ssh协议版本字符串的前缀，RFC 4253要求版本字符串加上CRLF不超过255个字符
+const (
+	clientVersionPrefix = "SSH-2.0-"
+	maxClientVersionLen = 253
+)
+
+// checkClientVersion 检查自定义的ssh客户端版本字符串，为空时使用默认值
+func checkClientVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	if !strings.HasPrefix(version, clientVersionPrefix) || len(version) > maxClientVersionLen || strings.ContainsAny(version, "\r\n") {
+		return ErrBadOption
+	}
+	return nil
+}
+
+// bannerCallback 接收ssh服务端在认证前发送的banner(如法律要求的告知)，触发EventBanner并调用配置的BannerCallback
+func (s *SshTunnel) bannerCallback(callback func(message string)) ssh.BannerCallback {
+	return func(message string) error {
+		s.log.Infof("[*] Received banner from ssh server")
+		s.emit(Event{Type: EventBanner, Message: message})
+		if callback != nil {
+			callback(message)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// 压测的默认参数
+const (
+	defaultBenchmarkBytes = 16 << 20
+	defaultBenchmarkPings = 10
+	benchmarkChunkSize    = 32 << 10
+)
+
+// BenchmarkOptions 压测的参数
+type BenchmarkOptions struct {
+	// Target 压测流量的目标地址(host:port)，必须是回显收到数据的服务(如echo服务或socat的回环)，为空时使用隧道的远端地址
+	Target string
+	Bytes  int64 // 发送的数据量，默认16MB
+	Pings  int   // 测量往返时间的次数，默认10次
+}
+
+// BenchmarkResult 压测的结果
+type BenchmarkResult struct {
+	Target     string
+	BastionRTT LatencySummary // ssh服务端的往返时间，通过keepalive请求测量
+	RTT        LatencySummary // 经过隧道到目标地址的往返时间，通过回显1个字节测量
+	Bytes      int64          // 发送并收到回显的数据量
+	Duration   time.Duration  // 发送并收到全部回显的耗时
+	Throughput float64        // 吞吐量，字节/秒
+}
+
+func (r *BenchmarkResult) String() string {
+	return fmt.Sprintf("target %s, bastion rtt avg %s max %s, rtt avg %s max %s, %d bytes in %s, %.2f MB/s",
+		r.Target, r.BastionRTT.Avg, r.BastionRTT.Max, r.RTT.Avg, r.RTT.Max, r.Bytes, r.Duration, r.Throughput/(1<<20))
+}
+
+// Benchmark 通过隧道向回显服务发送测试流量，测量往返时间及吞吐量，用于切换正式流量前检查链路质量
+// 压测使用单独的ssh连接，不经过本地监听的端口，ctx结束时中断压测
+func (s *SshTunnel) Benchmark(ctx context.Context, options BenchmarkOptions) (*BenchmarkResult, error) {
+	if options.Target == "" {
+		options.Target = s.remoteEndpoint
+	}
+	if options.Bytes <= 0 {
+		options.Bytes = defaultBenchmarkBytes
+	}
+	if options.Pings <= 0 {
+		options.Pings = defaultBenchmarkPings
+	}
+
+	serverConn, err := s.connectToServerSsh()
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh server failed, err: %w", err)
+	}
+	defer serverConn.Close()
+	// ssh的连接不支持deadline，ctx结束时直接关闭连接
+	stop := context.AfterFunc(ctx, func() { serverConn.Close() })
+	defer stop()
+
+	result := &BenchmarkResult{Target: options.Target}
+	var bastionRTT latencySum
+	for i := 0; i < options.Pings; i++ {
+		start := time.Now()
+		// 服务端不认识该请求时也会回复失败，只关心往返的时间
+		if _, _, err = serverConn.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return nil, benchmarkError(ctx, "ping ssh server", err)
+		}
+		bastionRTT.add(time.Since(start))
+	}
+	result.BastionRTT = bastionRTT.summary(int64(options.Pings))
+
+	remoteConn, err := s.dialThroughServer(serverConn, options.Target)
+	if err != nil {
+		return nil, benchmarkError(ctx, "connect to benchmark target", err)
+	}
+	defer remoteConn.Close()
+
+	var rtt latencySum
+	buf := make([]byte, 1)
+	for i := 0; i < options.Pings; i++ {
+		start := time.Now()
+		if _, err = remoteConn.Write(buf); err != nil {
+			return nil, benchmarkError(ctx, "write to benchmark target", err)
+		}
+		if _, err = io.ReadFull(remoteConn, buf); err != nil {
+			return nil, benchmarkError(ctx, "read echo from benchmark target", err)
+		}
+		rtt.add(time.Since(start))
+	}
+	result.RTT = rtt.summary(int64(options.Pings))
+
+	// 边发送边读取回显，避免回显服务因为发送缓冲区满而停止读取
+	start := time.Now()
+	writeErr := make(chan error, 1)
+	go func() {
+		chunk := make([]byte, benchmarkChunkSize)
+		for remaining := options.Bytes; remaining > 0; remaining -= int64(len(chunk)) {
+			if remaining < int64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+			if _, err := remoteConn.Write(chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+	received, err := io.CopyN(io.Discard, remoteConn, options.Bytes)
+	if err != nil {
+		return nil, benchmarkError(ctx, "read echo from benchmark target", err)
+	}
+	if err = <-writeErr; err != nil {
+		return nil, benchmarkError(ctx, "write to benchmark target", err)
+	}
+	result.Bytes = received
+	result.Duration = time.Since(start)
+	result.Throughput = float64(received) / result.Duration.Seconds()
+	return result, nil
+}
+
+// benchmarkError 压测被ctx中断时返回ctx的错误
+func benchmarkError(ctx context.Context, action string, err error) error {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w, the target may not echo back", err)
+	}
+	return fmt.Errorf("%s failed, err: %w", action, err)
+}
@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+)
+
+var (
+	errNotBound         = errors.New("tunnel stopped before local endpoint was bound")
+	errTunnelNotStarted = errors.New("tunnel failed to start")
+)
+
+// addrPort 获取地址中的端口，不是tcp地址时为0
+func addrPort(addr net.Addr) int {
+	if addr == nil {
+		return 0
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.Port
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+	portNum, _ := strconv.Atoi(port)
+	return portNum
+}
+
+// markBound 本地端点监听成功或失败，唤醒WaitBound
+func (s *SshTunnel) markBound(err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	select {
+	case <-s.bound:
+	default:
+		s.bindErr = err
+		close(s.bound)
+	}
+}
+
+// Addr 本地实际监听的地址(host:port)，监听随机端口(如127.0.0.1:0)时为系统分配的端口，隧道开始监听前为空
+func (s *SshTunnel) Addr() string {
+	if addr := s.LocalAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// Port 本地实际监听的端口，隧道开始监听前或监听的不是tcp地址时为0
+func (s *SshTunnel) Port() int {
+	return addrPort(s.LocalAddr())
+}
+
+// WaitBound 等待本地端点开始监听，返回实际监听的地址，监听或启动时连接ssh服务失败时返回对应的错误
+func (s *SshTunnel) WaitBound(ctx context.Context) (net.Addr, error) {
+	select {
+	case <-s.bound:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s.stateMu.Lock()
+	err := s.bindErr
+	s.stateMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if addr := s.LocalAddr(); addr != nil {
+		return addr, nil
+	}
+	return nil, errNotBound
+}
+
+// startError 启动失败的原因
+func (s *SshTunnel) startError() error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.bindErr
+}
+
+// Addr 本地实际监听的地址(host:port)，隧道开始监听前为空
+func (m *MemTunnel) Addr() string {
+	if addr := m.LocalAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// Port 本地实际监听的端口，隧道开始监听前为0
+func (m *MemTunnel) Port() int {
+	return addrPort(m.LocalAddr())
+}
+
+// WaitBound 等待本地端点开始监听，返回实际监听的地址，监听失败时返回监听的错误
+func (m *MemTunnel) WaitBound(ctx context.Context) (net.Addr, error) {
+	select {
+	case <-m.bound:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bindErr != nil {
+		return nil, m.bindErr
+	}
+	return m.listener.Addr(), nil
+}
+
+// startError 启动失败的原因
+func (m *MemTunnel) startError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bindErr
+}
@@ -0,0 +1,85 @@
+package tunnel
+
+// TunnelBuilder 以链式调用的方式构建隧道配置，如
+// NewTunnelBuilder().SSH().Via("bastion:22").As("root", WithPassword("secret")).To("10.0.0.5:5432").ListenOn(":15432").Build()
+// 链式调用中的第一个错误在Config或Build时返回
+type TunnelBuilder struct {
+	config TunnelConfig
+	err    error
+}
+
+// NewTunnelBuilder 创建隧道配置的构建器
+func NewTunnelBuilder() *TunnelBuilder {
+	return &TunnelBuilder{}
+}
+
+// Protocol 隧道协议
+func (b *TunnelBuilder) Protocol(protocol string) *TunnelBuilder {
+	b.config.Protocol = protocol
+	return b
+}
+
+// SSH 使用ssh隧道
+func (b *TunnelBuilder) SSH() *TunnelBuilder {
+	return b.Protocol(ProtocolSSH)
+}
+
+// Via 隧道的地址，提供多个地址时按顺序故障切换
+func (b *TunnelBuilder) Via(endpoints ...string) *TunnelBuilder {
+	if len(endpoints) == 1 {
+		b.config.TunnelEndpoint = endpoints[0]
+	} else {
+		b.config.TunnelEndpoints = endpoints
+	}
+	return b
+}
+
+// As 隧道认证的账号，auth为认证方式，如WithPassword、WithAuthKeyFile
+func (b *TunnelBuilder) As(username string, auth Option) *TunnelBuilder {
+	b.config.Username = username
+	return b.With(auth)
+}
+
+// To 透过隧道后最终要连接的端点，未指定协议时为http
+func (b *TunnelBuilder) To(endpoint string) *TunnelBuilder {
+	return b.With(WithRemote(endpoint))
+}
+
+// ListenOn 本地监听的地址
+func (b *TunnelBuilder) ListenOn(addr string) *TunnelBuilder {
+	b.config.LocalAddr = addr
+	return b
+}
+
+// With 设置其他的选项
+func (b *TunnelBuilder) With(opts ...Option) *TunnelBuilder {
+	for _, opt := range opts {
+		if b.err != nil {
+			return b
+		}
+		b.err = opt(&b.config)
+	}
+	return b
+}
+
+// Config 返回检查过的隧道配置
+func (b *TunnelBuilder) Config() (*TunnelConfig, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	config := b.config
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Build 按构建的配置创建隧道，创建后需要以协程的方式调用Start
+func (b *TunnelBuilder) Build() (Tunnel, error) {
+	config, err := b.Config()
+	if err != nil {
+		return nil, err
+	}
+	tunnelFactoryFunc, _ := lookupFactory(config.Protocol)
+	return tunnelFactoryFunc(config)
+}
@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	defaultChannelPoolIdleTimeout = 60 * time.Second // 默认预先建立的连接的最长空闲时间
+	channelPoolRetryInterval      = 5 * time.Second  // 预先建立连接失败后重试的间隔
+)
+
+// pooledChannel 预先通过隧道建立的远端连接
+type pooledChannel struct {
+	conn   net.Conn
+	client *ssh.Client
+	opened time.Time
+}
+
+// channelPool 预先通过共用的ssh连接建立到远端地址的连接，新的本地连接直接使用，省去打开ssh通道的往返时间
+type channelPool struct {
+	mu          sync.Mutex
+	target      string
+	size        int
+	idleTimeout time.Duration
+	channels    []pooledChannel
+	refill      chan struct{} // 取走连接后通知补充
+}
+
+func newChannelPool(target string, size int, idleTimeout time.Duration) *channelPool {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultChannelPoolIdleTimeout
+	}
+	return &channelPool{target: target, size: size, idleTimeout: idleTimeout, refill: make(chan struct{}, 1)}
+}
+
+// take 取出最近建立的连接，没有可用的连接时返回false
+func (p *channelPool) take(alive func(client *ssh.Client) bool) (pooledChannel, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.channels) > 0 {
+		last := len(p.channels) - 1
+		channel := p.channels[last]
+		p.channels = p.channels[:last]
+		if alive(channel.client) && time.Since(channel.opened) < p.idleTimeout {
+			p.notifyRefill()
+			return channel, true
+		}
+		channel.conn.Close()
+	}
+	p.notifyRefill()
+	return pooledChannel{}, false
+}
+
+// notifyRefill 通知补充连接，调用方需要持有锁
+func (p *channelPool) notifyRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// expire 关闭空闲超过idleTimeout的连接，远端可能已经关闭了这些连接
+func (p *channelPool) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	kept := p.channels[:0]
+	for _, channel := range p.channels {
+		if time.Since(channel.opened) < p.idleTimeout {
+			kept = append(kept, channel)
+			continue
+		}
+		channel.conn.Close()
+	}
+	p.channels = kept
+}
+
+// missing 需要补充的连接数
+func (p *channelPool) missing() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size - len(p.channels)
+}
+
+func (p *channelPool) put(channel pooledChannel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 较早建立的连接排在前面，take时优先使用最近建立的连接
+	p.channels = append(p.channels, channel)
+}
+
+func (p *channelPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, channel := range p.channels {
+		channel.conn.Close()
+	}
+	p.channels = nil
+}
+
+// runChannelPool 保持预先建立的连接数量，直到隧道停止
+func (s *SshTunnel) runChannelPool() {
+	p := s.channelPool
+	for {
+		p.expire()
+		wait := p.idleTimeout / 2
+		if err := s.fillChannelPool(); err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error pre-opening channel to %s: %s", p.target, err.Error()))
+			wait = channelPoolRetryInterval
+		}
+		select {
+		case <-s.stopped:
+			p.close()
+			return
+		case <-p.refill:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// fillChannelPool 补充预先建立的连接
+func (s *SshTunnel) fillChannelPool() error {
+	p := s.channelPool
+	for i := p.missing(); i > 0; i-- {
+		remoteConn, serverConn, err := s.dialRemote(p.target, nil)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-s.stopped:
+			remoteConn.Close()
+			return nil
+		default:
+		}
+		p.put(pooledChannel{conn: remoteConn, client: serverConn, opened: time.Now()})
+	}
+	return nil
+}
+
+// takePooledChannel 使用预先建立的到target的连接，没有可用的连接时返回false
+func (s *SshTunnel) takePooledChannel(target string, latency *ConnLatency) (net.Conn, *ssh.Client, bool) {
+	if s.channelPool == nil || s.channelPool.target != target {
+		return nil, nil, false
+	}
+	channel, ok := s.channelPool.take(s.isSharedClient)
+	if !ok {
+		return nil, nil, false
+	}
+	s.connLog.Infof("[*] Using pre-opened channel to final endpoint")
+	if latency != nil {
+		latency.Setup = time.Since(latency.Accepted)
+	}
+	return channel.conn, channel.client, true
+}
+
+// isSharedClient 判断ssh连接是否为当前共用的连接，断开的连接会被清除
+func (s *SshTunnel) isSharedClient(client *ssh.Client) bool {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	return s.shared.client == client
+}
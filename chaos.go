@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+var errChaosReset = errors.New("connection reset by chaos injection")
+
+// ChaosConfig 故障注入的配置，用于测试应用在隧道质量变差时的重试逻辑，不应在生产环境中使用
+type ChaosConfig struct {
+	Latency      time.Duration // 每次读写增加的延迟
+	Jitter       time.Duration // 延迟的随机抖动，实际延迟在Latency到Latency+Jitter之间
+	ResetRate    float64       // 每次读写时连接被重置的概率，如0.01
+	Bandwidth    int           // 每个连接每个方向每秒最多转发的字节数，为0时不限制
+	DropInterval time.Duration // 隧道连接(如ssh连接)建立后经过该时间被断开，为0时不断开
+}
+
+// chaosConn 按故障注入的配置读写的连接
+type chaosConn struct {
+	net.Conn
+	config *ChaosConfig
+}
+
+// withChaos 为连接注入故障，config为空时返回原始的连接
+func withChaos(conn net.Conn, config *ChaosConfig) net.Conn {
+	if config == nil {
+		return conn
+	}
+	return &chaosConn{Conn: conn, config: config}
+}
+
+func (c *chaosConn) Read(p []byte) (int, error) {
+	if c.config.Bandwidth > 0 && len(p) > c.config.Bandwidth {
+		p = p[:c.config.Bandwidth]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if injectErr := c.inject(n); injectErr != nil {
+			return 0, injectErr
+		}
+	}
+	return n, err
+}
+
+func (c *chaosConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if c.config.Bandwidth > 0 && len(chunk) > c.config.Bandwidth {
+			chunk = chunk[:c.config.Bandwidth]
+		}
+		if err := c.inject(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// inject 按配置延迟、限速或重置连接
+func (c *chaosConn) inject(size int) error {
+	if c.config.ResetRate > 0 && rand.Float64() < c.config.ResetRate {
+		if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		c.Conn.Close()
+		return errChaosReset
+	}
+	delay := c.config.Latency
+	if c.config.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(c.config.Jitter)))
+	}
+	if c.config.Bandwidth > 0 {
+		delay += time.Duration(size) * time.Second / time.Duration(c.config.Bandwidth)
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return nil
+}
+
+// scheduleChaosDrop 按配置在一段时间后断开隧道连接
+func scheduleChaosDrop(config *ChaosConfig, closeFunc func() error) {
+	if config == nil || config.DropInterval <= 0 {
+		return
+	}
+	time.AfterFunc(config.DropInterval, func() {
+		closeFunc()
+	})
+}
@@ -0,0 +1,46 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RemoteCheck CheckRemote的结果
+type RemoteCheck struct {
+	Target  string        // 检查的远端地址
+	Latency time.Duration // 通过隧道建立到远端地址的连接的耗时，需要时包括建立ssh连接的耗时
+}
+
+// CheckRemote 通过隧道连接远端地址后立即关闭，检查远端地址是否可达，不需要发送实际的流量
+// 有多个远端地址时检查第一个地址，失败时返回的结果中包括失败前的耗时
+func (s *SshTunnel) CheckRemote(ctx context.Context) (RemoteCheck, error) {
+	check := RemoteCheck{Target: s.remoteEndpoint}
+	if s.balancer != nil {
+		check.Target = s.balancer.targets[0]
+	}
+	if s.forwardOnly {
+		return check, errors.New("tunnel has no remote endpoint")
+	}
+	start := time.Now()
+	conn, err := s.DialContext(ctx, "tcp", check.Target)
+	check.Latency = time.Since(start)
+	if err != nil {
+		return check, err
+	}
+	conn.Close()
+	return check, nil
+}
+
+// CheckRemote 连接内存中的远端监听后立即关闭，检查远端监听是否可用
+func (m *MemTunnel) CheckRemote(ctx context.Context) (RemoteCheck, error) {
+	check := RemoteCheck{Target: m.remoteEndpoint}
+	start := time.Now()
+	conn, err := m.dial(ctx, m.remoteEndpoint)
+	check.Latency = time.Since(start)
+	if err != nil {
+		return check, err
+	}
+	conn.Close()
+	return check, nil
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// concurrencyGauge 正在运行的数量，达到告警阈值时触发一次告警，降到阈值以下后才会再次告警
+type concurrencyGauge struct {
+	name   string
+	active atomic.Int64
+	warned atomic.Bool
+}
+
+// inc 数量加一，返回是否刚达到告警阈值
+func (g *concurrencyGauge) inc(threshold int) bool {
+	active := g.active.Add(1)
+	return threshold > 0 && active >= int64(threshold) && g.warned.CompareAndSwap(false, true)
+}
+
+func (g *concurrencyGauge) dec(threshold int) {
+	if g.active.Add(-1) < int64(threshold) {
+		g.warned.Store(false)
+	}
+}
+
+// forwarderStarted 转发协程开始运行
+func (s *SshTunnel) forwarderStarted() {
+	if s.forwarders.inc(s.warnThreshold) {
+		s.warnConcurrency(&s.forwarders)
+	}
+}
+
+func (s *SshTunnel) forwarderFinished() {
+	s.forwarders.dec(s.warnThreshold)
+}
+
+// trackChannel 统计通过ssh连接client打开的通道，返回的连接第一次关闭时通道数减一，并且不再需要在隧道停止时关闭
+func (s *SshTunnel) trackChannel(conn net.Conn, client *ssh.Client) net.Conn {
+	if s.channels.inc(s.warnThreshold) {
+		s.warnConcurrency(&s.channels)
+	}
+	s.channelOpened(client)
+	tracked := &trackedConn{Conn: conn}
+	tracked.onClose = func() {
+		s.channels.dec(s.warnThreshold)
+		s.channelClosed(client)
+		s.untrack(tracked)
+	}
+	return tracked
+}
+
+// warnConcurrency 数量达到告警阈值，可能存在泄漏或者并发失控
+func (s *SshTunnel) warnConcurrency(gauge *concurrencyGauge) {
+	message := fmt.Sprintf("%d active %s reached the warning threshold %d", gauge.active.Load(), gauge.name, s.warnThreshold)
+	s.log.Warnf("[!] %s", message)
+	s.emit(Event{Type: EventHighConcurrency, Message: message})
+}
+
+// trackedConn 第一次关闭时回调
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
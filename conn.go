@@ -0,0 +1,38 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// copyBuffers 转发连接时复用的缓冲区，避免每个连接的每个方向分配32KB
+var copyBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, 32<<10)
+	return &buf
+}}
+
+// setCloseBehavior 设置连接关闭时的行为
+// resetOnClose为true时，关闭连接会直接发送RST；否则lingerTimeout大于0时，关闭连接会最多等待lingerTimeout将未发送的数据发送完毕
+// 两者都未设置时保持系统默认的FIN关闭行为
+func setCloseBehavior(conn net.Conn, resetOnClose bool, lingerTimeout time.Duration) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if resetOnClose {
+		return tcpConn.SetLinger(0)
+	}
+	if lingerTimeout > 0 {
+		seconds := int(lingerTimeout / time.Second)
+		if seconds == 0 {
+			seconds = 1
+		}
+		return tcpConn.SetLinger(seconds)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 从CredentialProvider获取凭据的默认超时时间，配置了DialTimeout时使用DialTimeout
+var defaultCredentialTimeout = 30 * time.Second
+
+// credentialHTTPClient 未设置HTTPClient时凭据提供者使用的客户端，避免凭据服务无响应时一直等待
+var credentialHTTPClient = &http.Client{Timeout: defaultCredentialTimeout}
+
+// Credentials 隧道认证使用的凭据
+type Credentials struct {
+	Username    string
+	Password    string
+	PrivateKey  []byte // PEM格式的私钥
+	Certificate []byte // 私钥对应的ssh证书(authorized_keys格式)，如Vault SSH引擎签发的证书
+}
+
+// CredentialProvider 凭据的提供者，每次建立ssh连接时获取凭据，避免在TunnelConfig中保存明文的凭据
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// loadKeyFile 读取私钥文件，同目录下存在OpenSSH约定的<文件名>-cert.pub证书时使用证书认证
+func loadKeyFile(path, passphrase string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private key file failed, err: %w", err)
+	}
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private key file %s failed, err: %w", path, err)
+	}
+	certificate, err := os.ReadFile(path + "-cert.pub")
+	if err != nil {
+		return signer, nil
+	}
+	return newCertSigner(certificate, signer)
+}
+
+// newCertSigner 使用ssh证书包装私钥
+func newCertSigner(certificate []byte, signer ssh.Signer) (ssh.Signer, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate failed, err: %w", err)
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("certificate is not an ssh certificate")
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate signer failed, err: %w", err)
+	}
+	return certSigner, nil
+}
+
+// authMethods 根据凭据构建ssh的认证方式，私钥优先于密码
+func (c *Credentials) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if len(c.PrivateKey) > 0 {
+		signer, err := ssh.ParsePrivateKey(c.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key failed, err: %w", err)
+		}
+		if len(c.Certificate) > 0 {
+			if signer, err = newCertSigner(c.Certificate, signer); err != nil {
+				return nil, err
+			}
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no password or private key in credentials")
+	}
+	return methods, nil
+}
+
+// clientConfig 返回建立ssh连接使用的配置，配置了CredentialProvider时每次重新获取凭据，最多等待DialTimeout
+func (s *SshTunnel) clientConfig() (*ssh.ClientConfig, error) {
+	s.configMu.RLock()
+	baseConfig, credentialProvider := s.config, s.credentialProvider
+	s.configMu.RUnlock()
+	if credentialProvider == nil {
+		return baseConfig, nil
+	}
+	timeout := defaultCredentialTimeout
+	if baseConfig.Timeout > 0 {
+		timeout = baseConfig.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	credentials, err := credentialProvider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get tunnel credentials failed, err: %w", err)
+	}
+	authMethods, err := credentials.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	config := *baseConfig
+	if credentials.Username != "" {
+		config.User = credentials.Username
+	}
+	config.Auth = s.withAuthMethods(authMethods)
+	return &config, nil
+}
+
+// withAuthMethods 在methods前加上调用方通过AuthMethods注入的认证方式
+func (s *SshTunnel) withAuthMethods(methods []ssh.AuthMethod) []ssh.AuthMethod {
+	if len(s.authMethods) == 0 {
+		return methods
+	}
+	return append(append([]ssh.AuthMethod{}, s.authMethods...), methods...)
+}
+
+// UpdateCredentials 更新运行中隧道的凭据，已经建立的连接不受影响，之后新建立的ssh连接使用新的凭据
+// 配置了CredentialProvider时，更新后不再从CredentialProvider获取凭据
+func (s *SshTunnel) UpdateCredentials(credentials Credentials) error {
+	authMethods, err := credentials.authMethods()
+	if err != nil {
+		return err
+	}
+	s.configMu.Lock()
+	config := *s.config
+	if credentials.Username != "" {
+		config.User = credentials.Username
+	}
+	config.Auth = s.withAuthMethods(authMethods)
+	s.config = &config
+	s.credentialProvider = nil
+	s.sshUsername = config.User
+	s.sshPassword = credentials.Password
+	s.configMu.Unlock()
+
+	s.log.Infof("[*] Tunnel credentials rotated")
+	s.emit(Event{Type: EventCredentialRotated, Message: fmt.Sprintf("credentials of user %s rotated", config.User)})
+	return nil
+}
+
+// parseSecretCredentials 解析secret中保存的凭据
+// secret为json对象时读取其中的username、password、private_key字段，否则整个secret作为密码
+func parseSecretCredentials(secret []byte, credentials *Credentials) error {
+	trimmed := bytes.TrimSpace(secret)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		credentials.Password = string(secret)
+		return nil
+	}
+	fields := struct {
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		PrivateKey string `json:"private_key"`
+	}{}
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return fmt.Errorf("parse secret failed, err: %w", err)
+	}
+	if fields.Username != "" {
+		credentials.Username = fields.Username
+	}
+	credentials.Password = fields.Password
+	credentials.PrivateKey = []byte(fields.PrivateKey)
+	return nil
+}
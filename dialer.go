@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+)
+
+// DialFunc 通过隧道建立连接的函数，与net.Dialer.DialContext的签名相同，可以直接用于各种客户端库
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialContext 调用f，使DialFunc满足各种客户端库中的ContextDialer接口
+func (f DialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// sshTunnelConn 通过ssh隧道建立的连接，关闭时同时关闭对应的ssh连接，共用ssh连接时不关闭
+type sshTunnelConn struct {
+	net.Conn
+	serverConn *ssh.Client
+	tunnel     *SshTunnel
+}
+
+func (c *sshTunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.tunnel.releaseClient(c.serverConn)
+	return err
+}
+
+// Dialer 返回直接通过ssh隧道连接远端地址的DialFunc，不经过本地监听的端口
+func (s *SshTunnel) Dialer() DialFunc {
+	return s.DialContext
+}
+
+// DialContext 通过ssh隧道连接到addr，仅支持tcp
+func (s *SshTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("not supported network through ssh tunnel: %s", network)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	resultChan := make(chan dialResult, 1)
+	go func() {
+		defer s.recoverPanic(func(err error) { resultChan <- dialResult{err: err} })
+		remoteConn, serverConn, err := s.dialRemote(addr, nil)
+		if err != nil {
+			resultChan <- dialResult{err: err}
+			return
+		}
+		resultChan <- dialResult{conn: &sshTunnelConn{Conn: remoteConn, serverConn: serverConn, tunnel: s}}
+	}()
+
+	select {
+	case result := <-resultChan:
+		return result.conn, result.err
+	case <-ctx.Done():
+		// ssh的连接不支持取消，连接建立后直接关闭
+		go func() {
+			if result := <-resultChan; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
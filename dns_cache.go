@@ -0,0 +1,203 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	defaultDNSNegativeTTL = 5 * time.Second // 默认缓存解析失败的时间
+	dnsCacheIdleTTLs      = 10              // 超过该数量的TTL未使用的记录不再刷新并被清除
+)
+
+// dnsEntry 主机名的解析结果
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	err     error     // 解析失败并且没有旧的解析结果时的错误
+	expires time.Time // 解析结果过期的时间
+	used    time.Time // 最近一次使用的时间
+}
+
+// dnsCache 缓存隧道地址及远端地址的解析结果，在后台定期刷新，避免每个连接都要等待dns解析
+// 刷新失败时继续使用旧的解析结果，解析失败(没有旧的结果)时缓存negativeTTL，避免dns服务不稳定时每个连接都重试
+type dnsCache struct {
+	resolver    *net.Resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+func newDNSCache(resolver *net.Resolver, ttl, negativeTTL time.Duration) *dnsCache {
+	if negativeTTL <= 0 {
+		negativeTTL = defaultDNSNegativeTTL
+	}
+	return &dnsCache{resolver: resolver, ttl: ttl, negativeTTL: negativeTTL, entries: map[string]*dnsEntry{}}
+}
+
+// lookup 返回host的地址，缓存过期或不存在时同步解析
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok {
+		entry.used = now
+		if now.Before(entry.expires) {
+			c.mu.Unlock()
+			return entry.addrs, entry.err
+		}
+	}
+	c.mu.Unlock()
+	return c.resolve(ctx, host)
+}
+
+// resolve 解析host并更新缓存，失败时有旧的解析结果则继续使用
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[host]
+	if !ok {
+		entry = &dnsEntry{used: now}
+		c.entries[host] = entry
+	}
+	switch {
+	case err == nil:
+		entry.addrs, entry.err, entry.expires = addrs, nil, now.Add(c.ttl)
+	case len(entry.addrs) > 0:
+		// 继续使用旧的解析结果，下次刷新时重试
+		entry.expires = now.Add(c.negativeTTL)
+	default:
+		entry.err, entry.expires = err, now.Add(c.negativeTTL)
+	}
+	return entry.addrs, entry.err
+}
+
+// run 定期刷新即将过期的记录并清除长时间未使用的记录，直到stopped关闭
+func (c *dnsCache) run(stopped <-chan struct{}) {
+	ticker := time.NewTicker(c.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var hosts []string
+		c.mu.Lock()
+		for host, entry := range c.entries {
+			if now.Sub(entry.used) > time.Duration(dnsCacheIdleTTLs)*c.ttl {
+				delete(c.entries, host)
+				continue
+			}
+			// 在过期前刷新，使用方不需要等待解析
+			if entry.expires.Sub(now) < c.ttl/2 {
+				hosts = append(hosts, host)
+			}
+		}
+		c.mu.Unlock()
+		for _, host := range hosts {
+			c.resolve(context.Background(), host)
+		}
+	}
+}
+
+// dialTCP 使用缓存的解析结果连接addr，ipv4和ipv6地址都有时按Happy Eyeballs的方式在fallbackDelay后尝试另一种地址
+func (c *dnsCache) dialTCP(dialer *net.Dialer, addr string, fallbackDelay time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.Dial("tcp", addr)
+	}
+	ctx := context.Background()
+	if dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialer.Timeout)
+		defer cancel()
+	}
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	var primaries, fallbacks []string
+	for _, ip := range addrs {
+		target := net.JoinHostPort(ip.String(), port)
+		if len(primaries) == 0 || (ip.IP.To4() != nil) == (addrs[0].IP.To4() != nil) {
+			primaries = append(primaries, target)
+		} else {
+			fallbacks = append(fallbacks, target)
+		}
+	}
+	if len(primaries) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("no addresses for %s", host)}
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, 2)
+	dialSerial := func(targets []string) {
+		var lastErr error
+		for _, target := range targets {
+			conn, err := dialer.DialContext(ctx, "tcp", target)
+			if err == nil {
+				results <- dialResult{conn: conn}
+				return
+			}
+			lastErr = err
+		}
+		results <- dialResult{err: lastErr}
+	}
+	go dialSerial(primaries)
+	pending := 1
+	var fallbackTimer <-chan time.Time
+	if len(fallbacks) > 0 {
+		timer := time.NewTimer(fallbackDelay)
+		defer timer.Stop()
+		fallbackTimer = timer.C
+	}
+	var firstErr error
+	for {
+		select {
+		case <-fallbackTimer:
+			fallbackTimer = nil
+			pending++
+			go dialSerial(fallbacks)
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				if pending > 0 {
+					// 关闭另一种地址稍后建立的连接
+					go func(pending int) {
+						for ; pending > 0; pending-- {
+							if late := <-results; late.conn != nil {
+								late.conn.Close()
+							}
+						}
+					}(pending)
+				}
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if fallbackTimer != nil {
+				// 主要地址都失败时立即尝试另一种地址
+				fallbackTimer = nil
+				pending++
+				go dialSerial(fallbacks)
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
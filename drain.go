@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+)
+
+// connStarted 记录开始处理一个本地连接
+func (s *SshTunnel) connStarted() {
+	s.connMu.Lock()
+	s.activeConns++
+	s.stopIdleTimers()
+	s.connMu.Unlock()
+}
+
+// connFinished 记录一个本地连接处理结束，正在排空时报告进度，最后一个连接结束后通知Shutdown
+func (s *SshTunnel) connFinished() {
+	s.connMu.Lock()
+	s.activeConns--
+	remaining := s.activeConns
+	draining := s.drained != nil
+	if remaining == 0 && draining {
+		close(s.drained)
+		s.drained = nil
+	}
+	if remaining == 0 {
+		s.resetIdleTimers()
+	}
+	s.connMu.Unlock()
+	if draining {
+		s.reportDrainProgress(remaining)
+	}
+}
+
+// ActiveConns 获取正在处理的本地连接数，排空时即剩余的连接数
+func (s *SshTunnel) ActiveConns() int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.activeConns
+}
+
+// reportDrainProgress 报告排空的进度
+func (s *SshTunnel) reportDrainProgress(remaining int) {
+	message := fmt.Sprintf("draining tunnel, %d connections remaining", remaining)
+	s.log.Infof(fmt.Sprintf("[*] %s", message))
+	s.emit(Event{Type: EventDrainProgress, Message: message, Remaining: remaining})
+}
+
+// Shutdown 优雅地停止隧道，立即停止接收新的连接，等待已经建立的连接结束后再停止隧道
+// ctx结束时强制关闭剩余的连接并返回ctx的错误
+func (s *SshTunnel) Shutdown(ctx context.Context) error {
+	s.willClose.Store(true)
+	s.stateMu.Lock()
+	s.closeListeners()
+	s.stateMu.Unlock()
+
+	s.connMu.Lock()
+	// 已经有Shutdown在排空时共用同一个channel，保证所有调用方都能收到排空完成的通知
+	drained := s.drained
+	if drained == nil {
+		drained = make(chan struct{})
+		if s.activeConns == 0 {
+			close(drained)
+		} else {
+			s.drained = drained
+		}
+	}
+	remaining := s.activeConns
+	s.connMu.Unlock()
+	s.setState(StateDraining, nil)
+	s.reportDrainProgress(remaining)
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.log.Infof(fmt.Sprintf("[!] Drain deadline reached, force closing remaining connections: %s", err.Error()))
+	}
+	s.Stop()
+	return err
+}
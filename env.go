@@ -0,0 +1,165 @@
+package tunnel
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix ConfigFromEnv及ConfigsFromEnv使用的环境变量前缀
+const EnvPrefix = "GOTUNNEL"
+
+// WithEnv 按prefix_XXX形式的环境变量设置配置，如GOTUNNEL_ENDPOINT、GOTUNNEL_REMOTE，未设置的环境变量不影响原有的配置
+// 放在其他选项(如从配置文件读取的配置)之后时环境变量优先，便于在容器中覆盖部分配置，支持的环境变量(去掉前缀)见:
+//
+//	PROTOCOL、NAME、ID、ENDPOINT、ENDPOINTS(逗号分隔)、USERNAME、PASSWORD、PASSWORD_FILE(从文件读取密码，如docker secret)
+//	REMOTE(如tcp://10.0.0.5:5432)、REMOTE_ADDR、REMOTE_PORT、REMOTE_ADDRS、TUNNELED_PROTOCOL、LOCAL_ADDR、LOCAL_MODE
+//	AUTH_KEY_FILE、AUTH_KEY_PASSPHRASE、USE_AGENT、AGENT_SOCKET、HOST_KEY_POLICY、KNOWN_HOSTS_FILE、CONNECT_POLICY
+//	DIAL_TIMEOUT、LOG_LEVEL、QUIET、LABELS(如team=db,env=prod)、HEALTH_CHECK_INTERVAL、IDLE_SHUTDOWN、TRANSFER_QUOTA
+//
+// 时间使用time.ParseDuration的格式，如30s，布尔值使用strconv.ParseBool的格式
+func WithEnv(prefix string) Option {
+	return func(config *TunnelConfig) error {
+		env := envReader{prefix: prefix}
+		env.custom("PROTOCOL", func(v string) error { config.Protocol = strings.ToUpper(v); return nil })
+		env.string("NAME", &config.Name)
+		env.string("ID", &config.ID)
+		env.string("ENDPOINT", &config.TunnelEndpoint)
+		env.list("ENDPOINTS", &config.TunnelEndpoints)
+		env.string("USERNAME", &config.Username)
+		env.string("PASSWORD", &config.Password)
+		env.custom("PASSWORD_FILE", func(v string) error { return setEnvPasswordFile(config, v) })
+		env.custom("REMOTE", func(v string) error { return WithRemote(v)(config) })
+		env.string("REMOTE_ADDR", &config.RemoteAddr)
+		env.int("REMOTE_PORT", &config.RemotePort)
+		env.list("REMOTE_ADDRS", &config.RemoteAddrs)
+		env.string("TUNNELED_PROTOCOL", &config.TunneledProtocol)
+		env.string("LOCAL_ADDR", &config.LocalAddr)
+		env.string("LOCAL_MODE", &config.LocalMode)
+		env.string("AUTH_KEY_FILE", &config.AuthKeyFile)
+		env.string("AUTH_KEY_PASSPHRASE", &config.AuthKeyPassphrase)
+		env.bool("USE_AGENT", &config.UseAgent)
+		env.string("AGENT_SOCKET", &config.AgentSocket)
+		env.string("HOST_KEY_POLICY", &config.HostKeyPolicy)
+		env.string("KNOWN_HOSTS_FILE", &config.KnownHostsFile)
+		env.string("CONNECT_POLICY", &config.ConnectPolicy)
+		env.duration("DIAL_TIMEOUT", &config.DialTimeout)
+		env.string("LOG_LEVEL", &config.LogLevel)
+		env.bool("QUIET", &config.Quiet)
+		env.custom("LABELS", func(v string) error { return setEnvLabels(config, v) })
+		env.duration("HEALTH_CHECK_INTERVAL", &config.HealthCheckInterval)
+		env.duration("IDLE_SHUTDOWN", &config.IdleShutdown)
+		env.custom("TRANSFER_QUOTA", func(v string) (err error) {
+			config.TransferQuota, err = strconv.ParseInt(v, 10, 64)
+			return err
+		})
+		return env.err
+	}
+}
+
+// envReader 按顺序读取prefix_XXX形式的环境变量，未设置的环境变量被跳过，记录第一个无法解析的环境变量
+type envReader struct {
+	prefix string
+	err    error
+}
+
+// custom 环境变量已设置时调用set，set返回错误时记录为ConfigError
+func (r *envReader) custom(suffix string, set func(value string) error) {
+	name := r.prefix + "_" + suffix
+	value, ok := os.LookupEnv(name)
+	if !ok || r.err != nil {
+		return
+	}
+	if err := set(value); err != nil {
+		r.err = &ConfigError{Field: name, Value: value, Err: ErrBadOption}
+	}
+}
+
+func (r *envReader) string(suffix string, field *string) {
+	r.custom(suffix, func(v string) error { *field = v; return nil })
+}
+
+func (r *envReader) list(suffix string, field *[]string) {
+	r.custom(suffix, func(v string) error { *field = splitEnvList(v); return nil })
+}
+
+func (r *envReader) int(suffix string, field *int) {
+	r.custom(suffix, func(v string) (err error) { *field, err = strconv.Atoi(v); return err })
+}
+
+func (r *envReader) bool(suffix string, field *bool) {
+	r.custom(suffix, func(v string) (err error) { *field, err = strconv.ParseBool(v); return err })
+}
+
+func (r *envReader) duration(suffix string, field *time.Duration) {
+	r.custom(suffix, func(v string) (err error) { *field, err = time.ParseDuration(v); return err })
+}
+
+// ConfigFromEnv 按GOTUNNEL_XXX形式的环境变量创建配置，见WithEnv，base为配置文件等其他来源的配置，环境变量优先
+func ConfigFromEnv(base TunnelConfig) (TunnelConfig, error) {
+	base.Labels = copyLabels(base.Labels)
+	err := WithEnv(EnvPrefix)(&base)
+	return base, err
+}
+
+// ConfigsFromEnv 按环境变量创建多个隧道的配置，GOTUNNEL_TUNNELS为逗号分隔的隧道名称，如db,web
+// 每个隧道的配置先应用GOTUNNEL_XXX(所有隧道共用)，再应用GOTUNNEL_<名称>_XXX，如GOTUNNEL_DB_REMOTE，名称转换为大写
+// 未设置GOTUNNEL_NAME及GOTUNNEL_<名称>_NAME时隧道的名称为列表中的名称，未设置GOTUNNEL_TUNNELS时只返回一个配置
+func ConfigsFromEnv() ([]TunnelConfig, error) {
+	names := splitEnvList(os.Getenv(EnvPrefix + "_TUNNELS"))
+	if len(names) == 0 {
+		config, err := ConfigFromEnv(TunnelConfig{})
+		if err != nil {
+			return nil, err
+		}
+		return []TunnelConfig{config}, nil
+	}
+	configs := make([]TunnelConfig, 0, len(names))
+	for _, name := range names {
+		config, err := ConfigFromEnv(TunnelConfig{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		envName := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if err = WithEnv(EnvPrefix + "_" + envName)(&config); err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// setEnvPasswordFile 从文件读取密码，去掉末尾的换行
+func setEnvPasswordFile(config *TunnelConfig, path string) error {
+	password, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config.Password = strings.TrimRight(string(password), "\r\n")
+	return nil
+}
+
+// setEnvLabels 解析key=value形式、逗号分隔的标签，与已有的标签合并
+func setEnvLabels(config *TunnelConfig, value string) error {
+	labels := map[string]string{}
+	for _, pair := range splitEnvList(value) {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return ErrBadOption
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return WithLabels(labels)(config)
+}
+
+// splitEnvList 按逗号分隔，去掉空白及空的元素
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
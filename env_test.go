@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigsFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	sharedSecret := filepath.Join(dir, "shared")
+	dbSecret := filepath.Join(dir, "db")
+	if err := os.WriteFile(sharedSecret, []byte("shared-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbSecret, []byte("db-secret\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		want      []TunnelConfig
+		wantField string
+	}{
+		{name: "single tunnel without list", env: map[string]string{
+			"GOTUNNEL_PROTOCOL": "ssh",
+			"GOTUNNEL_ENDPOINT": "10.0.0.1:22",
+			"GOTUNNEL_USERNAME": "root",
+			"GOTUNNEL_REMOTE":   "tcp://10.0.0.5:5432",
+		}, want: []TunnelConfig{
+			{Protocol: ProtocolSSH, TunnelEndpoint: "10.0.0.1:22", Username: "root", RemoteAddr: "10.0.0.5", RemotePort: 5432, TunneledProtocol: "tcp"},
+		}},
+		{name: "tunnel list with per tunnel prefixes", env: map[string]string{
+			"GOTUNNEL_TUNNELS":          " db, web-api ,",
+			"GOTUNNEL_ENDPOINT":         "10.0.0.1:22",
+			"GOTUNNEL_USERNAME":         "root",
+			"GOTUNNEL_DB_REMOTE":        "tcp://10.0.0.5:5432",
+			"GOTUNNEL_DB_USERNAME":      "postgres",
+			"GOTUNNEL_WEB_API_REMOTE":   "http://10.0.0.6:8080",
+			"GOTUNNEL_WEB_API_NAME":     "api",
+			"GOTUNNEL_WEB_API_ENDPOINT": "10.0.0.2:22",
+		}, want: []TunnelConfig{
+			{Name: "db", TunnelEndpoint: "10.0.0.1:22", Username: "postgres", RemoteAddr: "10.0.0.5", RemotePort: 5432, TunneledProtocol: "tcp"},
+			{Name: "api", TunnelEndpoint: "10.0.0.2:22", Username: "root", RemoteAddr: "10.0.0.6", RemotePort: 8080, TunneledProtocol: "http"},
+		}},
+		{name: "password file", env: map[string]string{
+			"GOTUNNEL_TUNNELS":          "db,web",
+			"GOTUNNEL_PASSWORD_FILE":    sharedSecret,
+			"GOTUNNEL_DB_PASSWORD_FILE": dbSecret,
+		}, want: []TunnelConfig{
+			{Name: "db", Password: "db-secret"},
+			{Name: "web", Password: "shared-secret"},
+		}},
+		{name: "labels merge per tunnel", env: map[string]string{
+			"GOTUNNEL_TUNNELS":   "db,web",
+			"GOTUNNEL_LABELS":    "team=data, env=prod",
+			"GOTUNNEL_DB_LABELS": "env=dev,tier=storage",
+		}, want: []TunnelConfig{
+			{Name: "db", Labels: map[string]string{"team": "data", "env": "dev", "tier": "storage"}},
+			{Name: "web", Labels: map[string]string{"team": "data", "env": "prod"}},
+		}},
+		{name: "missing password file", env: map[string]string{
+			"GOTUNNEL_TUNNELS":          "db",
+			"GOTUNNEL_DB_PASSWORD_FILE": filepath.Join(dir, "missing"),
+		}, wantField: "GOTUNNEL_DB_PASSWORD_FILE"},
+		{name: "malformed labels", env: map[string]string{
+			"GOTUNNEL_LABELS": "team",
+		}, wantField: "GOTUNNEL_LABELS"},
+		{name: "malformed per tunnel port", env: map[string]string{
+			"GOTUNNEL_TUNNELS":        "db",
+			"GOTUNNEL_DB_REMOTE_PORT": "postgres",
+		}, wantField: "GOTUNNEL_DB_REMOTE_PORT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			configs, err := ConfigsFromEnv()
+			if tt.wantField != "" {
+				var configErr *ConfigError
+				if !errors.As(err, &configErr) || configErr.Field != tt.wantField || !errors.Is(err, ErrBadOption) {
+					t.Fatalf("ConfigsFromEnv error = %v, want %s ErrBadOption", err, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigsFromEnv: %v", err)
+			}
+			if !reflect.DeepEqual(configs, tt.want) {
+				t.Fatalf("ConfigsFromEnv = %+v, want %+v", configs, tt.want)
+			}
+		})
+	}
+}
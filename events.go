@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"net"
+	"time"
+)
+
+// TunnelState 隧道的状态
+type TunnelState string
+
+const (
+	StateStarting TunnelState = "starting" // 正在启动，本地端点还未监听
+	StateRunning  TunnelState = "running"  // 隧道及远端地址都可用
+	StateDegraded TunnelState = "degraded" // 隧道可用，但是通过隧道无法连接到远端地址
+	StateDown     TunnelState = "down"     // 无法连接到隧道(如ssh服务)
+	StateDraining TunnelState = "draining" // 正在停止，不再接收新的连接，等待已有的连接结束
+	StateStopped  TunnelState = "stopped"  // 隧道已经停止
+)
+
+// EventType 隧道事件的类型
+type EventType string
+
+const (
+	EventStateChanged        EventType = "state-changed"        // 隧道状态发生变化
+	EventHealthCheck         EventType = "health-check"         // 完成一次健康检查，Err不为空时表示检查失败
+	EventCredentialRotated   EventType = "credential-rotated"   // 隧道的凭据已经更新
+	EventCertificateExpiring EventType = "certificate-expiring" // 健康检查时发现远端的证书即将过期
+	EventConnLatency         EventType = "conn-latency"         // 转发连接收到远端第一个字节，Latency为连接建立各阶段的耗时
+	EventHighConcurrency     EventType = "high-concurrency"     // 转发协程或ssh通道的数量达到告警阈值
+	EventDrainProgress       EventType = "drain-progress"       // 排空时开始排空及每个连接结束时触发，Remaining为剩余的连接数
+	EventAcceptFailed        EventType = "accept-failed"        // 本地监听意外关闭或持续无法接受连接，隧道随后停止
+	EventPanic               EventType = "panic"                // 处理连接的协程发生panic，已经恢复并关闭了该连接，Err包装ErrPanic
+	EventRemoteListening     EventType = "remote-listening"     // 开始在ssh服务端监听，Addr为实际监听的地址(包括服务端分配的端口)
+	EventBanner              EventType = "banner"               // 收到ssh服务端在认证前发送的banner，Message为banner的内容
+	EventHostKeyChanged      EventType = "host-key-changed"     // ssh服务端的主机公钥与KnownHostsFile中的不一致，可能存在中间人攻击，连接被拒绝
+	EventIdleShutdown        EventType = "idle-shutdown"        // 本地连接数为0持续IdleShutdown，隧道随后停止
+	EventIdleDisconnect      EventType = "idle-disconnect"      // 本地连接数为0持续IdleDisconnect，共用的ssh连接已经退役，下一个本地连接时重新建立
+
+	EventTransferQuotaExceeded EventType = "transfer-quota-exceeded" // 转发的流量达到TransferQuota，之后的新连接被拒绝，Err为ErrTransferQuotaExceeded
+)
+
+// Event 隧道事件
+type Event struct {
+	Type      EventType
+	Tunnel    string            // 隧道的名称
+	TunnelID  string            // 隧道唯一的ID
+	Labels    map[string]string // 隧道的标签，不应修改
+	State     TunnelState       // 事件发生时隧道的状态
+	Message   string
+	Err       error
+	Remaining int          // 排空时剩余的本地连接数，只在EventDrainProgress中有效
+	Latency   *ConnLatency // 连接建立各阶段的耗时，只在EventConnLatency中有效
+	Addr      net.Addr     // 在ssh服务端实际监听的地址，只在EventRemoteListening中有效
+	Time      time.Time
+}
+
+// EventHandler 隧道事件的回调，会在隧道内部的协程中同步调用，不应阻塞
+type EventHandler func(event Event)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	logger "github.com/sirupsen/logrus"
+	"os/signal"
+	"syscall"
+	"tunnel"
+)
+
+// 通过ssh隧道向回显服务发送测试流量，输出往返时间及吞吐量，用于切换正式流量前检查链路质量
+// 目标地址需要回显收到的数据，如在远端执行socat tcp-listen:7,fork,reuseaddr exec:cat
+//
+//	go run ./example/bench -endpoint 10.50.122.50:22 -user root -password xxx -target 192.168.1.111:7 -bytes 67108864
+func main() {
+	endpoint := flag.String("endpoint", "10.50.122.50:22", "ssh隧道的地址")
+	user := flag.String("user", "root", "ssh认证的账号")
+	password := flag.String("password", "", "ssh认证的密码")
+	target := flag.String("target", "192.168.1.111:7", "回显服务的地址")
+	bytes := flag.Int64("bytes", 16<<20, "发送的数据量")
+	pings := flag.Int("pings", 10, "测量往返时间的次数")
+	flag.Parse()
+
+	tunnelInstance, err := tunnel.NewTunnel(tunnel.ProtocolSSH,
+		tunnel.WithTunnelEndpoint(*endpoint),
+		tunnel.WithAuth(*user, *password),
+		tunnel.WithRemote("tcp://"+*target),
+	)
+	if err != nil {
+		logger.Fatal("create tunnel failed, ", err.Error())
+	}
+	sshTunnel := tunnelInstance.(*tunnel.SshTunnel)
+
+	ctx, done := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer done()
+	result, err := sshTunnel.Benchmark(ctx, tunnel.BenchmarkOptions{Target: *target, Bytes: *bytes, Pings: *pings})
+	if err != nil {
+		logger.Fatal("benchmark failed, ", err.Error())
+	}
+	logger.Infof("benchmark result: %s", result)
+}
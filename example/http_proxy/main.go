@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	logger "github.com/sirupsen/logrus"
+	"tunnel"
+)
+
+// 以http代理的方式使用ssh隧道，本地会随机监听一个端口作为http代理，浏览器或curl把代理指向这个地址后，
+// 请求的目标地址(CONNECT或绝对路径的请求)都会通过10.50.122.50的ssh隧道访问，无需为每个目标地址单独配置隧道
+//
+// 示例:
+//
+//	go run ./example/http_proxy -endpoint 10.50.122.50:22 -user root -password xxx
+//	curl -x http://localhost:59205 http://192.168.1.111/
+
+func main() {
+	endpoint := flag.String("endpoint", "10.50.122.50:22", "ssh隧道的地址")
+	user := flag.String("user", "root", "ssh认证的账号")
+	password := flag.String("password", "dbapp#2023", "ssh认证的密码")
+	flag.Parse()
+
+	tunnelConfig := tunnel.TunnelConfig{
+		Protocol:         "SSH",
+		TunnelEndpoint:   *endpoint,
+		Username:         *user,
+		Password:         *password,
+		RemoteAddr:       "localhost", // http代理模式下目标地址由每个请求决定
+		RemotePort:       80,
+		TunneledProtocol: "http",
+		LocalMode:        tunnel.LocalModeHTTPProxy,
+	}
+
+	tunnelInstance, err := tunnel.FastStartTunnel(tunnelConfig)
+	if err != nil {
+		logger.Fatal("start tunnel failed, ", err.Error())
+	}
+	logger.Infof("local http proxy endpoint: %s", tunnelInstance.GetLocalEndpoint())
+
+	// 阻塞，收到退出的信号后等待已有的连接结束再停止隧道
+	if err = tunnel.Serve(context.Background(), tunnelInstance); err != nil {
+		logger.Fatal("tunnel stopped, ", err.Error())
+	}
+	logger.Infof("really stop tunnel!")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"tunnel"
+	"tunnel/tunneltest"
+)
+
+// 使用私钥而不是密码认证ssh隧道，示例在进程内启动tunneltest的ssh服务及echo服务，不需要真实的跳板机
+// 生成一个临时的ed25519私钥，ssh服务只接受该私钥对应的公钥，隧道通过AuthKeyFile读取私钥完成认证
+//
+// 示例:
+//
+//	go run ./example/key_auth
+//
+// 连接真实的跳板机时将AuthKeyFile设置为已有的私钥，如~/.ssh/id_ed25519，同目录下的<文件名>-cert.pub会作为证书一起使用
+
+func main() {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		logger.Fatal("generate key failed, ", err.Error())
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		logger.Fatal("marshal key failed, ", err.Error())
+	}
+	dir, err := os.MkdirTemp("", "key_auth")
+	if err != nil {
+		logger.Fatal("create temp dir failed, ", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		logger.Fatal("write key failed, ", err.Error())
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		logger.Fatal("parse public key failed, ", err.Error())
+	}
+	server, err := tunneltest.NewServer(sshPublicKey)
+	if err != nil {
+		logger.Fatal("start ssh server failed, ", err.Error())
+	}
+	defer server.Close()
+	echo, err := tunneltest.NewEchoTarget()
+	if err != nil {
+		logger.Fatal("start echo target failed, ", err.Error())
+	}
+	defer echo.Close()
+
+	tunnelConfig, err := server.TunnelConfig("tcp://" + echo.Addr)
+	if err != nil {
+		logger.Fatal("build tunnel config failed, ", err.Error())
+	}
+	// 只配置私钥，不配置密码
+	tunnelConfig.Password = ""
+	tunnelConfig.AuthKeyFile = keyFile
+	tunnelInstance, err := tunnel.FastStartTunnel(*tunnelConfig)
+	if err != nil {
+		logger.Fatal("start tunnel failed, ", err.Error())
+	}
+	defer tunnelInstance.Stop()
+
+	conn, err := net.Dial("tcp", tunnelInstance.LocalAddr().String())
+	if err != nil {
+		logger.Fatal("connect local endpoint failed, ", err.Error())
+	}
+	defer conn.Close()
+	io.WriteString(conn, "hello through key auth")
+	reply := make([]byte, len("hello through key auth"))
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		logger.Fatal("read reply failed, ", err.Error())
+	}
+	logger.Infof("echo reply: %s", reply)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	logger "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"tunnel"
+	"tunnel/tunneltest"
+)
+
+// 以http反向代理的方式访问远端的web服务，远端服务只认自己的域名或者会跳转到内部域名时，开启RewriteHTTP改写Host头部及跳转地址
+// 示例在进程内启动tunneltest的ssh服务及一个检查Host头部的http服务，本地端点可以像访问远端服务一样直接使用
+//
+// 示例:
+//
+//	go run ./example/reverse_proxy
+
+func main() {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		logger.Fatal("start ssh server failed, ", err.Error())
+	}
+	defer server.Close()
+	var targetAddr string
+	// 只接受自己的地址作为Host，并把/login跳转到自己的地址
+	target, err := tunneltest.NewHTTPTarget(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != targetAddr {
+			http.Error(w, "unknown host "+r.Host, http.StatusMisdirectedRequest)
+			return
+		}
+		if r.URL.Path == "/login" {
+			http.Redirect(w, r, "http://"+targetAddr+"/home", http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, "served %s for host %s", r.URL.Path, r.Host)
+	}))
+	if err != nil {
+		logger.Fatal("start http target failed, ", err.Error())
+	}
+	defer target.Close()
+	targetAddr = target.Addr
+
+	tunnelConfig, err := server.TunnelConfig("http://" + target.Addr)
+	if err != nil {
+		logger.Fatal("build tunnel config failed, ", err.Error())
+	}
+	tunnelConfig.RewriteHTTP = true
+	tunnelInstance, err := tunnel.FastStartTunnel(*tunnelConfig)
+	if err != nil {
+		logger.Fatal("start tunnel failed, ", err.Error())
+	}
+	defer tunnelInstance.Stop()
+
+	// 跳转被改写为本地端点，客户端跟随跳转后仍然经过隧道
+	resp, err := http.Get("http://" + tunnelInstance.LocalAddr().String() + "/login")
+	if err != nil {
+		logger.Fatal("request through reverse proxy failed, ", err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	logger.Infof("response through reverse proxy: %s %s (final url %s)", resp.Status, body, resp.Request.URL)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+	"io"
+	"net"
+	"net/http"
+	"tunnel"
+	"tunnel/tunneltest"
+)
+
+// 反向的动态转发(ssh -R 1080)，在ssh服务端监听SOCKS5，服务端一侧的程序通过该SOCKS5访问本地网络中的服务
+// 用于跳板机上的程序需要回连开发机或内网中只有本地能访问的服务，示例在进程内启动tunneltest的ssh服务及本地的http服务
+//
+// 示例:
+//
+//	go run ./example/reverse_socks
+//
+// 连接真实的跳板机后，在跳板机上执行curl --socks5-hostname 127.0.0.1:1080 http://<本地网络中的地址>/ 即可访问
+
+func main() {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		logger.Fatal("start ssh server failed, ", err.Error())
+	}
+	defer server.Close()
+	// 只有本地能访问的http服务
+	target, err := tunneltest.NewHTTPTarget(nil)
+	if err != nil {
+		logger.Fatal("start http target failed, ", err.Error())
+	}
+	defer target.Close()
+
+	tunnelConfig, err := server.TunnelConfig("http://" + target.Addr)
+	if err != nil {
+		logger.Fatal("build tunnel config failed, ", err.Error())
+	}
+	// 端口为0时由ssh服务端分配，开始监听后通过EventRemoteListening获取实际的地址
+	listening := make(chan net.Addr, 1)
+	tunnelConfig.RemoteSOCKSAddr = "127.0.0.1:0"
+	tunnelConfig.EventHandler = func(event tunnel.Event) {
+		if event.Type == tunnel.EventRemoteListening {
+			select {
+			case listening <- event.Addr:
+			default:
+			}
+		}
+	}
+	tunnelInstance, err := tunnel.FastStartTunnel(*tunnelConfig)
+	if err != nil {
+		logger.Fatal("start tunnel failed, ", err.Error())
+	}
+	defer tunnelInstance.Stop()
+	socksAddr := <-listening
+	logger.Infof("SOCKS5 listening on ssh server at %s", socksAddr)
+
+	// 模拟ssh服务端一侧的程序，通过SOCKS5访问本地的http服务
+	dialer, err := proxy.SOCKS5("tcp", socksAddr.String(), nil, proxy.Direct)
+	if err != nil {
+		logger.Fatal("create socks dialer failed, ", err.Error())
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + target.Addr + "/from-ssh-server")
+	if err != nil {
+		logger.Fatal("request through reverse socks failed, ", err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	logger.Infof("response through reverse socks: %s %s", resp.Status, body)
+}
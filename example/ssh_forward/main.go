@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	logger "github.com/sirupsen/logrus"
+	"tunnel"
+)
+
+// 创建一个ssh隧道，ssh隧道端点为10.50.122.50虚拟机，最终目标地址为里面的192.168.1.111，监听一个本地端口，如果有请求就通过隧道往最终的地址转发
+// 示例，在下列样例中，执行go run ./example/ssh_forward，会随机监听一个本地的端口比如，localhost:59205，访问这个地址的http请求，会先跟
+// 10.50.122.50:22建立ssh隧道，然后由10.50.122.50转发到192.168.1.111的80这个端口上的http服务
+//
+// 可以通过参数指定其他的隧道端点和目标地址，如:
+//
+//	go run ./example/ssh_forward -endpoint 10.50.122.50:22 -user root -password xxx -remote 192.168.1.111 -port 80
+
+func main() {
+	endpoint := flag.String("endpoint", "10.50.122.50:22", "ssh隧道的地址")
+	user := flag.String("user", "root", "ssh认证的账号")
+	password := flag.String("password", "dbapp#2023", "ssh认证的密码")
+	remoteAddr := flag.String("remote", "192.168.1.111", "透过隧道后最终要连接的地址")
+	remotePort := flag.Int("port", 80, "透过隧道后最终要连接的端口")
+	flag.Parse()
+
+	tunnelConfig := tunnel.TunnelConfig{
+		Protocol:         "SSH",
+		TunnelEndpoint:   *endpoint,
+		Username:         *user,
+		Password:         *password,
+		RemoteAddr:       *remoteAddr,
+		RemotePort:       *remotePort,
+		TunneledProtocol: "http",
+	}
+
+	// 快速启动一个隧道
+	tunnelInstance, err := tunnel.FastStartTunnel(tunnelConfig)
+	if err != nil {
+		logger.Fatal("start tunnel failed, ", err.Error())
+	}
+	logger.Infof("local tunnel endpoint: %s", tunnelInstance.GetLocalEndpoint())
+
+	// 阻塞，收到退出的信号后等待已有的连接结束再停止隧道
+	if err = tunnel.Serve(context.Background(), tunnelInstance); err != nil {
+		logger.Fatal("tunnel stopped, ", err.Error())
+	}
+	logger.Infof("really stop tunnel!")
+}
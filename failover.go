@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"fmt"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"time"
+)
+
+// 默认探测主隧道地址是否恢复的间隔
+var defaultFailbackInterval = 30 * time.Second
+
+// endpointFailover 在多个隧道地址之间故障切换，排在前面的地址优先使用
+// 切换到备用地址后会定期探测主地址，主地址恢复后切换回主地址
+type endpointFailover struct {
+	mu               sync.Mutex
+	endpoints        []string
+	active           int // 当前使用的隧道地址
+	failbackInterval time.Duration
+	resolver         *net.Resolver // 探测主地址时解析主机名
+	relay            *h2Relay      // 设置后通过HTTP/2中继探测主地址
+	hosts            staticHosts   // 探测主地址时在dns解析之前查找的主机名映射
+	dialer           DialFunc      // 设置后通过该DialFunc探测主地址
+	probing          bool          // 是否正在探测主地址
+	stopped          chan struct{} // 关闭后停止探测
+	log              logger.FieldLogger
+}
+
+func newEndpointFailover(endpoints []string, failbackInterval time.Duration, resolver *net.Resolver, log logger.FieldLogger) *endpointFailover {
+	if failbackInterval <= 0 {
+		failbackInterval = defaultFailbackInterval
+	}
+	return &endpointFailover{
+		endpoints:        endpoints,
+		failbackInterval: failbackInterval,
+		resolver:         resolver,
+		stopped:          make(chan struct{}),
+		log:              log,
+	}
+}
+
+// dial 从当前使用的隧道地址开始依次尝试，返回第一个成功建立的连接
+func (f *endpointFailover) dial(dialFunc func(endpoint string) (*ssh.Client, error)) (*ssh.Client, error) {
+	f.mu.Lock()
+	start := f.active
+	endpoints := f.endpoints
+	f.mu.Unlock()
+
+	var lastErr error
+	for i := range endpoints {
+		index := (start + i) % len(endpoints)
+		client, err := dialFunc(endpoints[index])
+		if err != nil {
+			f.log.Infof(fmt.Sprintf("[!] Error connecting to tunnel endpoint %s: %s", endpoints[index], err.Error()))
+			lastErr = err
+			continue
+		}
+		if index != start {
+			f.switchTo(endpoints[index])
+		}
+		return client, nil
+	}
+	return nil, lastErr
+}
+
+func (f *endpointFailover) switchTo(endpoint string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	index := f.indexOf(endpoint)
+	if index < 0 {
+		// 隧道地址列表已经更新
+		return
+	}
+	f.log.Infof(fmt.Sprintf("[*] Failing over to tunnel endpoint %s", endpoint))
+	f.active = index
+	if index != 0 && !f.probing {
+		f.probing = true
+		go f.probeFailback()
+	}
+}
+
+// probeFailback 定期探测主隧道地址，可以建立tcp连接时切换回主地址
+func (f *endpointFailover) probeFailback() {
+	ticker := time.NewTicker(f.failbackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopped:
+			return
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		primary := f.endpoints[0]
+		f.mu.Unlock()
+		var conn net.Conn
+		var err error
+		if f.relay != nil {
+			conn, err = f.relay.dial(primary, f.failbackInterval)
+		} else if f.dialer != nil {
+			conn, err = dialTimeout(f.dialer, f.hosts.rewrite(primary), f.failbackInterval)
+		} else {
+			dialer := net.Dialer{Timeout: f.failbackInterval, Resolver: f.resolver}
+			conn, err = dialer.Dial("tcp", f.hosts.rewrite(primary))
+		}
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		f.mu.Lock()
+		f.log.Infof(fmt.Sprintf("[*] Primary tunnel endpoint %s is reachable again, failing back", primary))
+		f.active = 0
+		f.probing = false
+		f.mu.Unlock()
+		return
+	}
+}
+
+// update 更新隧道地址列表，当前使用的地址仍然存在时继续使用
+func (f *endpointFailover) update(endpoints []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current := f.endpoints[f.active]
+	f.endpoints = endpoints
+	f.active = 0
+	if index := f.indexOf(current); index > 0 {
+		f.active = index
+		if !f.probing {
+			f.probing = true
+			go f.probeFailback()
+		}
+	}
+}
+
+// indexOf 查找隧道地址的位置，调用方需要持有锁
+func (f *endpointFailover) indexOf(endpoint string) int {
+	for i, e := range f.endpoints {
+		if e == endpoint {
+			return i
+		}
+	}
+	return -1
+}
+
+// current 当前使用的隧道地址
+func (f *endpointFailover) current() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.endpoints[f.active]
+}
+
+func (f *endpointFailover) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.stopped:
+	default:
+		close(f.stopped)
+	}
+}
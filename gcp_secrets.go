@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// gcp元数据服务获取默认服务账号token的地址
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCPSecretsCredentialProvider 从GCP Secret Manager获取隧道的凭据
+// secret的内容为json对象时读取其中的username、password、private_key字段，否则整个secret作为密码
+type GCPSecretsCredentialProvider struct {
+	Project  string // 项目的id或编号
+	Secret   string // secret的名称
+	Version  string // secret的版本，为空时为latest
+	Username string // secret中没有username时使用的账号
+
+	// TokenSource 获取访问GCP的OAuth2 access token，为空时从GCE/GKE的元数据服务获取默认服务账号的token
+	TokenSource func(ctx context.Context) (string, error)
+	HTTPClient  *http.Client // 为空时使用超时时间为30s的客户端
+}
+
+func (g *GCPSecretsCredentialProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	tokenSource := g.TokenSource
+	if tokenSource == nil {
+		tokenSource = g.metadataToken
+	}
+	token, err := tokenSource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get gcp access token failed, err: %w", err)
+	}
+
+	version := g.Version
+	if version == "" {
+		version = "latest"
+	}
+	url := fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s/versions/%s:access", g.Project, g.Secret, version)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	output := struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}{}
+	if err = g.getJSON(req, &output); err != nil {
+		return nil, fmt.Errorf("get gcp secret %s failed, err: %w", g.Secret, err)
+	}
+	credentials := &Credentials{Username: g.Username}
+	if err = parseSecretCredentials(output.Payload.Data, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// metadataToken 从元数据服务获取默认服务账号的access token
+func (g *GCPSecretsCredentialProvider) metadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err = g.getJSON(req, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("metadata server returned an empty token")
+	}
+	return token.AccessToken, nil
+}
+
+func (g *GCPSecretsCredentialProvider) getJSON(req *http.Request, output interface{}) error {
+	client := g.HTTPClient
+	if client == nil {
+		client = credentialHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status: %s, body: %s", resp.Status, string(body))
+	}
+	return json.Unmarshal(body, output)
+}
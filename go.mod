@@ -3,8 +3,16 @@ module tunnel
 go 1.22.3
 
 require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/pkg/sftp v1.13.7
 	github.com/sirupsen/logrus v1.9.3
-	golang.org/x/crypto v0.30.0
+	golang.org/x/crypto v0.33.0
+	golang.org/x/net v0.35.0
+	golang.org/x/sys v0.30.0
 )
 
-require golang.org/x/sys v0.28.0 // indirect
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,20 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+)
+
+// GRPCContextDialer 返回通过隧道建立连接的函数，签名与grpc.WithContextDialer的参数相同，使用方式:
+//
+//	conn, err := grpc.NewClient("passthrough:///10.0.0.5:50051",
+//		grpc.WithContextDialer(tunnel.GRPCContextDialer(t)),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))
+//
+// 使用passthrough:///的目标时，主机名会原样交给隧道，而不会先在本地通过dns解析
+func GRPCContextDialer(t Tunnel) func(ctx context.Context, addr string) (net.Conn, error) {
+	dial := t.Dialer()
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return dial(ctx, "tcp", addr)
+	}
+}
@@ -0,0 +1,135 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// h2RelayProtocol extended CONNECT请求中:protocol的值，即draft-ietf-httpbis-connect-tcp中的tcp代理
+const h2RelayProtocol = "connect-tcp"
+
+// 未配置DialTimeout时等待中继响应的时间
+var defaultH2RelayTimeout = 30 * time.Second
+
+// h2Relay 通过HTTP/2 extended CONNECT(RFC 8441)连接中继，每个连接为同一个HTTP/2连接上的一个流
+type h2Relay struct {
+	template  string
+	header    http.Header
+	transport *http2.Transport // net/http的Transport不支持extended CONNECT
+}
+
+// newH2Relay 创建中继的客户端，template为包含{target_host}及{target_port}的https地址
+func newH2Relay(template string, header http.Header, tlsConfig *tls.Config) *h2Relay {
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	}
+	return &h2Relay{
+		template:  template,
+		header:    header,
+		transport: &http2.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
+// checkH2Relay 检查中继的地址模板
+func checkH2Relay(template string) error {
+	u, err := url.Parse(template)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ErrBadOption
+	}
+	if !strings.Contains(template, "{target_host}") || !strings.Contains(template, "{target_port}") {
+		return ErrBadOption
+	}
+	return nil
+}
+
+// targetURL 将目标地址填入地址模板
+func (r *h2Relay) targetURL(target string) string {
+	host, port, _ := net.SplitHostPort(target)
+	return strings.NewReplacer("{target_host}", url.PathEscape(host), "{target_port}", port).Replace(r.template)
+}
+
+// dial 通过中继连接target，timeout内中继未响应时返回错误
+func (r *h2Relay) dial(target string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		timeout = defaultH2RelayTimeout
+	}
+	// 请求的ctx同时控制流的生命周期，只在握手阶段超时
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(timeout, cancel)
+	reader, writer := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, r.targetURL(target), reader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	req.Header.Set(":protocol", h2RelayProtocol)
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil || !timer.Stop() {
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+			err = context.DeadlineExceeded
+		}
+		return nil, fmt.Errorf("connect to %s through h2 relay failed, err: %w", target, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("connect to %s through h2 relay failed, status: %s", target, resp.Status)
+	}
+	return &h2StreamConn{
+		ReadCloser: resp.Body,
+		writer:     writer,
+		cancel:     cancel,
+		localAddr:  h2RelayAddr("local"),
+		remoteAddr: h2RelayAddr(target),
+	}, nil
+}
+
+// close 关闭空闲的HTTP/2连接，已经建立的流不受影响
+func (r *h2Relay) close() {
+	r.transport.CloseIdleConnections()
+}
+
+// h2RelayAddr 中继流的地址
+type h2RelayAddr string
+
+func (a h2RelayAddr) Network() string { return "h2-relay" }
+func (a h2RelayAddr) String() string  { return string(a) }
+
+// h2StreamConn 将HTTP/2的流作为net.Conn使用，读取响应的body，写入请求的body
+type h2StreamConn struct {
+	io.ReadCloser
+	writer     *io.PipeWriter
+	cancel     context.CancelFunc
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *h2StreamConn) Write(p []byte) (int, error) { return c.writer.Write(p) }
+
+func (c *h2StreamConn) Close() error {
+	c.writer.Close()
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func (c *h2StreamConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *h2StreamConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+// HTTP/2的流不支持超时
+func (c *h2StreamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *h2StreamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *h2StreamConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,85 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// handoffParentPidEnv 交接监听时传给新进程的环境变量，值为交接监听的进程id，用于代替systemd的LISTEN_PID
+const handoffParentPidEnv = "TUNNEL_LISTEN_PPID"
+
+// fileListener 可以获取底层文件描述符的监听，如*net.TCPListener、*net.UnixListener
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// HandOff 启动新的进程，并将监听按systemd socket激活的方式交给新进程，新进程以LocalAddr为systemd:<name>使用交接的监听
+// 交接的监听占用新进程从3开始的文件描述符，cmd.ExtraFiles中原有的文件排在其后
+// 新进程开始接收连接后，当前进程调用隧道的Stop即可，关闭当前进程中的监听不影响新进程
+func HandOff(cmd *exec.Cmd, listeners map[string]net.Listener) error {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		if name == "" || strings.Contains(name, ":") {
+			return fmt.Errorf("invalid listener name: %q", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return errors.New("no listener to hand off")
+	}
+	sort.Strings(names)
+
+	files := make([]*os.File, 0, len(names))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, name := range names {
+		listener, ok := listeners[name].(fileListener)
+		if !ok {
+			return fmt.Errorf("listener %s does not support hand off", name)
+		}
+		file, err := listener.File()
+		if err != nil {
+			return fmt.Errorf("get file of listener %s failed, err: %w", name, err)
+		}
+		files = append(files, file)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = make([]string, 0, len(env)+4)
+	for _, kv := range env {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", handoffParentPidEnv:
+			continue
+		}
+		cmd.Env = append(cmd.Env, kv)
+	}
+	cmd.Env = append(cmd.Env,
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		handoffParentPidEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+	cmd.ExtraFiles = append(files, cmd.ExtraFiles...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start new process failed, err: %w", err)
+	}
+	return nil
+}
+
+// Listener 获取隧道本地的监听，隧道未启动时为空，可以用于HandOff
+func (s *SshTunnel) Listener() net.Listener {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.listener
+}
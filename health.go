@@ -0,0 +1,163 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 默认的健康检查超时时间
+var defaultHealthCheckTimeout = 10 * time.Second
+
+// errTunnelDown 健康检查时无法连接到隧道
+var errTunnelDown = errors.New("tunnel endpoint is unreachable")
+
+// ErrRemoteCertificate 健康检查时远端的证书无效，如证书链不可信、主机名不匹配或已经过期
+var ErrRemoteCertificate = errors.New("remote certificate is invalid")
+
+// 远端证书在该时间内过期时触发EventCertificateExpiring
+var certificateExpiryWarning = 14 * 24 * time.Hour
+
+// runHealthCheck 定期通过隧道检查远端地址是否可用，直到隧道停止
+func (s *SshTunnel) runHealthCheck() {
+	ticker := time.NewTicker(s.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+		err := s.checkHealth()
+		switch {
+		case err == nil:
+			s.setState(StateRunning, nil)
+		case errors.Is(err, errTunnelDown):
+			s.setState(StateDown, err)
+		default:
+			s.setState(StateDegraded, err)
+		}
+		s.emit(Event{Type: EventHealthCheck, Err: err})
+	}
+}
+
+// checkHealth 通过隧道连接所有的远端地址，配置了HealthCheckPath时还会发送http GET请求
+// 共用ssh连接时在已经建立的连接上检查，不会每次建立新的连接
+func (s *SshTunnel) checkHealth() error {
+	serverConn, err := s.serverClient()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errTunnelDown, err.Error())
+	}
+	defer s.releaseClient(serverConn)
+	if err = s.pingServer(serverConn); err != nil {
+		// 关闭无响应的ssh连接，之后的本地连接重新建立连接
+		serverConn.Close()
+		return fmt.Errorf("%w: %s", errTunnelDown, err.Error())
+	}
+	s.channelOpened(serverConn)
+	defer s.channelClosed(serverConn)
+
+	targets := []string{s.remoteEndpoint}
+	if s.balancer != nil {
+		targets = s.balancer.targets
+	}
+	if s.forwardOnly {
+		targets = nil
+	}
+	for _, target := range s.forwards {
+		targets = append(targets, target)
+	}
+	for _, target := range targets {
+		remoteConn, err := s.dialThroughServer(serverConn, target)
+		if err != nil {
+			return fmt.Errorf("remote endpoint %s is unreachable: %w", target, err)
+		}
+		if s.healthCheckTLS {
+			if remoteConn, err = s.checkCertificate(remoteConn, target); err != nil {
+				return err
+			}
+		}
+		if s.healthCheckPath != "" {
+			err = s.checkHTTP(remoteConn, target)
+		}
+		// ssh连接不再随检查结束关闭，需要关闭每个远端连接的通道
+		remoteConn.Close()
+		if err != nil {
+			return fmt.Errorf("remote endpoint %s is unhealthy: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// pingServer 在ssh连接上发送keepalive请求，超过健康检查的超时时间未响应时认为连接已经断开
+func (s *SshTunnel) pingServer(serverConn *ssh.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := serverConn.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.healthCheckTimeout):
+		return errors.New("ssh server did not answer keepalive")
+	}
+}
+
+// checkCertificate 在远端连接上进行TLS握手并校验证书，返回TLS连接
+func (s *SshTunnel) checkCertificate(remoteConn net.Conn, target string) (net.Conn, error) {
+	tlsConn := tls.Client(remoteConn, s.remoteTLSConfig(s.remoteTLS, target))
+	ctx, cancel := context.WithTimeout(context.Background(), s.healthCheckTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		remoteConn.Close()
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return nil, fmt.Errorf("remote endpoint %s: %w: %s", target, ErrRemoteCertificate, certErr.Err.Error())
+		}
+		return nil, fmt.Errorf("remote endpoint %s TLS handshake failed: %w", target, err)
+	}
+	leaf := tlsConn.ConnectionState().PeerCertificates[0]
+	if remaining := time.Until(leaf.NotAfter); remaining < certificateExpiryWarning {
+		message := fmt.Sprintf("certificate of remote endpoint %s expires at %s", target, leaf.NotAfter.Format(time.RFC3339))
+		s.log.Warnf("[!] %s", message)
+		s.emit(Event{Type: EventCertificateExpiring, Message: message})
+	}
+	return tlsConn, nil
+}
+
+// checkHTTP 在已经建立的远端连接上发送http GET请求，5xx的响应认为远端不可用
+// 远端连接已经完成TLS握手时直接在其上发送https请求
+func (s *SshTunnel) checkHTTP(remoteConn net.Conn, target string) error {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return remoteConn, nil
+		},
+		// 证书的校验由HealthCheckTLS决定，不属于http检查的范围
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	if _, ok := remoteConn.(*tls.Conn); ok {
+		transport.DialTLSContext = transport.DialContext
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: s.healthCheckTimeout}
+	scheme := "http"
+	if s.tunneledProtocol == "https" {
+		scheme = "https"
+	}
+	resp, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, target, s.healthCheckPath))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
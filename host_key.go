@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 校验ssh服务端主机公钥的策略
+const (
+	HostKeyInsecure   = ""            // 不校验主机公钥，默认的行为
+	HostKeyTOFU       = "tofu"        // 首次连接时信任并将主机公钥保存到KnownHostsFile，之后公钥变化时拒绝连接
+	HostKeyKnownHosts = "known-hosts" // 只接受KnownHostsFile中已有的主机公钥
+	HostKeySSHFP      = "sshfp"       // 按主机名的SSHFP记录(RFC 4255)校验主机公钥，不需要known_hosts文件
+)
+
+// 主机公钥校验失败的原因，可以通过errors.Is判断
+var (
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+	ErrHostKeyUnknown  = errors.New("host key unknown")
+)
+
+// knownHostsVerifier 按known_hosts格式的文件校验主机公钥，每次校验时重新读取文件，外部对文件的修改立即生效
+type knownHostsVerifier struct {
+	mu   sync.Mutex // 保证读取及追加公钥的原子性
+	path string
+	tofu bool
+}
+
+// check 校验hostname的主机公钥，TOFU时未记录的主机公钥追加到文件中，此时saved为true
+func (v *knownHostsVerifier) check(hostname string, remote net.Addr, key ssh.PublicKey) (saved bool, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.tofu {
+		if err = ensureKnownHostsFile(v.path); err != nil {
+			return false, err
+		}
+	}
+	callback, err := knownhosts.New(v.path)
+	if err != nil {
+		return false, fmt.Errorf("read known hosts file failed, err: %w", err)
+	}
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return false, err
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	if len(keyErr.Want) > 0 {
+		return false, fmt.Errorf("%w: %s key of %s is %s, which differs from the key recorded in %s, possible man-in-the-middle attack",
+			ErrHostKeyMismatch, key.Type(), hostname, fingerprint, v.path)
+	}
+	if !v.tofu {
+		return false, fmt.Errorf("%w: %s key %s of %s not found in %s", ErrHostKeyUnknown, key.Type(), fingerprint, hostname, v.path)
+	}
+	return true, appendKnownHost(v.path, hostname, key)
+}
+
+// ensureKnownHostsFile 文件不存在时创建空文件
+func ensureKnownHostsFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create known hosts dir failed, err: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create known hosts file failed, err: %w", err)
+	}
+	return file.Close()
+}
+
+// appendKnownHost 将主机公钥追加到known_hosts格式的文件
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open known hosts file failed, err: %w", err)
+	}
+	_, err = fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("save host key to %s failed, err: %w", path, err)
+	}
+	return nil
+}
+
+// hostKeyCheck 校验主机公钥，saved表示TOFU时保存了新的公钥
+type hostKeyCheck func(hostname string, remote net.Addr, key ssh.PublicKey) (saved bool, err error)
+
+// newHostKeyCheck 按HostKeyPolicy创建主机公钥的校验
+func newHostKeyCheck(tunnelConfig *TunnelConfig) hostKeyCheck {
+	if tunnelConfig.HostKeyPolicy == HostKeySSHFP {
+		verifier := &sshfpVerifier{server: tunnelConfig.SSHFPResolver, requireDNSSEC: tunnelConfig.SSHFPRequireDNSSEC}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+			return false, verifier.check(hostname, key)
+		}
+	}
+	verifier := &knownHostsVerifier{path: tunnelConfig.KnownHostsFile, tofu: tunnelConfig.HostKeyPolicy == HostKeyTOFU}
+	return verifier.check
+}
+
+// hostKeyCallback 按HostKeyPolicy校验主机公钥，TOFU保存新的公钥时记录日志，公钥变化时触发EventHostKeyChanged
+func (s *SshTunnel) hostKeyCallback(check hostKeyCheck) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		saved, err := check(hostname, remote, key)
+		switch {
+		case err == nil:
+			if saved {
+				s.log.Infof(fmt.Sprintf("[*] Trusted %s key %s of %s on first use", key.Type(), ssh.FingerprintSHA256(key), hostname))
+			}
+		case errors.Is(err, ErrHostKeyMismatch):
+			s.log.Warnf(fmt.Sprintf("[!] %s", err.Error()))
+			s.emit(Event{Type: EventHostKeyChanged, Message: fmt.Sprintf("host key of %s changed", hostname), Err: err})
+		default:
+			s.log.Infof(fmt.Sprintf("[!] Error verifying host key of %s: %s", hostname, err.Error()))
+		}
+		return err
+	}
+}
@@ -0,0 +1,20 @@
+package tunnel
+
+import (
+	"net/http"
+)
+
+// NewTransport 返回通过隧道发送请求的http.Transport
+// 连接直接通过隧道建立，https请求会与最终的主机进行TLS握手并校验其证书，不经过本地监听的端口
+func NewTransport(t Tunnel) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 请求已经通过隧道发送，不再使用环境变量中的代理
+	transport.Proxy = nil
+	transport.DialContext = t.Dialer()
+	return transport
+}
+
+// NewHTTPClient 返回通过隧道发送请求的http.Client
+func NewHTTPClient(t Tunnel) *http.Client {
+	return &http.Client{Transport: NewTransport(t)}
+}
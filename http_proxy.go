@@ -0,0 +1,140 @@
+package tunnel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 代理请求中不应转发给目标服务的逐跳头部
+var hopByHopHeaders = []string{
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Keep-Alive",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// bufferedConn 读取时优先消费reader中已经缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// serveHTTPProxy 以http代理的方式处理本地连接
+// CONNECT请求会在隧道建立后直接转发双向的流量，绝对路径的请求(如GET http://host/path)会改写为普通请求后发往目标地址
+func (s *SshTunnel) serveHTTPProxy(localConn net.Conn, latency *ConnLatency) {
+	reader := bufio.NewReader(localConn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error reading http proxy request: %s", err.Error()))
+		localConn.Close()
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		s.serveConnectRequest(localConn, reader, req, latency)
+		return
+	}
+	if s.isPACRequest(req) {
+		s.servePAC(localConn, req)
+		return
+	}
+
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		writeProxyError(localConn, http.StatusBadRequest)
+		localConn.Close()
+		return
+	}
+	targetAddr := req.URL.Host
+	if req.URL.Port() == "" {
+		targetAddr = net.JoinHostPort(req.URL.Hostname(), strconv.Itoa(DefaultPorts["http"]))
+	}
+	remoteConn, serverConn, err := s.dialRoute(targetAddr, latency)
+	if err != nil {
+		writeProxyError(localConn, dialErrorStatus(err))
+		localConn.Close()
+		return
+	}
+
+	// 每个连接只代理一个请求，由目标服务在响应后关闭连接，避免客户端复用连接访问其他地址
+	for _, header := range hopByHopHeaders {
+		req.Header.Del(header)
+	}
+	req.Close = true
+	if err = req.Write(remoteConn); err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error writing http request to remote endpoint: %s", err.Error()))
+		writeProxyError(localConn, http.StatusBadGateway)
+		localConn.Close()
+		remoteConn.Close()
+		s.releaseClient(serverConn)
+		return
+	}
+	s.transfer(&bufferedConn{Conn: localConn, reader: reader}, remoteConn, serverConn, targetAddr, latency)
+}
+
+// serveConnect 以只支持CONNECT的http代理处理本地连接，适用于通过HTTPS_PROXY配置代理的工具
+// 其他请求返回405，不会以明文代理http请求
+func (s *SshTunnel) serveConnect(localConn net.Conn, latency *ConnLatency) {
+	reader := bufio.NewReader(localConn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error reading CONNECT request: %s", err.Error()))
+		localConn.Close()
+		return
+	}
+	if s.isPACRequest(req) {
+		s.servePAC(localConn, req)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		fmt.Fprint(localConn, "HTTP/1.1 405 Method Not Allowed\r\nAllow: CONNECT\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		localConn.Close()
+		return
+	}
+	s.serveConnectRequest(localConn, reader, req, latency)
+}
+
+// serveConnectRequest 通过隧道连接CONNECT请求的目标地址，成功后转发双向的流量
+func (s *SshTunnel) serveConnectRequest(localConn net.Conn, reader *bufio.Reader, req *http.Request, latency *ConnLatency) {
+	remoteConn, serverConn, err := s.dialRoute(req.Host, latency)
+	if err != nil {
+		writeProxyError(localConn, dialErrorStatus(err))
+		localConn.Close()
+		return
+	}
+	if _, err = fmt.Fprint(localConn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		localConn.Close()
+		remoteConn.Close()
+		s.releaseClient(serverConn)
+		return
+	}
+	s.transfer(&bufferedConn{Conn: localConn, reader: reader}, remoteConn, serverConn, req.Host, latency)
+}
+
+// dialErrorStatus 连接目标地址失败时返回给代理客户端的状态码，被路由规则拒绝时为403
+func dialErrorStatus(err error) int {
+	if errors.Is(err, errRouteDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// writeProxyError 向代理客户端返回错误的状态码
+func writeProxyError(conn net.Conn, statusCode int) {
+	statusText := http.StatusText(statusCode)
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		statusCode, statusText, len(statusText), strings.ToLower(statusText))
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 需要把远端地址改写为本地端点的响应头部
+var rewriteLocationHeaders = []string{"Location", "Content-Location"}
+
+// addrConn 使用指定的地址代替net.Pipe的地址，审计、抓包等记录的仍然是本地连接的地址
+type addrConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *addrConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+// rewriteHostPort 改写Host头部时使用的远端地址，默认端口时省略端口
+func rewriteHostPort(host string, port int) string {
+	if port == 0 || port == DefaultPorts["http"] {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// newHTTPRewriter 在本地连接和隧道之间改写http请求及响应，返回交给transfer转发的连接
+func (s *SshTunnel) newHTTPRewriter(localConn net.Conn) net.Conn {
+	inner, outer := net.Pipe()
+	go s.rewriteHTTP(localConn, inner)
+	return &addrConn{Conn: outer, localAddr: localConn.LocalAddr(), remoteAddr: localConn.RemoteAddr()}
+}
+
+// rewriteHTTP 逐个读取本地连接上的请求，将Host头部改写为远端地址，并将响应中指向远端地址的跳转改写为本地端点
+// 协议升级(如websocket)后直接转发双向的数据
+func (s *SshTunnel) rewriteHTTP(localConn, remoteConn net.Conn) {
+	defer s.recoverPanic(nil)
+	defer localConn.Close()
+	defer remoteConn.Close()
+	localReader := bufio.NewReader(localConn)
+	remoteReader := bufio.NewReader(remoteConn)
+	for {
+		req, err := http.ReadRequest(localReader)
+		if err != nil {
+			return
+		}
+		localHost := req.Host
+		req.Host = s.rewriteHost
+		for _, header := range []string{"Origin", "Referer"} {
+			if value := req.Header.Get(header); value != "" {
+				req.Header.Set(header, rewriteURLHost(value, localHost, "", s.rewriteHost))
+			}
+		}
+		if err = req.Write(remoteConn); err != nil {
+			return
+		}
+		resp, err := http.ReadResponse(remoteReader, req)
+		if err != nil {
+			return
+		}
+		for _, header := range rewriteLocationHeaders {
+			if value := resp.Header.Get(header); value != "" {
+				resp.Header.Set(header, rewriteURLHost(value, s.rewriteHost, s.localScheme(), localHost))
+			}
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			if err = resp.Write(localConn); err != nil {
+				return
+			}
+			go func() {
+				io.Copy(remoteConn, localReader)
+				remoteConn.Close()
+			}()
+			io.Copy(localConn, remoteReader)
+			return
+		}
+		err = resp.Write(localConn)
+		resp.Body.Close()
+		if err != nil || resp.Close || req.Close {
+			return
+		}
+	}
+}
+
+// localScheme 本地端点的协议，本地端点使用TLS时为https
+func (s *SshTunnel) localScheme() string {
+	if s.localTLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// rewriteURLHost 绝对地址的主机为from时改写为to，scheme不为空时同时改写协议，其他地址不变
+func rewriteURLHost(rawURL, from, scheme, to string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || !sameHTTPHost(u, from) {
+		return rawURL
+	}
+	if scheme != "" {
+		u.Scheme = scheme
+	}
+	u.Host = to
+	return u.String()
+}
+
+// sameHTTPHost 判断地址的主机及端口是否为host，未指定端口时使用协议默认的端口
+func sameHTTPHost(u *url.URL, host string) bool {
+	hostname, port, hasPort := splitHostPort(host)
+	if !hasPort {
+		port = strconv.Itoa(DefaultPorts["http"])
+	}
+	urlPort := u.Port()
+	if urlPort == "" {
+		urlPort = strconv.Itoa(DefaultPorts[strings.ToLower(u.Scheme)])
+	}
+	return strings.EqualFold(u.Hostname(), hostname) && urlPort == port
+}
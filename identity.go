@@ -0,0 +1,26 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// tunnelIdentity 隧道的名称及ID，未配置名称时使用隧道协议，未配置ID时随机生成
+func tunnelIdentity(tunnelConfig *TunnelConfig) (string, string) {
+	name := tunnelConfig.Name
+	if name == "" {
+		name = tunnelConfig.Protocol
+	}
+	id := tunnelConfig.ID
+	if id == "" {
+		id = newTunnelID()
+	}
+	return name, id
+}
+
+// newTunnelID 生成随机的隧道ID
+func newTunnelID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+)
+
+// startIdleTimers 开始计算空闲时间，本地连接数为0持续idleShutdown后停止隧道，持续idleDisconnect后断开共用的ssh连接
+func (s *SshTunnel) startIdleTimers() {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.idleShutdown > 0 {
+		s.idleTimer = time.AfterFunc(s.idleShutdown, s.idleExpired)
+	}
+	if s.idleDisconnect > 0 {
+		s.disconnectTimer = time.AfterFunc(s.idleDisconnect, s.disconnectIdle)
+	}
+	if s.activeConns > 0 {
+		s.stopIdleTimers()
+	}
+}
+
+// stopIdleTimers 有本地连接时停止计时，调用方需要持有connMu
+func (s *SshTunnel) stopIdleTimers() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.disconnectTimer != nil {
+		s.disconnectTimer.Stop()
+	}
+}
+
+// resetIdleTimers 最后一个本地连接结束后重新计时，调用方需要持有connMu
+func (s *SshTunnel) resetIdleTimers() {
+	if s.willClose.Load() {
+		return
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(s.idleShutdown)
+	}
+	if s.disconnectTimer != nil {
+		s.disconnectTimer.Reset(s.idleDisconnect)
+	}
+}
+
+// idle 是否没有本地连接并且隧道没有停止
+func (s *SshTunnel) idle() bool {
+	s.connMu.Lock()
+	active := s.activeConns
+	s.connMu.Unlock()
+	return active == 0 && !s.IsStopped()
+}
+
+// idleExpired 空闲超时后触发EventIdleShutdown并停止隧道，期间有新的连接时忽略
+func (s *SshTunnel) idleExpired() {
+	if !s.idle() {
+		return
+	}
+	message := fmt.Sprintf("no active connections for %s", s.idleShutdown)
+	s.log.Infof(fmt.Sprintf("[*] Stopping idle tunnel, %s", message))
+	s.emit(Event{Type: EventIdleShutdown, Message: message})
+	s.Stop()
+}
+
+// disconnectIdle 空闲超时后退役共用的ssh连接，本地监听保持不变，下一个本地连接时重新建立ssh连接
+// 通过Dialer等建立的通道不计入本地连接，退役的ssh连接在这些通道结束后关闭
+func (s *SshTunnel) disconnectIdle() {
+	if !s.idle() {
+		return
+	}
+	s.shared.mu.Lock()
+	client := s.shared.client
+	s.shared.mu.Unlock()
+	if client == nil {
+		return
+	}
+	s.retireClient(client, fmt.Sprintf("idle for %s", s.idleDisconnect))
+	s.emit(Event{Type: EventIdleDisconnect, Message: fmt.Sprintf("no active connections for %s", s.idleDisconnect)})
+}
@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+)
+
+// KafkaDialer 通过隧道连接kafka的broker，broker广播的地址可以通过AddressMap改写为隧道后可以访问的地址
+//
+// segmentio/kafka-go:
+//
+//	dialer := &kafka.Dialer{DialFunc: kafkaDialer.DialContext}
+//	transport := &kafka.Transport{Dial: kafkaDialer.DialContext}
+//
+// sarama:
+//
+//	config.Net.Proxy.Enable = true
+//	config.Net.Proxy.Dialer = kafkaDialer
+type KafkaDialer struct {
+	dial DialFunc
+	// AddressMap broker广播的地址到实际连接地址的映射，key可以是host:port或只有host(保留原端口)
+	AddressMap map[string]string
+}
+
+// NewKafkaDialer 创建通过隧道连接kafka的Dialer，addressMap可以为空
+func NewKafkaDialer(t Tunnel, addressMap map[string]string) *KafkaDialer {
+	return &KafkaDialer{dial: t.Dialer(), AddressMap: addressMap}
+}
+
+// DialContext 改写broker的地址后通过隧道建立连接
+func (d *KafkaDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.dial(ctx, network, d.rewrite(addr))
+}
+
+// Dial 满足golang.org/x/net/proxy.Dialer接口，用于sarama
+func (d *KafkaDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// rewrite 按AddressMap改写broker的地址，优先匹配host:port
+func (d *KafkaDialer) rewrite(addr string) string {
+	if target, ok := d.AddressMap[addr]; ok {
+		return target
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if target, ok := d.AddressMap[host]; ok {
+		if _, _, err := net.SplitHostPort(target); err == nil {
+			return target
+		}
+		return net.JoinHostPort(target, port)
+	}
+	return addr
+}
@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	logger "github.com/sirupsen/logrus"
+)
+
+// copyLabels 复制标签，避免调用方之后修改配置中的map
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
+
+// labelFields 将标签转换为日志的字段
+func labelFields(labels map[string]string) logger.Fields {
+	fields := make(logger.Fields, len(labels))
+	for key, value := range labels {
+		fields[key] = value
+	}
+	return fields
+}
+
+// Labels 获取隧道的标签
+func (s *SshTunnel) Labels() map[string]string {
+	return copyLabels(s.labels)
+}
+
+// Labels 获取隧道的标签
+func (m *MemTunnel) Labels() map[string]string {
+	return copyLabels(m.labels)
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"time"
+)
+
+// minRekeyThreshold golang.org/x/crypto/ssh允许的最小重新协商密钥的数据量
+const minRekeyThreshold = 256
+
+// scheduleRetire 记录共用的ssh连接上的通道，达到MaxConnectionLifetime后退役，调用方需要持有shared.mu
+// 配置了IdleDisconnect时同样需要记录通道，空闲时退役的连接在通道全部结束后关闭
+func (s *SshTunnel) scheduleRetire(client *ssh.Client) {
+	if s.maxLifetime <= 0 && s.idleDisconnect <= 0 {
+		return
+	}
+	if s.shared.channels == nil {
+		s.shared.channels = map[*ssh.Client]int{}
+		s.shared.retired = map[*ssh.Client]bool{}
+	}
+	s.shared.channels[client] = 0
+	if s.maxLifetime > 0 {
+		time.AfterFunc(s.maxLifetime, func() { s.retireClient(client, fmt.Sprintf("reached max lifetime %s", s.maxLifetime)) })
+	}
+}
+
+// retireClient 之后的本地连接使用新的ssh连接，旧连接上的通道全部结束后关闭旧连接，已经建立的转发不会中断
+// reason为退役的原因，记录在日志中
+func (s *SshTunnel) retireClient(client *ssh.Client, reason string) {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	open, ok := s.shared.channels[client]
+	if !ok {
+		// ssh连接已经断开
+		return
+	}
+	if s.shared.client == client {
+		s.shared.client = nil
+	}
+	s.log.Infof(fmt.Sprintf("[*] Shared ssh connection %s, retiring with %d channels remaining", reason, open))
+	if open == 0 {
+		delete(s.shared.channels, client)
+		client.Close()
+		return
+	}
+	s.shared.retired[client] = true
+}
+
+// channelOpened 记录共用的ssh连接上打开的通道
+func (s *SshTunnel) channelOpened(client *ssh.Client) {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	if _, ok := s.shared.channels[client]; ok {
+		s.shared.channels[client]++
+	}
+}
+
+// channelClosed 通道关闭，已经退役的ssh连接上的通道全部结束后关闭该连接
+func (s *SshTunnel) channelClosed(client *ssh.Client) {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	open, ok := s.shared.channels[client]
+	if !ok {
+		return
+	}
+	s.shared.channels[client] = open - 1
+	if open == 1 && s.shared.retired[client] {
+		delete(s.shared.channels, client)
+		delete(s.shared.retired, client)
+		client.Close()
+	}
+}
+
+// forgetClient ssh连接断开后不再记录其通道
+func (s *SshTunnel) forgetClient(client *ssh.Client) {
+	delete(s.shared.channels, client)
+	delete(s.shared.retired, client)
+}
@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// localListener 本地的监听，target为空时按隧道的配置确定远端地址
+type localListener struct {
+	net.Listener
+	addr   string // 配置的监听地址
+	target string
+}
+
+// listenLocal 监听额外的本地地址，unix:开头的地址监听unix socket
+func listenLocal(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenAll 监听所有的本地地址，第一个为隧道主要的监听，任意一个失败时关闭已经建立的监听
+func (s *SshTunnel) listenAll() ([]localListener, error) {
+	var listeners []localListener
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	if !s.forwardOnly {
+		listener, err := s.listen()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, localListener{Listener: listener, addr: s.localTunnelEndpoint})
+	}
+	for _, addr := range s.localAddrs {
+		listener, err := listenLocal(addr)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("listen on %s failed, err: %w", addr, err)
+		}
+		listeners = append(listeners, localListener{Listener: listener, addr: addr})
+	}
+	// 按地址排序，只有Forwards时第一个转发的监听作为隧道主要的监听
+	localAddrs := make([]string, 0, len(s.forwards))
+	for localAddr := range s.forwards {
+		localAddrs = append(localAddrs, localAddr)
+	}
+	sort.Strings(localAddrs)
+	for _, localAddr := range localAddrs {
+		listener, err := listenLocal(localAddr)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("listen on %s failed, err: %w", localAddr, err)
+		}
+		listeners = append(listeners, localListener{Listener: listener, addr: localAddr, target: s.forwards[localAddr]})
+	}
+	return listeners, nil
+}
+
+// closeListeners 关闭所有本地监听，调用方需要持有stateMu
+func (s *SshTunnel) closeListeners() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, listener := range s.localListeners {
+		listener.Close()
+	}
+}
+
+// ForwardAddrs Forwards中每个转发实际监听的地址，key为Forwards中配置的本地地址，隧道开始监听前为空
+func (s *SshTunnel) ForwardAddrs() map[string]net.Addr {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	addrs := map[string]net.Addr{}
+	for _, listener := range s.localListeners {
+		if listener.target != "" {
+			addrs[listener.addr] = listener.Addr()
+		}
+	}
+	return addrs
+}
+
+// forwardTo 将本地连接转发到指定的远端地址
+func (s *SshTunnel) forwardTo(localConn net.Conn, target string, latency *ConnLatency) {
+	remoteConn, serverConn, err := s.dialForward(target, latency)
+	if err != nil {
+		localConn.Close()
+		return
+	}
+	s.transfer(localConn, remoteConn, serverConn, target, latency)
+}
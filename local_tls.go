@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// 自签名证书的有效期
+var selfSignedValidity = 365 * 24 * time.Hour
+
+// newLocalTLSConfig 本地端点的TLS配置，优先使用LocalTLSConfig，其次是证书文件，都未配置时生成自签名证书
+func newLocalTLSConfig(tunnelConfig *TunnelConfig, localEndpoint string) (*tls.Config, error) {
+	if tunnelConfig.LocalTLSConfig != nil {
+		return tunnelConfig.LocalTLSConfig, nil
+	}
+	if tunnelConfig.LocalTLSCertFile != "" || tunnelConfig.LocalTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tunnelConfig.LocalTLSCertFile, tunnelConfig.LocalTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load local tls certificate failed, err: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+	host, _, _ := splitHostPort(localEndpoint)
+	cert, err := newSelfSignedCert(host)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// newSelfSignedCert 生成localhost及host的自签名证书
+func newSelfSignedCert(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate self-signed key failed, err: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate certificate serial failed, err: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "go-tunnel self-signed"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.IsUnspecified() && !ip.IsLoopback() {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		}
+	} else if host != "" && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("create self-signed certificate failed, err: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,48 @@
+package tunnel
+
+import (
+	logger "github.com/sirupsen/logrus"
+)
+
+// checkLogLevel 检查隧道的日志级别，为空时使用Logger本身的级别
+func checkLogLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	if _, err := logger.ParseLevel(level); err != nil {
+		return ErrBadOption
+	}
+	return nil
+}
+
+// withLogLevel 返回使用单独日志级别的日志，输出、格式及hook与log相同，不影响共用同一个Logger的其他隧道
+// quiet时只输出警告及错误，连接的详细信息可以通过Audit及EventHandler获取
+// log不是logrus的Logger或Entry时无法调整级别，直接返回log
+func withLogLevel(log logger.FieldLogger, level string, quiet bool) logger.FieldLogger {
+	if quiet {
+		level = logger.WarnLevel.String()
+	}
+	parsed, err := logger.ParseLevel(level)
+	if level == "" || err != nil {
+		return log
+	}
+	var base *logger.Logger
+	var fields logger.Fields
+	switch l := log.(type) {
+	case *logger.Logger:
+		base = l
+	case *logger.Entry:
+		base, fields = l.Logger, l.Data
+	default:
+		return log
+	}
+	leveled := &logger.Logger{
+		Out:          base.Out,
+		Hooks:        base.Hooks,
+		Formatter:    base.Formatter,
+		ReportCaller: base.ReportCaller,
+		Level:        parsed,
+		ExitFunc:     base.ExitFunc,
+	}
+	return leveled.WithFields(fields)
+}
@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	logger "github.com/sirupsen/logrus"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// sampledLogger 按调用位置采样Info日志，每个位置每rate次只输出一次，用于连接频率很高的隧道中每个连接的过程日志
+// 按位置分别计数，串行的连接中被采样的连接在每个位置的日志都会输出，其他级别的日志不采样
+type sampledLogger struct {
+	logger.FieldLogger
+	rate     uint64
+	counters sync.Map // 调用位置 -> *atomic.Uint64
+}
+
+// newSampledLogger rate不大于1时不采样，直接返回log
+func newSampledLogger(log logger.FieldLogger, rate int) logger.FieldLogger {
+	if rate <= 1 {
+		return log
+	}
+	return &sampledLogger{FieldLogger: log, rate: uint64(rate)}
+}
+
+func (l *sampledLogger) Infof(format string, args ...interface{}) {
+	var pc [1]uintptr
+	runtime.Callers(2, pc[:])
+	counter, ok := l.counters.Load(pc[0])
+	if !ok {
+		counter, _ = l.counters.LoadOrStore(pc[0], new(atomic.Uint64))
+	}
+	if (counter.(*atomic.Uint64).Add(1)-1)%l.rate == 0 {
+		l.FieldLogger.Infof(format, args...)
+	}
+}
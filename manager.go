@@ -0,0 +1,542 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	logger "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	windowCheckInterval = 30 * time.Second // 检查是否进入或离开激活时间窗口的间隔
+	managerRetryDelay   = 30 * time.Second // 窗口内启动隧道失败后重试的间隔
+)
+
+var (
+	// ErrTunnelNotFound 管理器中没有指定ID的隧道，或者隧道属于其他租户
+	ErrTunnelNotFound = errors.New("tunnel not found")
+	// ErrQuotaExceeded 租户的隧道数量达到配额
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// ManageOptions 管理器中隧道的时间限制，用于有时限的生产环境访问授权
+type ManageOptions struct {
+	// TTL 加入管理器后经过TTL自动停止并从管理器中移除，为0时不限制
+	TTL time.Duration
+	// Windows 隧道只在这些时间窗口内运行，离开窗口时停止，再次进入窗口时按原配置重新启动，为空时一直运行
+	Windows []ActiveWindow
+	// Location 时间窗口使用的时区，为空时使用time.Local
+	Location *time.Location
+	// Tenant 隧道所属的租户(如团队名)，受该租户配额的限制，见TunnelManager.SetQuota，为空时不属于任何租户
+	Tenant string
+}
+
+// TenantQuota 租户的配额
+type TenantQuota struct {
+	MaxTunnels int   // 租户最多同时管理的隧道数量(包括不在激活时间窗口内的隧道)，为0时不限制
+	Bandwidth  int64 // 租户所有隧道每秒最多转发的总字节数，为0时不限制
+}
+
+// ActiveWindow 隧道的激活时间窗口，如每周一至周五的09:00-18:00
+type ActiveWindow struct {
+	Days  [7]bool       // 按time.Weekday索引的生效日期，全部为false时每天生效
+	Start time.Duration // 开始时间相对0点的偏移
+	End   time.Duration // 结束时间相对0点的偏移，小于Start时窗口跨越0点，在第二天结束
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseActiveWindow 解析类似cron的时间窗口，形式为[日期] 开始-结束，如:
+//
+//	09:00-18:00
+//	Mon-Fri 09:00-18:00
+//	Sat,Sun 10:00-12:00
+//	* 22:00-06:00
+func ParseActiveWindow(window string) (ActiveWindow, error) {
+	var parsed ActiveWindow
+	fields := strings.Fields(window)
+	if len(fields) == 0 || len(fields) > 2 {
+		return parsed, &ConfigError{Field: "Windows", Value: window, Err: ErrBadOption}
+	}
+	if len(fields) == 2 && fields[0] != "*" {
+		for _, part := range strings.Split(strings.ToLower(fields[0]), ",") {
+			first, last, isRange := strings.Cut(part, "-")
+			from, fromOK := weekdayNames[first]
+			to, toOK := from, true
+			if isRange {
+				to, toOK = weekdayNames[last]
+			}
+			if !fromOK || !toOK {
+				return parsed, &ConfigError{Field: "Windows", Value: window, Err: ErrBadOption}
+			}
+			for day := from; ; day = (day + 1) % 7 {
+				parsed.Days[day] = true
+				if day == to {
+					break
+				}
+			}
+		}
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	var err error
+	if ok {
+		if parsed.Start, err = parseTimeOfDay(start); err == nil {
+			parsed.End, err = parseTimeOfDay(end)
+		}
+	}
+	if !ok || err != nil || parsed.Start == parsed.End {
+		return parsed, &ConfigError{Field: "Windows", Value: window, Err: ErrBadOption}
+	}
+	return parsed, nil
+}
+
+// parseTimeOfDay 解析HH:MM形式的时间，返回相对0点的偏移
+func parseTimeOfDay(value string) (time.Duration, error) {
+	hour, minute, ok := strings.Cut(value, ":")
+	h, err := strconv.Atoi(hour)
+	if err != nil || !ok || h < 0 || h > 24 {
+		return 0, ErrBadOption
+	}
+	m, err := strconv.Atoi(minute)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, ErrBadOption
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Contains 判断t是否在时间窗口内
+func (w ActiveWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.Start < w.End {
+		return w.onDay(t.Weekday()) && offset >= w.Start && offset < w.End
+	}
+	// 跨越0点的窗口，0点后的部分属于前一天开始的窗口
+	return (w.onDay(t.Weekday()) && offset >= w.Start) || (w.onDay((t.Weekday()+6)%7) && offset < w.End)
+}
+
+func (w ActiveWindow) onDay(day time.Weekday) bool {
+	return w.Days == [7]bool{} || w.Days[day]
+}
+
+// ManagedTunnel 管理器中隧道的信息
+type ManagedTunnel struct {
+	ID      string // 管理器中的ID，属于租户的隧道为"租户/隧道ID"，通过TenantManager获取时为隧道ID
+	Name    string
+	Tenant  string
+	Tunnel  Tunnel    // 正在运行的隧道，不在激活时间窗口内时为nil
+	Added   time.Time // 加入管理器的时间
+	Expires time.Time // TTL到期的时间，未配置TTL时为零值
+}
+
+// managedEntry 管理器中的一个隧道
+type managedEntry struct {
+	config  TunnelConfig
+	options ManageOptions
+	added   time.Time
+	tunnel  Tunnel
+	removed chan struct{} // 从管理器中移除时关闭
+}
+
+// TunnelManager 管理多个隧道的启动及停止，支持隧道的TTL、激活时间窗口及多租户，可以并发使用
+// TunnelManager的方法可以操作所有租户的隧道，提供给各租户的管理接口应使用Tenant返回的TenantManager
+type TunnelManager struct {
+	mu       sync.Mutex
+	entries  map[string]*managedEntry // key为managedID返回的ID
+	quotas   map[string]TenantQuota
+	limiters map[string]*BandwidthLimiter // 每个租户的所有隧道共用的限速器
+	log      logger.FieldLogger
+
+	saveMu     sync.Mutex                 // 保证状态文件按顺序写入
+	statePath  string                     // 保存隧道定义的状态文件，为空时不保存，见Persist
+	unrestored map[string]persistedTunnel // 恢复失败的隧道定义，保存状态时原样写回，key为managedID返回的ID
+}
+
+// NewTunnelManager 创建隧道管理器，log为空时使用logrus的标准日志
+func NewTunnelManager(log logger.FieldLogger) *TunnelManager {
+	if log == nil {
+		log = logger.StandardLogger()
+	}
+	return &TunnelManager{
+		entries:    map[string]*managedEntry{},
+		quotas:     map[string]TenantQuota{},
+		limiters:   map[string]*BandwidthLimiter{},
+		log:        log,
+		unrestored: map[string]persistedTunnel{},
+	}
+}
+
+// SetQuota 设置租户的配额，带宽的配额对租户正在运行的隧道立即生效，隧道数量的配额只影响之后加入的隧道
+func (m *TunnelManager) SetQuota(tenant string, quota TenantQuota) {
+	m.mu.Lock()
+	m.quotas[tenant] = quota
+	m.limiter(tenant).SetLimit(quota.Bandwidth)
+	m.mu.Unlock()
+	m.saveState()
+}
+
+// Quota 获取租户的配额
+func (m *TunnelManager) Quota(tenant string) TenantQuota {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.quotas[tenant]
+}
+
+// Tenant 返回只能操作该租户隧道的管理接口
+func (m *TunnelManager) Tenant(tenant string) *TenantManager {
+	return &TenantManager{manager: m, tenant: tenant}
+}
+
+// Start 将隧道加入管理器，在激活时间窗口内(或未配置窗口)时立即启动，返回管理器中的ID
+// 配置中未指定ID时生成一个，隧道离开窗口或自行停止后重新启动时ID保持不变
+// 隧道的ID只需要在同一个租户内唯一，属于租户的隧道在管理器中的ID为"租户/隧道ID"，租户名称不能包含/
+// 指定了租户时隧道使用租户共用的限速器(替换配置中的BandwidthLimiter)，租户的隧道数量达到配额时返回ErrQuotaExceeded
+func (m *TunnelManager) Start(config TunnelConfig, options ManageOptions) (string, error) {
+	return m.start(config, options, time.Now(), false)
+}
+
+// start 将隧道加入管理器，TTL从added开始计算
+// restoring为true时(从状态文件恢复)启动失败不返回错误，隧道仍然加入管理器，之后定期重试启动
+func (m *TunnelManager) start(config TunnelConfig, options ManageOptions, added time.Time, restoring bool) (string, error) {
+	if options.Location == nil {
+		options.Location = time.Local
+	}
+	if strings.Contains(options.Tenant, "/") {
+		return "", &ConfigError{Field: "Tenant", Value: options.Tenant, Err: ErrBadOption}
+	}
+	_, config.ID = tunnelIdentity(&config)
+	id := managedID(options.Tenant, config.ID)
+
+	// 启动隧道前先占用ID及配额，避免重复的ID启动第二个隧道，启动失败时再移除
+	m.mu.Lock()
+	err := m.checkQuota(options.Tenant)
+	if _, ok := m.entries[id]; ok {
+		err = fmt.Errorf("tunnel %s already managed", config.ID)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return "", err
+	}
+	if options.Tenant != "" {
+		config.BandwidthLimiter = m.limiter(options.Tenant)
+	}
+	entry := &managedEntry{config: config, options: options, added: added, removed: make(chan struct{})}
+	m.entries[id] = entry
+	m.mu.Unlock()
+
+	if entry.inWindow(time.Now()) {
+		tunnel, err := FastStartTunnel(config)
+		switch {
+		case err != nil && restoring:
+			m.log.Infof(fmt.Sprintf("[!] Error starting restored tunnel %s, will retry: %s", config.ID, err.Error()))
+		case err != nil:
+			m.remove(id, func(e *managedEntry) bool { return e == entry })
+			return "", err
+		case !m.setTunnel(entry, tunnel):
+			// 启动期间已经被移除
+			tunnel.Stop()
+			return "", fmt.Errorf("%w: %s", ErrTunnelNotFound, id)
+		}
+	}
+	// 重新加入恢复失败的隧道后替换保存的定义
+	m.mu.Lock()
+	delete(m.unrestored, id)
+	m.mu.Unlock()
+	go m.schedule(id, entry)
+	if !restoring {
+		m.warnUnsaved(id, entry)
+	}
+	m.saveState()
+	return id, nil
+}
+
+// managedID 隧道在管理器中的ID，不同租户的隧道可以使用相同的ID
+func managedID(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return tenant + "/" + id
+}
+
+// Get 获取正在运行的隧道，不在激活时间窗口内时返回false
+func (m *TunnelManager) Get(id string) (Tunnel, bool) {
+	return m.get(id, nil)
+}
+
+// List 获取管理器中所有的隧道，按加入的时间排列
+func (m *TunnelManager) List() []ManagedTunnel {
+	return m.list(nil)
+}
+
+// Stop 停止隧道并从管理器中移除
+func (m *TunnelManager) Stop(id string) error {
+	return m.remove(id, nil)
+}
+
+// StopAll 停止所有的隧道并清空管理器，同时丢弃恢复失败的隧道定义
+func (m *TunnelManager) StopAll() {
+	m.mu.Lock()
+	clear(m.unrestored)
+	m.mu.Unlock()
+	for _, managed := range m.List() {
+		m.Stop(managed.ID)
+	}
+	m.saveState()
+}
+
+// checkQuota 检查租户的隧道数量是否达到配额，调用时需要持有mu
+func (m *TunnelManager) checkQuota(tenant string) error {
+	quota := m.quotas[tenant]
+	if tenant == "" || quota.MaxTunnels <= 0 {
+		return nil
+	}
+	count := 0
+	for _, entry := range m.entries {
+		if entry.options.Tenant == tenant {
+			count++
+		}
+	}
+	if count >= quota.MaxTunnels {
+		return fmt.Errorf("%w: tenant %s already has %d tunnels", ErrQuotaExceeded, tenant, count)
+	}
+	return nil
+}
+
+// limiter 获取租户共用的限速器，不存在时按配额创建，调用时需要持有mu
+func (m *TunnelManager) limiter(tenant string) *BandwidthLimiter {
+	limiter, ok := m.limiters[tenant]
+	if !ok {
+		limiter = NewBandwidthLimiter(m.quotas[tenant].Bandwidth)
+		m.limiters[tenant] = limiter
+	}
+	return limiter
+}
+
+// get 获取正在运行的隧道，match不为空且返回false时视为不存在
+func (m *TunnelManager) get(id string, match func(*managedEntry) bool) (Tunnel, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[id]
+	if !ok || entry.tunnel == nil || (match != nil && !match(entry)) {
+		return nil, false
+	}
+	return entry.tunnel, true
+}
+
+// list 获取match返回true(或match为空时所有)的隧道，按加入的时间排列
+func (m *TunnelManager) list(match func(*managedEntry) bool) []ManagedTunnel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tunnels := make([]ManagedTunnel, 0, len(m.entries))
+	for id, entry := range m.entries {
+		if match == nil || match(entry) {
+			tunnels = append(tunnels, entry.info(id))
+		}
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Added.Before(tunnels[j].Added) })
+	return tunnels
+}
+
+// remove 停止隧道并从管理器中移除，match不为空且返回false时视为不存在
+func (m *TunnelManager) remove(id string, match func(*managedEntry) bool) error {
+	m.mu.Lock()
+	entry, ok := m.entries[id]
+	if ok && (match == nil || match(entry)) {
+		delete(m.entries, id)
+		close(entry.removed)
+	} else {
+		ok = false
+	}
+	tunnel := entry.current()
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTunnelNotFound, id)
+	}
+	if tunnel != nil {
+		tunnel.Stop()
+	}
+	m.saveState()
+	return nil
+}
+
+// schedule TTL到期时移除隧道，按激活时间窗口启动及停止隧道，直到隧道被移除
+// 隧道自行停止(如IdleShutdown或连接失败)时清除该隧道，仍在激活时间窗口内时在下次检查时重新启动
+func (m *TunnelManager) schedule(id string, entry *managedEntry) {
+	var expired <-chan time.Time
+	if entry.options.TTL > 0 {
+		timer := time.NewTimer(time.Until(entry.added.Add(entry.options.TTL)))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	ticker := time.NewTicker(windowCheckInterval)
+	defer ticker.Stop()
+	var retryAt time.Time
+	for {
+		var stopped <-chan struct{}
+		m.mu.Lock()
+		running := entry.tunnel
+		m.mu.Unlock()
+		if running != nil {
+			stopped = running.Done()
+		}
+		select {
+		case <-entry.removed:
+			return
+		case <-stopped:
+			if m.clearTunnel(entry, running) {
+				m.log.Infof(fmt.Sprintf("[!] Managed tunnel %s stopped unexpectedly, will restart in its active window", id))
+			}
+		case <-expired:
+			m.log.Infof(fmt.Sprintf("[*] Tunnel %s reached its TTL %s, stopping", id, entry.options.TTL))
+			m.remove(id, func(e *managedEntry) bool { return e == entry })
+			return
+		case now := <-ticker.C:
+			switch active := entry.inWindow(now); {
+			case active && running == nil && !now.Before(retryAt):
+				m.log.Infof(fmt.Sprintf("[*] Tunnel %s is in its active window but not running, starting", id))
+				tunnel, err := FastStartTunnel(entry.config)
+				if err != nil {
+					m.log.Infof(fmt.Sprintf("[!] Error starting tunnel %s in its active window: %s", id, err.Error()))
+					retryAt = now.Add(managerRetryDelay)
+					continue
+				}
+				if !m.setTunnel(entry, tunnel) {
+					tunnel.Stop()
+				}
+			case !active && running != nil:
+				m.log.Infof(fmt.Sprintf("[*] Tunnel %s left its active window, stopping", id))
+				m.setTunnel(entry, nil)
+				running.Stop()
+			}
+		}
+	}
+}
+
+// setTunnel 更新正在运行的隧道，隧道已经被移除时返回false
+func (m *TunnelManager) setTunnel(entry *managedEntry, tunnel Tunnel) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-entry.removed:
+		return false
+	default:
+	}
+	entry.tunnel = tunnel
+	return true
+}
+
+// clearTunnel 隧道仍然是正在运行的隧道且未被移除时清除该隧道，返回是否已清除
+func (m *TunnelManager) clearTunnel(entry *managedEntry, tunnel Tunnel) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-entry.removed:
+		return false
+	default:
+	}
+	if entry.tunnel != tunnel {
+		return false
+	}
+	entry.tunnel = nil
+	return true
+}
+
+func (e *managedEntry) current() Tunnel {
+	if e == nil {
+		return nil
+	}
+	return e.tunnel
+}
+
+// inWindow 判断t是否在任意一个激活时间窗口内，未配置窗口时总是为true
+func (e *managedEntry) inWindow(t time.Time) bool {
+	if len(e.options.Windows) == 0 {
+		return true
+	}
+	t = t.In(e.options.Location)
+	for _, window := range e.options.Windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *managedEntry) info(id string) ManagedTunnel {
+	info := ManagedTunnel{ID: id, Name: e.config.Name, Tenant: e.options.Tenant, Tunnel: e.tunnel, Added: e.added}
+	if info.Name == "" {
+		info.Name = e.config.Protocol
+	}
+	if e.options.TTL > 0 {
+		info.Expires = e.added.Add(e.options.TTL)
+	}
+	return info
+}
+
+// TenantManager 只能操作一个租户隧道的管理接口，用于多个团队共用一个隧道守护进程的情况
+// 其他租户的隧道对其不可见，操作时与不存在的隧道一样返回ErrTunnelNotFound
+type TenantManager struct {
+	manager *TunnelManager
+	tenant  string
+}
+
+// Start 将隧道作为该租户的隧道加入管理器，忽略options中的Tenant，返回隧道的ID，见TunnelManager.Start
+// ID只需要在该租户内唯一，其他租户使用相同的ID不影响该租户
+func (t *TenantManager) Start(config TunnelConfig, options ManageOptions) (string, error) {
+	options.Tenant = t.tenant
+	id, err := t.manager.Start(config, options)
+	return t.localID(id), err
+}
+
+// Get 获取该租户正在运行的隧道
+func (t *TenantManager) Get(id string) (Tunnel, bool) {
+	return t.manager.get(managedID(t.tenant, id), t.owns)
+}
+
+// List 获取该租户的所有隧道，按加入的时间排列
+func (t *TenantManager) List() []ManagedTunnel {
+	tunnels := t.manager.list(t.owns)
+	for i := range tunnels {
+		tunnels[i].ID = t.localID(tunnels[i].ID)
+	}
+	return tunnels
+}
+
+// Stop 停止该租户的隧道并从管理器中移除
+func (t *TenantManager) Stop(id string) error {
+	if err := t.manager.remove(managedID(t.tenant, id), t.owns); err != nil {
+		return fmt.Errorf("%w: %s", ErrTunnelNotFound, id)
+	}
+	return nil
+}
+
+// StopAll 停止该租户的所有隧道
+func (t *TenantManager) StopAll() {
+	for _, managed := range t.List() {
+		t.Stop(managed.ID)
+	}
+}
+
+// Quota 获取该租户的配额
+func (t *TenantManager) Quota() TenantQuota {
+	return t.manager.Quota(t.tenant)
+}
+
+// localID 去掉管理器中ID的租户前缀
+func (t *TenantManager) localID(id string) string {
+	if t.tenant == "" {
+		return id
+	}
+	return strings.TrimPrefix(id, t.tenant+"/")
+}
+
+func (t *TenantManager) owns(entry *managedEntry) bool {
+	return entry.options.Tenant == t.tenant
+}
@@ -0,0 +1,243 @@
+package tunnel
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseActiveWindow(t *testing.T) {
+	// 2024-01-01为周一
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name    string
+		window  string
+		inside  []time.Time
+		outside []time.Time
+	}{
+		{name: "every day", window: "09:00-18:00",
+			inside:  []time.Time{at(1, 9, 0), at(6, 17, 59), at(7, 12, 0)},
+			outside: []time.Time{at(1, 8, 59), at(1, 18, 0), at(7, 23, 0)}},
+		{name: "weekday range", window: "Mon-Fri 09:00-18:00",
+			inside:  []time.Time{at(1, 9, 0), at(5, 17, 59)},
+			outside: []time.Time{at(6, 12, 0), at(7, 12, 0), at(2, 18, 30)}},
+		{name: "day list", window: "Sat,Sun 10:00-12:00",
+			inside:  []time.Time{at(6, 10, 0), at(7, 11, 59)},
+			outside: []time.Time{at(5, 11, 0), at(8, 11, 0), at(6, 12, 0)}},
+		{name: "day range across week end", window: "fri-mon 10:00-12:00",
+			inside:  []time.Time{at(5, 10, 0), at(6, 11, 0), at(7, 11, 0), at(8, 11, 0)},
+			outside: []time.Time{at(2, 11, 0), at(3, 11, 0), at(4, 11, 0)}},
+		{name: "midnight wrap", window: "* 22:00-06:00",
+			inside:  []time.Time{at(1, 22, 0), at(1, 23, 59), at(2, 0, 0), at(2, 5, 59)},
+			outside: []time.Time{at(1, 6, 0), at(1, 12, 0), at(1, 21, 59)}},
+		{name: "midnight wrap belongs to start day", window: "Fri 22:00-06:00",
+			inside:  []time.Time{at(5, 23, 0), at(6, 5, 0)},
+			outside: []time.Time{at(5, 5, 0), at(6, 23, 0), at(4, 23, 0)}},
+		{name: "until end of day", window: "Sat 18:00-24:00",
+			inside:  []time.Time{at(6, 18, 0), at(6, 23, 59)},
+			outside: []time.Time{at(7, 0, 0), at(6, 17, 59)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := ParseActiveWindow(tt.window)
+			if err != nil {
+				t.Fatalf("ParseActiveWindow(%q): %v", tt.window, err)
+			}
+			for _, inside := range tt.inside {
+				if !window.Contains(inside) {
+					t.Errorf("Contains(%s) = false, want true", inside.Format("Mon 15:04"))
+				}
+			}
+			for _, outside := range tt.outside {
+				if window.Contains(outside) {
+					t.Errorf("Contains(%s) = true, want false", outside.Format("Mon 15:04"))
+				}
+			}
+		})
+	}
+}
+
+func TestParseActiveWindowErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		window string
+	}{
+		{name: "empty", window: ""},
+		{name: "too many fields", window: "Mon-Fri 09:00-18:00 UTC"},
+		{name: "missing end", window: "09:00"},
+		{name: "unknown day", window: "Funday 09:00-18:00"},
+		{name: "unknown range end", window: "Mon-Someday 09:00-18:00"},
+		{name: "hour out of range", window: "25:00-26:00"},
+		{name: "minute out of range", window: "09:60-18:00"},
+		{name: "past end of day", window: "24:30-01:00"},
+		{name: "without minutes", window: "9-17"},
+		{name: "empty window", window: "10:00-10:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseActiveWindow(tt.window)
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || configErr.Field != "Windows" || !errors.Is(err, ErrBadOption) {
+				t.Fatalf("ParseActiveWindow(%q) error = %v, want Windows ErrBadOption", tt.window, err)
+			}
+		})
+	}
+}
+
+func TestActiveWindowLocation(t *testing.T) {
+	window, err := ParseActiveWindow("Mon-Fri 09:00-18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 窗口按Location的本地时间判断，UTC+8的周一09:00为UTC的周一01:00
+	entry := &managedEntry{options: ManageOptions{
+		Windows:  []ActiveWindow{window},
+		Location: time.FixedZone("UTC+8", 8*60*60),
+	}}
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		{name: "start in location", now: time.Date(2024, time.January, 1, 1, 0, 0, 0, time.UTC), want: true},
+		{name: "before start in location", now: time.Date(2024, time.January, 1, 0, 59, 0, 0, time.UTC), want: false},
+		{name: "after end in location", now: time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC), want: false},
+		{name: "friday in location", now: time.Date(2024, time.January, 5, 9, 30, 0, 0, time.UTC), want: true},
+		{name: "saturday in location", now: time.Date(2024, time.January, 5, 17, 0, 0, 0, time.UTC), want: false},
+		{name: "utc sunday is early monday in location", now: time.Date(2024, time.January, 7, 23, 0, 0, 0, time.UTC), want: false},
+		{name: "monday morning in location", now: time.Date(2024, time.January, 8, 2, 0, 0, 0, time.UTC), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entry.inWindow(tt.now); got != tt.want {
+				t.Fatalf("inWindow(%s) = %v, want %v", tt.now.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
+
+// dormantOptions 返回激活时间窗口不包含当前时间的选项，加入管理器的隧道不会启动
+func dormantOptions(tenant string) ManageOptions {
+	now := time.Now().UTC()
+	offset := now.Sub(now.Truncate(24 * time.Hour)).Truncate(time.Minute)
+	window := ActiveWindow{Start: (offset + 2*time.Hour) % (24 * time.Hour), End: (offset + 3*time.Hour) % (24 * time.Hour)}
+	return ManageOptions{Windows: []ActiveWindow{window}, Location: time.UTC, Tenant: tenant}
+}
+
+func TestManagerTenants(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, m *TunnelManager)
+	}{
+		{name: "quota exceeded", run: func(t *testing.T, m *TunnelManager) {
+			config := validConfig()
+			m.SetQuota("team", TenantQuota{MaxTunnels: 2})
+			team := m.Tenant("team")
+			for _, id := range []string{"a", "b"} {
+				config.ID = id
+				if _, err := team.Start(config, dormantOptions("")); err != nil {
+					t.Fatalf("Start %s: %v", id, err)
+				}
+			}
+			config.ID = "c"
+			if _, err := team.Start(config, dormantOptions("")); !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("Start over quota error = %v, want ErrQuotaExceeded", err)
+			}
+			// 其他租户及不属于租户的隧道不受配额限制
+			if _, err := m.Tenant("other").Start(config, dormantOptions("")); err != nil {
+				t.Fatalf("Start for other tenant: %v", err)
+			}
+			if _, err := m.Start(config, dormantOptions("")); err != nil {
+				t.Fatalf("Start without tenant: %v", err)
+			}
+			if err := team.Stop("a"); err != nil {
+				t.Fatalf("Stop: %v", err)
+			}
+			if _, err := team.Start(config, dormantOptions("")); err != nil {
+				t.Fatalf("Start after Stop: %v", err)
+			}
+		}},
+		{name: "same id in two tenants", run: func(t *testing.T, m *TunnelManager) {
+			config := validConfig()
+			config.ID = "web"
+			for _, tenant := range []string{"a", "b"} {
+				id, err := m.Tenant(tenant).Start(config, dormantOptions(""))
+				if err != nil || id != "web" {
+					t.Fatalf("Start for %s = %q, %v, want web", tenant, id, err)
+				}
+			}
+			if _, err := m.Tenant("a").Start(config, dormantOptions("")); err == nil {
+				t.Fatal("Start with a managed ID in the same tenant succeeded")
+			}
+			var ids []string
+			for _, managed := range m.List() {
+				ids = append(ids, managed.ID)
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != "a/web,b/web" {
+				t.Fatalf("manager IDs = %v, want [a/web b/web]", ids)
+			}
+			if err := m.Tenant("a").Stop("web"); err != nil {
+				t.Fatalf("Stop: %v", err)
+			}
+			if list := m.Tenant("b").List(); len(list) != 1 || list[0].ID != "web" {
+				t.Fatalf("tenant b List = %+v, want web", list)
+			}
+			if err := m.Tenant("a").Stop("web"); !errors.Is(err, ErrTunnelNotFound) {
+				t.Fatalf("Stop twice error = %v, want ErrTunnelNotFound", err)
+			}
+		}},
+		{name: "shared limiter", run: func(t *testing.T, m *TunnelManager) {
+			config := validConfig()
+			m.SetQuota("team", TenantQuota{Bandwidth: 1000})
+			own := NewBandwidthLimiter(1)
+			config.BandwidthLimiter = own
+			for _, id := range []string{"a", "b"} {
+				config.ID = id
+				if _, err := m.Tenant("team").Start(config, dormantOptions("")); err != nil {
+					t.Fatalf("Start %s: %v", id, err)
+				}
+			}
+			config.ID = "c"
+			if _, err := m.Tenant("other").Start(config, dormantOptions("")); err != nil {
+				t.Fatalf("Start for other tenant: %v", err)
+			}
+			m.mu.Lock()
+			a, b, c := m.entries["team/a"].config.BandwidthLimiter, m.entries["team/b"].config.BandwidthLimiter, m.entries["other/c"].config.BandwidthLimiter
+			m.mu.Unlock()
+			if a == nil || a == own || a != b {
+				t.Fatal("tunnels of a tenant don't share the tenant limiter")
+			}
+			if c == a {
+				t.Fatal("tenants share a limiter")
+			}
+			m.SetQuota("team", TenantQuota{Bandwidth: 2000})
+			if a.Limit() != 2000 {
+				t.Fatalf("limit after SetQuota = %d, want 2000", a.Limit())
+			}
+		}},
+		{name: "tenant with slash", run: func(t *testing.T, m *TunnelManager) {
+			config := validConfig()
+			config.ID = "web"
+			_, err := m.Tenant("a/b").Start(config, dormantOptions(""))
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || configErr.Field != "Tenant" || !errors.Is(err, ErrBadOption) {
+				t.Fatalf("Start error = %v, want Tenant ErrBadOption", err)
+			}
+			if list := m.List(); len(list) != 0 {
+				t.Fatalf("List = %+v, want empty", list)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewTunnelManager(nil)
+			t.Cleanup(m.StopAll)
+			tt.run(t, m)
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProtocolMem 内存隧道的协议名
+const ProtocolMem = "MEM"
+
+var errMemConnRefused = errors.New("connection refused: no in-memory listener")
+
+// memListeners ListenMem创建的监听，key为监听的地址
+var (
+	memListenersMu sync.Mutex
+	memListeners   = map[string]*memListener{}
+)
+
+// memAddr 内存监听的地址
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// memListener 内存中的监听，连接通过net.Pipe建立
+type memListener struct {
+	addr      memAddr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenMem 在内存中监听addr(如db:5432)，MEM隧道连接到该地址时，连接通过net.Pipe交给该监听，不经过网络
+// 用于在单元测试中作为隧道的远端服务
+func ListenMem(addr string) (net.Listener, error) {
+	memListenersMu.Lock()
+	defer memListenersMu.Unlock()
+	if _, ok := memListeners[addr]; ok {
+		return nil, fmt.Errorf("listen mem %s failed, err: address already in use", addr)
+	}
+	listener := &memListener{addr: memAddr(addr), conns: make(chan net.Conn), closed: make(chan struct{})}
+	memListeners[addr] = listener
+	return listener, nil
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		memListenersMu.Lock()
+		if memListeners[string(l.addr)] == l {
+			delete(memListeners, string(l.addr))
+		}
+		memListenersMu.Unlock()
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
+
+// dialMem 连接到内存中的监听
+func dialMem(ctx context.Context, addr string) (net.Conn, error) {
+	memListenersMu.Lock()
+	listener, ok := memListeners[addr]
+	memListenersMu.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "mem", Addr: memAddr(addr), Err: errMemConnRefused}
+	}
+	clientConn, serverConn := net.Pipe()
+	select {
+	case listener.conns <- serverConn:
+		return clientConn, nil
+	case <-listener.closed:
+		clientConn.Close()
+		return nil, &net.OpError{Op: "dial", Net: "mem", Addr: memAddr(addr), Err: errMemConnRefused}
+	case <-ctx.Done():
+		clientConn.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// MemTunnel 内存隧道，本地端点的连接及Dialer建立的连接都转发到ListenMem创建的监听，不使用ssh
+// 用于对依赖Tunnel接口的代码进行单元测试
+type MemTunnel struct {
+	name           string
+	id             string
+	localEndpoint  string
+	remoteEndpoint string
+	protocol       string
+	listener       net.Listener
+	eventHandler   EventHandler
+	labels         map[string]string
+	chaos          *ChaosConfig
+	bandwidth      *BandwidthLimiter
+
+	mu          sync.Mutex
+	state       TunnelState
+	conns       map[net.Conn]struct{} // 正在转发的本地连接
+	activeConns int
+	drained     chan struct{}
+	bound       chan struct{} // 本地端点监听成功或失败后关闭
+	bindErr     error
+	lastErr     error // 最近一次导致状态变化的错误
+	lastErrAt   time.Time
+	stopped     chan struct{} // 隧道停止时关闭
+	stopOnce    sync.Once
+}
+
+func init() {
+	if err := Register(ProtocolMem, MemTunnelFactory, Capabilities{}); err != nil {
+		panic(err)
+	}
+}
+
+// MemTunnelFactory 内存隧道实现，远端地址为RemoteAddr:RemotePort对应的内存监听
+func MemTunnelFactory(tunnelConfig *TunnelConfig) (Tunnel, error) {
+	if tunnelConfig.RemoteAddr == "" {
+		return nil, &ConfigError{Field: "RemoteAddr", Err: ErrMissingEndpoint}
+	}
+	localEndpoint := tunnelConfig.LocalAddr
+	if tunnelConfig.Listener != nil {
+		localEndpoint = tunnelConfig.Listener.Addr().String()
+	} else if localEndpoint == "" {
+		localEndpoint = "127.0.0.1:0"
+	}
+	name, id := tunnelIdentity(tunnelConfig)
+	return &MemTunnel{
+		name:           name,
+		id:             id,
+		localEndpoint:  localEndpoint,
+		remoteEndpoint: net.JoinHostPort(tunnelConfig.RemoteAddr, strconv.Itoa(tunnelConfig.RemotePort)),
+		protocol:       tunnelConfig.TunneledProtocol,
+		listener:       tunnelConfig.Listener,
+		eventHandler:   tunnelConfig.EventHandler,
+		labels:         copyLabels(tunnelConfig.Labels),
+		chaos:          tunnelConfig.Chaos,
+		bandwidth:      tunnelConfig.BandwidthLimiter,
+		state:          StateStarting,
+		conns:          map[net.Conn]struct{}{},
+		bound:          make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}, nil
+}
+
+func (m *MemTunnel) GetName() string {
+	return m.name
+}
+
+func (m *MemTunnel) GetID() string {
+	return m.id
+}
+
+func (m *MemTunnel) GetLocalEndpoint() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("%s://%s", m.protocol, m.localEndpoint)
+}
+
+func (m *MemTunnel) GetRemoteEndpoint() string {
+	return fmt.Sprintf("%s://%s", m.protocol, m.remoteEndpoint)
+}
+
+// LocalAddr 本地实际监听的地址，隧道开始监听前为nil
+func (m *MemTunnel) LocalAddr() net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listener == nil {
+		return nil
+	}
+	return m.listener.Addr()
+}
+
+func (m *MemTunnel) RemoteTarget() *Endpoint {
+	return newTargetEndpoint(m.protocol, m.remoteEndpoint)
+}
+
+func (m *MemTunnel) GetState() TunnelState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *MemTunnel) setState(state TunnelState, err error) {
+	m.mu.Lock()
+	if err != nil && m.state != StateStopped {
+		m.lastErr, m.lastErrAt = err, time.Now()
+	}
+	if m.state == state || m.state == StateStopped {
+		m.mu.Unlock()
+		return
+	}
+	previous := m.state
+	m.state = state
+	m.mu.Unlock()
+	if m.eventHandler != nil {
+		m.eventHandler(Event{
+			Type:     EventStateChanged,
+			Tunnel:   m.name,
+			TunnelID: m.id,
+			Labels:   m.labels,
+			State:    state,
+			Message:  fmt.Sprintf("tunnel state changed from %s to %s", previous, state),
+			Err:      err,
+			Time:     time.Now(),
+		})
+	}
+}
+
+// Start 监听本地端点，未指定LocalAddr时监听127.0.0.1的随机端口，必须以协程的方式运行
+func (m *MemTunnel) Start(tunnelReady chan bool) {
+	listener := m.listener
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", m.localEndpoint); err != nil {
+			m.mu.Lock()
+			m.bindErr = err
+			close(m.bound)
+			m.mu.Unlock()
+			m.setState(StateStopped, err)
+			tunnelReady <- false
+			return
+		}
+	}
+	m.mu.Lock()
+	m.listener = listener
+	m.localEndpoint = listener.Addr().String()
+	close(m.bound)
+	if m.IsStopped() {
+		// Start之前已经调用了Stop
+		m.mu.Unlock()
+		listener.Close()
+		tunnelReady <- false
+		return
+	}
+	m.mu.Unlock()
+	m.setState(StateRunning, nil)
+	tunnelReady <- true
+
+	var backoff acceptBackoff
+	for {
+		localConn, err := listener.Accept()
+		if err != nil {
+			if m.GetState() != StateRunning || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay, _ := backoff.next()
+			time.Sleep(delay)
+			continue
+		}
+		backoff.reset()
+		m.mu.Lock()
+		if m.IsStopped() {
+			m.mu.Unlock()
+			localConn.Close()
+			return
+		}
+		m.conns[localConn] = struct{}{}
+		m.activeConns++
+		m.mu.Unlock()
+		go m.forward(localConn)
+	}
+}
+
+// forward 将本地连接转发到内存监听
+func (m *MemTunnel) forward(localConn net.Conn) {
+	defer m.recoverPanic(nil)
+	defer func() {
+		localConn.Close()
+		m.mu.Lock()
+		delete(m.conns, localConn)
+		m.activeConns--
+		if m.activeConns == 0 && m.drained != nil {
+			close(m.drained)
+			m.drained = nil
+		}
+		m.mu.Unlock()
+	}()
+	remoteConn, err := m.dial(context.Background(), m.remoteEndpoint)
+	if err != nil {
+		return
+	}
+	defer remoteConn.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(remoteConn, localConn)
+		remoteConn.Close()
+	}()
+	io.Copy(localConn, remoteConn)
+	localConn.Close()
+	<-done
+}
+
+// Dialer 返回直接连接内存监听的DialFunc，addr为ListenMem的地址
+func (m *MemTunnel) Dialer() DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return m.dial(ctx, addr)
+	}
+}
+
+// dial 连接内存监听，配置了故障注入时DropInterval后断开连接
+func (m *MemTunnel) dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := dialMem(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	scheduleChaosDrop(m.chaos, conn.Close)
+	return withBandwidthLimit(withChaos(conn, m.chaos), m.bandwidth), nil
+}
+
+// Stop 停止隧道并关闭所有的本地连接，可以多次及并发调用
+func (m *MemTunnel) Stop() {
+	m.stopOnce.Do(m.stop)
+}
+
+func (m *MemTunnel) stop() {
+	m.mu.Lock()
+	close(m.stopped)
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.mu.Unlock()
+	m.setState(StateStopped, nil)
+}
+
+// IsStopped 隧道是否已经停止
+func (m *MemTunnel) IsStopped() bool {
+	select {
+	case <-m.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done 返回隧道停止时关闭的channel
+func (m *MemTunnel) Done() <-chan struct{} {
+	return m.stopped
+}
+
+// Shutdown 停止接收新的连接，等待已有的连接结束，ctx结束时强制关闭
+func (m *MemTunnel) Shutdown(ctx context.Context) error {
+	m.setState(StateDraining, nil)
+	m.mu.Lock()
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	drained := m.drained
+	if drained == nil {
+		drained = make(chan struct{})
+		if m.activeConns == 0 {
+			close(drained)
+		} else {
+			m.drained = drained
+		}
+	}
+	m.mu.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	m.Stop()
+	return err
+}
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"fmt"
+	logger "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorDialTimeout = 5 * time.Second
+	mirrorQueueSize   = 256 // 等待发送到镜像地址的数据块数，超过时丢弃，避免影响主连接
+)
+
+// mirrorConn 将写入的数据(本地发往远端的流量)复制一份异步发送到镜像地址，镜像地址的响应会被丢弃
+// 镜像地址不可用或发送过慢时只丢弃镜像的数据，不影响主连接
+type mirrorConn struct {
+	net.Conn
+	queue     chan []byte
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closed    bool
+	dropped   bool
+}
+
+// newMirrorConn 异步连接镜像地址并发送镜像数据，连接镜像地址的过程不会延迟主连接
+func newMirrorConn(conn net.Conn, mirrorAddr string, log logger.FieldLogger) net.Conn {
+	c := &mirrorConn{Conn: conn, queue: make(chan []byte, mirrorQueueSize)}
+	go func() {
+		mirror, err := net.DialTimeout("tcp", mirrorAddr, mirrorDialTimeout)
+		if err != nil {
+			log.Infof(fmt.Sprintf("[!] Error connecting to mirror endpoint %s: %s", mirrorAddr, err.Error()))
+		} else {
+			defer mirror.Close()
+			go io.Copy(io.Discard, mirror)
+		}
+		for data := range c.queue {
+			if mirror == nil {
+				continue
+			}
+			if _, err := mirror.Write(data); err != nil {
+				log.Infof(fmt.Sprintf("[!] Error writing to mirror endpoint %s: %s", mirrorAddr, err.Error()))
+				mirror.Close()
+				mirror = nil
+			}
+		}
+	}()
+	return c
+}
+
+func (c *mirrorConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.mirror(p[:n])
+	}
+	return n, err
+}
+
+func (c *mirrorConn) mirror(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.dropped {
+		return
+	}
+	select {
+	case c.queue <- append([]byte(nil), data...):
+	default:
+		// 镜像的数据不完整后已经没有意义，之后的数据都不再发送
+		c.dropped = true
+	}
+}
+
+func (c *mirrorConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		close(c.queue)
+		c.mu.Unlock()
+	})
+	return err
+}
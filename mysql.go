@@ -0,0 +1,18 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+)
+
+// MySQLDialContext 返回通过隧道建立连接的函数，签名与go-sql-driver/mysql的DialContextFunc相同
+// 通常使用mysqltunnel.RegisterMySQLNetwork一次完成注册，tunnel包本身不依赖mysql的驱动；也可以自行注册:
+//
+//	mysql.RegisterDialContext("tunnel", tunnel.MySQLDialContext(t))
+//	db, err := sql.Open("mysql", "user:pass@tunnel(db-host:3306)/db")
+func MySQLDialContext(t Tunnel) func(ctx context.Context, addr string) (net.Conn, error) {
+	dial := t.Dialer()
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return dial(ctx, "tcp", addr)
+	}
+}
@@ -0,0 +1,17 @@
+// Package mysqltunnel 将隧道注册为go-sql-driver/mysql的自定义网络，单独成包使tunnel包不依赖mysql的驱动
+package mysqltunnel
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"tunnel"
+)
+
+// RegisterMySQLNetwork 将隧道注册为名为name的mysql网络，之后dsn中使用该网络的连接都通过隧道建立:
+//
+//	mysqltunnel.RegisterMySQLNetwork("tunnel", t)
+//	db, err := sql.Open("mysql", "user:pass@tunnel(db-host:3306)/db")
+//
+// 同名的网络会被替换，隧道停止后使用该网络的连接会失败
+func RegisterMySQLNetwork(name string, t tunnel.Tunnel) {
+	mysql.RegisterDialContext(name, tunnel.MySQLDialContext(t))
+}
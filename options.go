@@ -0,0 +1,155 @@
+package tunnel
+
+import (
+	logger "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Option NewTunnel的选项，用于设置隧道的配置，未提供的选项可以直接修改TunnelConfig
+type Option func(config *TunnelConfig) error
+
+// NewTunnel 按协议创建隧道，创建后需要以协程的方式调用Start
+func NewTunnel(protocol string, opts ...Option) (Tunnel, error) {
+	tunnelFactoryFunc, ok := lookupFactory(protocol)
+	if !ok {
+		return nil, &ConfigError{Field: "Protocol", Value: protocol, Err: ErrUnsupportedProtocol}
+	}
+	return newTunnel(tunnelFactoryFunc, protocol, opts)
+}
+
+// newTunnel 应用选项后使用工厂创建隧道
+func newTunnel(tunnelFactoryFunc TunnelFactory, protocol string, opts []Option) (Tunnel, error) {
+	config := &TunnelConfig{Protocol: protocol}
+	for _, opt := range opts {
+		if err := opt(config); err != nil {
+			return nil, err
+		}
+	}
+	return tunnelFactoryFunc(config)
+}
+
+// WithTunnelEndpoint 隧道的地址，如ssh服务的host:port
+func WithTunnelEndpoint(endpoint string) Option {
+	return func(config *TunnelConfig) error {
+		config.TunnelEndpoint = endpoint
+		return nil
+	}
+}
+
+// WithAuth 隧道认证的账号和密码
+func WithAuth(username, password string) Option {
+	return func(config *TunnelConfig) error {
+		config.Username = username
+		config.Password = password
+		return nil
+	}
+}
+
+// WithPassword 隧道认证的密码
+func WithPassword(password string) Option {
+	return func(config *TunnelConfig) error {
+		config.Password = password
+		return nil
+	}
+}
+
+// WithAuthKeyFile 隧道认证使用的私钥文件，私钥没有密码时passphrase为空
+func WithAuthKeyFile(path, passphrase string) Option {
+	return func(config *TunnelConfig) error {
+		config.AuthKeyFile = path
+		config.AuthKeyPassphrase = passphrase
+		return nil
+	}
+}
+
+// WithRemote 透过隧道后最终要连接的端点，如http://10.0.0.1:8080，未指定协议时为http
+func WithRemote(endpoint string) Option {
+	return func(config *TunnelConfig) error {
+		parser := EndpointParser{DefaultScheme: "http"}
+		remoteEndpoint, err := parser.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		config.RemoteAddr = remoteEndpoint.Host
+		config.RemotePort = remoteEndpoint.Port
+		config.TunneledProtocol = remoteEndpoint.Scheme
+		return nil
+	}
+}
+
+// WithLocalAddr 本地监听的地址
+func WithLocalAddr(addr string) Option {
+	return func(config *TunnelConfig) error {
+		config.LocalAddr = addr
+		return nil
+	}
+}
+
+// WithLogger 隧道使用的日志
+func WithLogger(log logger.FieldLogger) Option {
+	return func(config *TunnelConfig) error {
+		config.Logger = log
+		return nil
+	}
+}
+
+// WithLogLevel 隧道单独的日志级别，如debug、info、warn
+func WithLogLevel(level string) Option {
+	return func(config *TunnelConfig) error {
+		config.LogLevel = level
+		return nil
+	}
+}
+
+// WithQuiet 只输出警告及错误
+func WithQuiet() Option {
+	return func(config *TunnelConfig) error {
+		config.Quiet = true
+		return nil
+	}
+}
+
+// WithDialTimeout 连接隧道服务的超时时间
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(config *TunnelConfig) error {
+		config.DialTimeout = timeout
+		return nil
+	}
+}
+
+// WithEventHandler 隧道事件的回调
+func WithEventHandler(handler EventHandler) Option {
+	return func(config *TunnelConfig) error {
+		config.EventHandler = handler
+		return nil
+	}
+}
+
+// WithLabels 添加隧道的标签，可以多次调用，相同的key后设置的生效
+func WithLabels(labels map[string]string) Option {
+	return func(config *TunnelConfig) error {
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for key, value := range labels {
+			config.Labels[key] = value
+		}
+		return nil
+	}
+}
+
+// WithName 隧道的名称
+func WithName(name string) Option {
+	return func(config *TunnelConfig) error {
+		config.Name = name
+		return nil
+	}
+}
+
+// WithConnectPolicy 建立ssh连接的时机，见ConnectXXX
+func WithConnectPolicy(policy string) Option {
+	return func(config *TunnelConfig) error {
+		config.ConnectPolicy = policy
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PACScript 按路由规则生成PAC文件，proxyAddr为浏览器访问本地代理的地址，如127.0.0.1:8080
+// 直接连接的规则返回DIRECT，其余(包括拒绝的规则)交给本地代理，由代理按路由规则处理
+// PAC中无法等价表示的规则(如ipv6的CIDR)也交给本地代理，保证与代理的路由结果一致
+func (s *SshTunnel) PACScript(proxyAddr string) string {
+	proxy := strconv.Quote("PROXY " + proxyAddr)
+	var script strings.Builder
+	script.WriteString("function FindProxyForURL(url, host) {\n")
+	script.WriteString("\thost = host.toLowerCase();\n")
+	script.WriteString("\tvar isIPv4 = /^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host);\n")
+	for _, r := range s.routes {
+		condition, exact := pacCondition(r)
+		result := proxy
+		if exact && r.rule.Dialer == nil && r.rule.Action == RouteDirect {
+			result = strconv.Quote("DIRECT")
+		}
+		fmt.Fprintf(&script, "\tif (%s) return %s;\n", condition, result)
+	}
+	fmt.Fprintf(&script, "\treturn %s;\n}\n", proxy)
+	return script.String()
+}
+
+// pacCondition 将路由规则的匹配条件转换为PAC中的表达式，exact为false时表达式匹配的范围比规则大(如ipv6的CIDR)
+// 此时匹配的地址只能交给本地代理，不能直接连接
+func pacCondition(r route) (condition string, exact bool) {
+	switch {
+	case r.rule.Match == "*":
+		return "true", true
+	case r.network != nil:
+		if ip := r.network.IP.To4(); ip != nil {
+			return fmt.Sprintf("isIPv4 && isInNet(host, %q, %q)", ip.String(), net.IP(r.network.Mask).String()), true
+		}
+		return `host.indexOf(":") >= 0`, false
+	case r.ip != nil:
+		return fmt.Sprintf("host == %q", r.ip.String()), true
+	case strings.HasPrefix(r.rule.Match, "*."):
+		return fmt.Sprintf("dnsDomainIs(host, %q)", r.rule.Match[1:]), true
+	default:
+		return fmt.Sprintf("host == %q", r.rule.Match), true
+	}
+}
+
+// isPACRequest 判断是否为获取PAC文件的请求
+func (s *SshTunnel) isPACRequest(req *http.Request) bool {
+	return s.pacPath != "" && req.Method == http.MethodGet && !req.URL.IsAbs() && req.URL.Path == s.pacPath
+}
+
+// servePAC 返回PAC文件，代理地址使用客户端请求中的Host，即客户端访问本地代理的地址
+func (s *SshTunnel) servePAC(localConn net.Conn, req *http.Request) {
+	defer localConn.Close()
+	proxyAddr := req.Host
+	if proxyAddr == "" {
+		proxyAddr = localConn.LocalAddr().String()
+	}
+	script := s.PACScript(proxyAddr)
+	s.connLog.Infof(fmt.Sprintf("[*] Serving PAC file to %s", localConn.RemoteAddr()))
+	fmt.Fprintf(localConn, "HTTP/1.1 200 OK\r\nContent-Type: application/x-ns-proxy-autoconfig\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(script), script)
+}
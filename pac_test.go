@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+)
+
+// newPACTunnel 创建配置了路由规则的http代理隧道，不启动
+func newPACTunnel(t *testing.T, routes []RouteRule) *SshTunnel {
+	t.Helper()
+	config := validConfig()
+	config.LocalMode = LocalModeHTTPProxy
+	config.Routes = routes
+	instance, err := SshTunnelFactory(&config)
+	if err != nil {
+		t.Fatalf("create tunnel: %v", err)
+	}
+	return instance.(*SshTunnel)
+}
+
+// pacResult 返回PAC中条件为condition的规则的结果
+func pacResult(t *testing.T, script, condition string) string {
+	t.Helper()
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if prefix := "if (" + condition + ") return "; strings.HasPrefix(line, prefix) {
+			return strings.TrimSuffix(strings.TrimPrefix(line, prefix), ";")
+		}
+	}
+	t.Fatalf("no rule with condition %s in PAC:\n%s", condition, script)
+	return ""
+}
+
+func TestPACScriptIPv6CIDRNotDirect(t *testing.T) {
+	instance := newPACTunnel(t, []RouteRule{
+		{Match: "fd00::/8", Action: RouteDirect},
+		{Match: "10.0.0.0/8", Action: RouteDirect},
+	})
+	script := instance.PACScript("127.0.0.1:8080")
+
+	// PAC无法判断ipv6地址是否在fd00::/8内，2001:db8::1等无关的ipv6地址也会匹配该条件，必须交给代理
+	if got := pacResult(t, script, `host.indexOf(":") >= 0`); got != `"PROXY 127.0.0.1:8080"` {
+		t.Fatalf("ipv6 CIDR rule returns %s, want the proxy", got)
+	}
+	if got := pacResult(t, script, `isIPv4 && isInNet(host, "10.0.0.0", "255.0.0.0")`); got != `"DIRECT"` {
+		t.Fatalf("ipv4 CIDR rule returns %s, want DIRECT", got)
+	}
+}
+
+func TestPACScriptRules(t *testing.T) {
+	instance := newPACTunnel(t, []RouteRule{
+		{Match: "*.corp.example.com", Action: RouteDirect},
+		{Match: "intranet", Action: RouteDirect},
+		{Match: "192.168.1.1", Action: RouteDeny},
+		{Match: "*", Action: RouteTunnel},
+	})
+	script := instance.PACScript("127.0.0.1:8080")
+	tests := []struct {
+		condition string
+		want      string
+	}{
+		{`dnsDomainIs(host, ".corp.example.com")`, `"DIRECT"`},
+		{`host == "intranet"`, `"DIRECT"`},
+		{`host == "192.168.1.1"`, `"PROXY 127.0.0.1:8080"`},
+		{`true`, `"PROXY 127.0.0.1:8080"`},
+	}
+	for _, tt := range tests {
+		if got := pacResult(t, script, tt.condition); got != tt.want {
+			t.Errorf("rule %s returns %s, want %s", tt.condition, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pcapLinkTypeRaw = 101   // LINKTYPE_RAW，数据包直接从ip头开始
+	pcapSnapLen     = 65535 // 每个数据包的最大长度
+	pcapMaxPayload  = pcapSnapLen - 60
+)
+
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+var pcapFileSeq uint64
+
+// pcapEndpoint 抓包时tcp连接一端的地址
+type pcapEndpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32 // 该端下一个发送的序号
+}
+
+// pcapWriter 将一个转发连接中双向的数据写入pcap文件，数据包的ip及tcp头是根据连接的地址生成的
+// 写入的是隧道加密前的原始数据，可以直接用wireshark等工具分析隧道内的协议
+type pcapWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	client pcapEndpoint
+	server pcapEndpoint
+	closed bool
+}
+
+// newPcapWriter 在dir中创建pcap文件，并写入tcp的三次握手
+func newPcapWriter(dir string, clientAddr, serverAddr net.Addr) (*pcapWriter, error) {
+	name := fmt.Sprintf("%s-%d.pcap", time.Now().Format("20060102-150405"), atomic.AddUint64(&pcapFileSeq, 1))
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("create pcap file failed, err: %w", err)
+	}
+	client, server := pcapEndpoints(clientAddr, serverAddr)
+	w := &pcapWriter{file: file, writer: bufio.NewWriter(file), client: client, server: server}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	w.writer.Write(header)
+
+	w.writePacket(&w.client, &w.server, tcpFlagSYN, nil)
+	w.writePacket(&w.server, &w.client, tcpFlagSYN|tcpFlagACK, nil)
+	w.writePacket(&w.client, &w.server, tcpFlagACK, nil)
+	return w, nil
+}
+
+// pcapEndpoints 获取连接两端的地址，无法获取ip(如unix socket或ssh服务端未返回地址)或地址族不同时使用保留的地址
+func pcapEndpoints(clientAddr, serverAddr net.Addr) (pcapEndpoint, pcapEndpoint) {
+	client := pcapEndpoint{ip: net.IPv4(192, 0, 2, 1), port: 50000, seq: 1000}
+	server := pcapEndpoint{ip: net.IPv4(192, 0, 2, 2), port: 80, seq: 2000}
+	clientTCP, clientOK := clientAddr.(*net.TCPAddr)
+	serverTCP, serverOK := serverAddr.(*net.TCPAddr)
+	if clientOK && clientTCP.Port != 0 {
+		client.port = uint16(clientTCP.Port)
+	}
+	if serverOK && serverTCP.Port != 0 {
+		server.port = uint16(serverTCP.Port)
+	}
+	clientOK = clientOK && clientTCP.IP != nil && !clientTCP.IP.IsUnspecified()
+	serverOK = serverOK && serverTCP.IP != nil && !serverTCP.IP.IsUnspecified()
+	if clientOK && serverOK && (clientTCP.IP.To4() == nil) == (serverTCP.IP.To4() == nil) {
+		client.ip = clientTCP.IP
+		server.ip = serverTCP.IP
+	}
+	return client, server
+}
+
+// writeData 写入from发送的数据，过大的数据拆分为多个数据包
+func (w *pcapWriter) writeData(fromClient bool, data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	from, to := &w.client, &w.server
+	if !fromClient {
+		from, to = &w.server, &w.client
+	}
+	for len(data) > 0 {
+		size := len(data)
+		if size > pcapMaxPayload {
+			size = pcapMaxPayload
+		}
+		w.writePacket(from, to, tcpFlagPSH|tcpFlagACK, data[:size])
+		data = data[size:]
+	}
+}
+
+// close 写入双方的FIN后关闭文件
+func (w *pcapWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.writePacket(&w.client, &w.server, tcpFlagFIN|tcpFlagACK, nil)
+	w.writePacket(&w.server, &w.client, tcpFlagFIN|tcpFlagACK, nil)
+	w.writer.Flush()
+	return w.file.Close()
+}
+
+// writePacket 生成ip及tcp头，写入一个数据包
+func (w *pcapWriter) writePacket(from, to *pcapEndpoint, flags byte, payload []byte) {
+	tcpHeader := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcpHeader[0:], from.port)
+	binary.BigEndian.PutUint16(tcpHeader[2:], to.port)
+	binary.BigEndian.PutUint32(tcpHeader[4:], from.seq)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(tcpHeader[8:], to.seq)
+	}
+	tcpHeader[12] = 5 << 4
+	tcpHeader[13] = flags
+	binary.BigEndian.PutUint16(tcpHeader[14:], 65535)
+	segment := append(tcpHeader, payload...)
+
+	var packet []byte
+	if from.ip.To4() != nil {
+		packet = make([]byte, 20, 20+len(segment))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:], uint16(20+len(segment)))
+		packet[8] = 64
+		packet[9] = 6
+		copy(packet[12:16], from.ip.To4())
+		copy(packet[16:20], to.ip.To4())
+		binary.BigEndian.PutUint16(packet[10:], checksum(packet, 0))
+		binary.BigEndian.PutUint16(segment[16:], checksum(segment, pseudoHeaderSum(from.ip.To4(), to.ip.To4(), len(segment))))
+	} else {
+		packet = make([]byte, 40, 40+len(segment))
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:], uint16(len(segment)))
+		packet[6] = 6
+		packet[7] = 64
+		copy(packet[8:24], from.ip.To16())
+		copy(packet[24:40], to.ip.To16())
+		binary.BigEndian.PutUint16(segment[16:], checksum(segment, pseudoHeaderSum(from.ip.To16(), to.ip.To16(), len(segment))))
+	}
+	packet = append(packet, segment...)
+
+	// SYN及FIN占用一个序号
+	from.seq += uint32(len(payload))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		from.seq++
+	}
+
+	now := time.Now()
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	w.writer.Write(record)
+	w.writer.Write(packet)
+}
+
+// pseudoHeaderSum 计算tcp校验和中伪首部的部分
+func pseudoHeaderSum(src, dst net.IP, length int) uint32 {
+	var sum uint32
+	for _, ip := range [][]byte{src, dst} {
+		for i := 0; i < len(ip); i += 2 {
+			sum += uint32(ip[i])<<8 | uint32(ip[i+1])
+		}
+	}
+	return sum + 6 + uint32(length)
+}
+
+// checksum 计算互联网校验和
+func checksum(data []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// captureConn 将读写的数据写入pcap文件的连接，写入的数据为客户端发送的，读取的数据为服务端发送的
+type captureConn struct {
+	net.Conn
+	pcap *pcapWriter
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.pcap.writeData(false, p[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.pcap.writeData(true, p[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Close() error {
+	err := c.Conn.Close()
+	c.pcap.close()
+	return err
+}
@@ -0,0 +1,446 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// managerState 状态文件的内容
+type managerState struct {
+	Tunnels []persistedTunnel      `json:"tunnels"`
+	Quotas  map[string]TenantQuota `json:"quotas,omitempty"`
+}
+
+// persistedTunnel 状态文件中保存的隧道定义
+// 保存所有可以安全写入文件的配置，密码、私钥的密码、中继的请求头部等凭据不保存，私钥文件、ssh-agent等凭据只保存其引用
+// 回调、监听、TLS配置等无法序列化的配置也不保存(见unsavedFields)，恢复时通过Persist的prepare重新设置
+type persistedTunnel struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name,omitempty"`
+	Protocol         string            `json:"protocol"`
+	TunnelEndpoint   string            `json:"tunnel_endpoint,omitempty"`
+	TunnelEndpoints  []string          `json:"tunnel_endpoints,omitempty"`
+	Username         string            `json:"username,omitempty"`
+	RemoteAddr       string            `json:"remote_addr,omitempty"`
+	RemotePort       int               `json:"remote_port,omitempty"`
+	RemoteAddrs      []string          `json:"remote_addrs,omitempty"`
+	LoadBalance      string            `json:"load_balance,omitempty"`
+	TunneledProtocol string            `json:"tunneled_protocol,omitempty"`
+	LocalMode        string            `json:"local_mode,omitempty"`
+	LocalAddr        string            `json:"local_addr,omitempty"`
+	AuthKeyFile      string            `json:"auth_key_file,omitempty"`
+	UseAgent         bool              `json:"use_agent,omitempty"`
+	AgentSocket      string            `json:"agent_socket,omitempty"`
+	HostKeyPolicy    string            `json:"host_key_policy,omitempty"`
+	KnownHostsFile   string            `json:"known_hosts_file,omitempty"`
+	ConnectPolicy    string            `json:"connect_policy,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+
+	HealthCheckInterval time.Duration `json:"health_check_interval,omitempty"`
+	IdleShutdown        time.Duration `json:"idle_shutdown,omitempty"`
+	IdleDisconnect      time.Duration `json:"idle_disconnect,omitempty"`
+	TransferQuota       int64         `json:"transfer_quota,omitempty"`
+
+	ResetOnClose             bool              `json:"reset_on_close,omitempty"`
+	LingerTimeout            time.Duration     `json:"linger_timeout,omitempty"`
+	LocalTLS                 bool              `json:"local_tls,omitempty"`
+	LocalTLSCertFile         string            `json:"local_tls_cert_file,omitempty"`
+	LocalTLSKeyFile          string            `json:"local_tls_key_file,omitempty"`
+	RemoteTLS                bool              `json:"remote_tls,omitempty"`
+	LocalAddrs               []string          `json:"local_addrs,omitempty"`
+	Forwards                 map[string]string `json:"forwards,omitempty"`
+	ShareConnection          bool              `json:"share_connection,omitempty"`
+	DedicatedConnection      bool              `json:"dedicated_connection,omitempty"`
+	ChannelPoolSize          int               `json:"channel_pool_size,omitempty"`
+	ChannelPoolIdleTimeout   time.Duration     `json:"channel_pool_idle_timeout,omitempty"`
+	RemoteSOCKSAddr          string            `json:"remote_socks_addr,omitempty"`
+	ReusePort                bool              `json:"reuse_port,omitempty"`
+	SNIRoutes                map[string]string `json:"sni_routes,omitempty"`
+	RewriteHTTP              bool              `json:"rewrite_http,omitempty"`
+	Routes                   []persistedRoute  `json:"routes,omitempty"`
+	FailbackInterval         time.Duration     `json:"failback_interval,omitempty"`
+	SRVRefreshInterval       time.Duration     `json:"srv_refresh_interval,omitempty"`
+	H2Relay                  string            `json:"h2_relay,omitempty"`
+	RemoteDialRetries        int               `json:"remote_dial_retries,omitempty"`
+	RemoteDialBackoff        time.Duration     `json:"remote_dial_backoff,omitempty"`
+	RemoteDialJitter         time.Duration     `json:"remote_dial_jitter,omitempty"`
+	ResolveLocally           bool              `json:"resolve_locally,omitempty"`
+	ResolveRemotely          bool              `json:"resolve_remotely,omitempty"`
+	DNSCacheTTL              time.Duration     `json:"dns_cache_ttl,omitempty"`
+	DNSNegativeCacheTTL      time.Duration     `json:"dns_negative_cache_ttl,omitempty"`
+	Hosts                    map[string]string `json:"hosts,omitempty"`
+	PACPath                  string            `json:"pac_path,omitempty"`
+	HealthCheckPath          string            `json:"health_check_path,omitempty"`
+	HealthCheckTimeout       time.Duration     `json:"health_check_timeout,omitempty"`
+	HealthCheckTLS           bool              `json:"health_check_tls,omitempty"`
+	MirrorAddr               string            `json:"mirror_addr,omitempty"`
+	TraceBytes               int               `json:"trace_bytes,omitempty"`
+	CaptureDir               string            `json:"capture_dir,omitempty"`
+	ConcurrencyWarnThreshold int               `json:"concurrency_warn_threshold,omitempty"`
+	Chaos                    *ChaosConfig      `json:"chaos,omitempty"`
+	DialTimeout              time.Duration     `json:"dial_timeout,omitempty"`
+	LogLevel                 string            `json:"log_level,omitempty"`
+	Quiet                    bool              `json:"quiet,omitempty"`
+	LogSampleRate            int               `json:"log_sample_rate,omitempty"`
+	ConnectOnStart           bool              `json:"connect_on_start,omitempty"`
+	ForwardAgent             bool              `json:"forward_agent,omitempty"`
+	ClientVersion            string            `json:"client_version,omitempty"`
+	MaxConnectionLifetime    time.Duration     `json:"max_connection_lifetime,omitempty"`
+	RekeyThreshold           uint64            `json:"rekey_threshold,omitempty"`
+	SSHFPResolver            string            `json:"sshfp_resolver,omitempty"`
+	SSHFPRequireDNSSEC       bool              `json:"sshfp_require_dnssec,omitempty"`
+
+	Tenant   string         `json:"tenant,omitempty"`
+	Added    time.Time      `json:"added"`
+	TTL      time.Duration  `json:"ttl,omitempty"`
+	Windows  []ActiveWindow `json:"windows,omitempty"`
+	Location string         `json:"location,omitempty"`
+}
+
+// persistedRoute 状态文件中保存的路由规则，Dialer无法保存
+type persistedRoute struct {
+	Match  string `json:"match"`
+	Action string `json:"action,omitempty"`
+}
+
+// Persist 从path恢复隧道，之后每次加入或移除隧道及修改配额时将隧道的定义保存到path，主机重启后不需要重新创建所有的隧道
+// 文件不存在时不恢复，恢复的隧道保留原来的ID、租户、加入时间及激活时间窗口，TTL已经到期的隧道不再恢复
+// 密码等凭据不会写入文件，prepare不为空时在启动每个恢复的隧道前调用，用于重新设置凭据(如CredentialProvider)、日志及回调
+// 之后加入的隧道包含回调等无法保存的配置时会记录警告
+// 恢复的隧道启动失败时仍然加入管理器并定期重试；prepare返回错误或无法加入管理器的隧道被跳过，错误合并后返回
+// 跳过的隧道定义仍然保留在状态文件中，下次调用Persist时再次尝试恢复，重新加入相同ID的隧道或调用StopAll时才丢弃
+func (m *TunnelManager) Persist(path string, prepare func(config *TunnelConfig) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("read state file failed, err: %w", err)
+	}
+	var state managerState
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("parse state file failed, err: %w", err)
+		}
+	}
+	m.mu.Lock()
+	for tenant, quota := range state.Quotas {
+		m.quotas[tenant] = quota
+		m.limiter(tenant).SetLimit(quota.Bandwidth)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, saved := range state.Tunnels {
+		if saved.TTL > 0 && time.Now().After(saved.Added.Add(saved.TTL)) {
+			m.log.Infof(fmt.Sprintf("[*] Tunnel %s reached its TTL %s while not running, not restoring", saved.ID, saved.TTL))
+			continue
+		}
+		config, options, err := saved.restore()
+		if err == nil && prepare != nil {
+			err = prepare(&config)
+		}
+		if err == nil {
+			_, err = m.start(config, options, saved.Added, true)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore tunnel %s failed, err: %w", saved.ID, err))
+			m.mu.Lock()
+			m.unrestored[managedID(saved.Tenant, saved.ID)] = saved
+			m.mu.Unlock()
+		}
+	}
+
+	m.saveMu.Lock()
+	m.statePath = path
+	m.saveMu.Unlock()
+	m.saveState()
+	return errors.Join(errs...)
+}
+
+// Close 停止所有的隧道，但不从状态文件中移除它们的定义，用于守护进程退出，下次启动时通过Persist恢复
+// 调用StopAll会同时清空状态文件
+func (m *TunnelManager) Close() {
+	m.saveMu.Lock()
+	m.statePath = ""
+	m.saveMu.Unlock()
+	m.StopAll()
+}
+
+// saveState 配置了状态文件时保存所有隧道的定义，失败时只记录日志
+func (m *TunnelManager) saveState() {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	if m.statePath == "" {
+		return
+	}
+	m.mu.Lock()
+	state := managerState{Tunnels: make([]persistedTunnel, 0, len(m.entries)), Quotas: map[string]TenantQuota{}}
+	for _, entry := range m.entries {
+		state.Tunnels = append(state.Tunnels, persistTunnel(entry))
+	}
+	now := time.Now()
+	for id, saved := range m.unrestored {
+		if saved.TTL > 0 && now.After(saved.Added.Add(saved.TTL)) {
+			delete(m.unrestored, id)
+			continue
+		}
+		state.Tunnels = append(state.Tunnels, saved)
+	}
+	for tenant, quota := range m.quotas {
+		state.Quotas[tenant] = quota
+	}
+	m.mu.Unlock()
+
+	if err := writeStateFile(m.statePath, state); err != nil {
+		m.log.Infof(fmt.Sprintf("[!] Error saving tunnel state to %s: %s", m.statePath, err.Error()))
+	}
+}
+
+// writeStateFile 先写入临时文件再重命名，避免写入过程中退出导致状态文件不完整
+func writeStateFile(path string, state managerState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func persistTunnel(entry *managedEntry) persistedTunnel {
+	c := entry.config
+	return persistedTunnel{
+		ID:                       c.ID,
+		Name:                     c.Name,
+		Protocol:                 c.Protocol,
+		TunnelEndpoint:           c.TunnelEndpoint,
+		TunnelEndpoints:          c.TunnelEndpoints,
+		Username:                 c.Username,
+		RemoteAddr:               c.RemoteAddr,
+		RemotePort:               c.RemotePort,
+		RemoteAddrs:              c.RemoteAddrs,
+		LoadBalance:              c.LoadBalance,
+		TunneledProtocol:         c.TunneledProtocol,
+		LocalMode:                c.LocalMode,
+		LocalAddr:                c.LocalAddr,
+		AuthKeyFile:              c.AuthKeyFile,
+		UseAgent:                 c.UseAgent,
+		AgentSocket:              c.AgentSocket,
+		HostKeyPolicy:            c.HostKeyPolicy,
+		KnownHostsFile:           c.KnownHostsFile,
+		ConnectPolicy:            c.ConnectPolicy,
+		Labels:                   c.Labels,
+		HealthCheckInterval:      c.HealthCheckInterval,
+		IdleShutdown:             c.IdleShutdown,
+		IdleDisconnect:           c.IdleDisconnect,
+		TransferQuota:            c.TransferQuota,
+		ResetOnClose:             c.ResetOnClose,
+		LingerTimeout:            c.LingerTimeout,
+		LocalTLS:                 c.LocalTLS,
+		LocalTLSCertFile:         c.LocalTLSCertFile,
+		LocalTLSKeyFile:          c.LocalTLSKeyFile,
+		RemoteTLS:                c.RemoteTLS,
+		LocalAddrs:               c.LocalAddrs,
+		Forwards:                 c.Forwards,
+		ShareConnection:          c.ShareConnection,
+		DedicatedConnection:      c.DedicatedConnection,
+		ChannelPoolSize:          c.ChannelPoolSize,
+		ChannelPoolIdleTimeout:   c.ChannelPoolIdleTimeout,
+		RemoteSOCKSAddr:          c.RemoteSOCKSAddr,
+		ReusePort:                c.ReusePort,
+		SNIRoutes:                c.SNIRoutes,
+		RewriteHTTP:              c.RewriteHTTP,
+		Routes:                   persistRoutes(c.Routes),
+		FailbackInterval:         c.FailbackInterval,
+		SRVRefreshInterval:       c.SRVRefreshInterval,
+		H2Relay:                  c.H2Relay,
+		RemoteDialRetries:        c.RemoteDialRetries,
+		RemoteDialBackoff:        c.RemoteDialBackoff,
+		RemoteDialJitter:         c.RemoteDialJitter,
+		ResolveLocally:           c.ResolveLocally,
+		ResolveRemotely:          c.ResolveRemotely,
+		DNSCacheTTL:              c.DNSCacheTTL,
+		DNSNegativeCacheTTL:      c.DNSNegativeCacheTTL,
+		Hosts:                    c.Hosts,
+		PACPath:                  c.PACPath,
+		HealthCheckPath:          c.HealthCheckPath,
+		HealthCheckTimeout:       c.HealthCheckTimeout,
+		HealthCheckTLS:           c.HealthCheckTLS,
+		MirrorAddr:               c.MirrorAddr,
+		TraceBytes:               c.TraceBytes,
+		CaptureDir:               c.CaptureDir,
+		ConcurrencyWarnThreshold: c.ConcurrencyWarnThreshold,
+		Chaos:                    c.Chaos,
+		DialTimeout:              c.DialTimeout,
+		LogLevel:                 c.LogLevel,
+		Quiet:                    c.Quiet,
+		LogSampleRate:            c.LogSampleRate,
+		ConnectOnStart:           c.ConnectOnStart,
+		ForwardAgent:             c.ForwardAgent,
+		ClientVersion:            c.ClientVersion,
+		MaxConnectionLifetime:    c.MaxConnectionLifetime,
+		RekeyThreshold:           c.RekeyThreshold,
+		SSHFPResolver:            c.SSHFPResolver,
+		SSHFPRequireDNSSEC:       c.SSHFPRequireDNSSEC,
+		Tenant:                   entry.options.Tenant,
+		Added:                    entry.added,
+		TTL:                      entry.options.TTL,
+		Windows:                  entry.options.Windows,
+		Location:                 entry.options.Location.String(),
+	}
+}
+
+func persistRoutes(routes []RouteRule) []persistedRoute {
+	var persisted []persistedRoute
+	for _, route := range routes {
+		persisted = append(persisted, persistedRoute{Match: route.Match, Action: route.Action})
+	}
+	return persisted
+}
+
+// unsavedFields 配置中已设置但无法写入状态文件的字段，恢复时需要通过Persist的prepare重新设置
+// 密码等凭据按设计不保存，不在其中
+func unsavedFields(c TunnelConfig) []string {
+	var fields []string
+	add := func(set bool, field string) {
+		if set {
+			fields = append(fields, field)
+		}
+	}
+	add(c.Listener != nil, "Listener")
+	add(c.LocalTLSConfig != nil, "LocalTLSConfig")
+	add(c.RemoteTLSConfig != nil, "RemoteTLSConfig")
+	add(c.H2RelayTLSConfig != nil, "H2RelayTLSConfig")
+	add(c.TunnelDialer != nil, "TunnelDialer")
+	add(c.Resolver != nil, "Resolver")
+	add(c.BandwidthLimiter != nil, "BandwidthLimiter")
+	add(c.EventHandler != nil, "EventHandler")
+	add(c.AuditSink != nil, "AuditSink")
+	add(c.Logger != nil, "Logger")
+	add(c.SecurityKey != nil, "SecurityKey")
+	add(c.CredentialProvider != nil, "CredentialProvider")
+	add(c.BannerCallback != nil, "BannerCallback")
+	add(c.HostKeyCallback != nil, "HostKeyCallback")
+	add(len(c.AuthMethods) > 0, "AuthMethods")
+	for _, route := range c.Routes {
+		if route.Dialer != nil {
+			fields = append(fields, "Routes.Dialer")
+			break
+		}
+	}
+	return fields
+}
+
+// warnUnsaved 管理器配置了状态文件时，提示隧道中无法保存的配置在恢复时会丢失
+func (m *TunnelManager) warnUnsaved(id string, entry *managedEntry) {
+	config := entry.config
+	if entry.options.Tenant != "" {
+		// 租户的限速器由管理器设置，恢复时同样会设置
+		config.BandwidthLimiter = nil
+	}
+	m.saveMu.Lock()
+	persisting := m.statePath != ""
+	m.saveMu.Unlock()
+	if fields := unsavedFields(config); persisting && len(fields) > 0 {
+		m.log.Warnf(fmt.Sprintf("[!] Tunnel %s has settings that can't be saved to the state file (%s), set them again in Persist's prepare when restoring",
+			id, strings.Join(fields, ", ")))
+	}
+}
+
+// restore 按保存的定义还原隧道的配置
+func (p persistedTunnel) restore() (TunnelConfig, ManageOptions, error) {
+	config := TunnelConfig{
+		ID:                       p.ID,
+		Name:                     p.Name,
+		Protocol:                 p.Protocol,
+		TunnelEndpoint:           p.TunnelEndpoint,
+		TunnelEndpoints:          p.TunnelEndpoints,
+		Username:                 p.Username,
+		RemoteAddr:               p.RemoteAddr,
+		RemotePort:               p.RemotePort,
+		RemoteAddrs:              p.RemoteAddrs,
+		LoadBalance:              p.LoadBalance,
+		TunneledProtocol:         p.TunneledProtocol,
+		LocalMode:                p.LocalMode,
+		LocalAddr:                p.LocalAddr,
+		AuthKeyFile:              p.AuthKeyFile,
+		UseAgent:                 p.UseAgent,
+		AgentSocket:              p.AgentSocket,
+		HostKeyPolicy:            p.HostKeyPolicy,
+		KnownHostsFile:           p.KnownHostsFile,
+		ConnectPolicy:            p.ConnectPolicy,
+		Labels:                   p.Labels,
+		HealthCheckInterval:      p.HealthCheckInterval,
+		IdleShutdown:             p.IdleShutdown,
+		IdleDisconnect:           p.IdleDisconnect,
+		TransferQuota:            p.TransferQuota,
+		ResetOnClose:             p.ResetOnClose,
+		LingerTimeout:            p.LingerTimeout,
+		LocalTLS:                 p.LocalTLS,
+		LocalTLSCertFile:         p.LocalTLSCertFile,
+		LocalTLSKeyFile:          p.LocalTLSKeyFile,
+		RemoteTLS:                p.RemoteTLS,
+		LocalAddrs:               p.LocalAddrs,
+		Forwards:                 p.Forwards,
+		ShareConnection:          p.ShareConnection,
+		DedicatedConnection:      p.DedicatedConnection,
+		ChannelPoolSize:          p.ChannelPoolSize,
+		ChannelPoolIdleTimeout:   p.ChannelPoolIdleTimeout,
+		RemoteSOCKSAddr:          p.RemoteSOCKSAddr,
+		ReusePort:                p.ReusePort,
+		SNIRoutes:                p.SNIRoutes,
+		RewriteHTTP:              p.RewriteHTTP,
+		FailbackInterval:         p.FailbackInterval,
+		SRVRefreshInterval:       p.SRVRefreshInterval,
+		H2Relay:                  p.H2Relay,
+		RemoteDialRetries:        p.RemoteDialRetries,
+		RemoteDialBackoff:        p.RemoteDialBackoff,
+		RemoteDialJitter:         p.RemoteDialJitter,
+		ResolveLocally:           p.ResolveLocally,
+		ResolveRemotely:          p.ResolveRemotely,
+		DNSCacheTTL:              p.DNSCacheTTL,
+		DNSNegativeCacheTTL:      p.DNSNegativeCacheTTL,
+		Hosts:                    p.Hosts,
+		PACPath:                  p.PACPath,
+		HealthCheckPath:          p.HealthCheckPath,
+		HealthCheckTimeout:       p.HealthCheckTimeout,
+		HealthCheckTLS:           p.HealthCheckTLS,
+		MirrorAddr:               p.MirrorAddr,
+		TraceBytes:               p.TraceBytes,
+		CaptureDir:               p.CaptureDir,
+		ConcurrencyWarnThreshold: p.ConcurrencyWarnThreshold,
+		Chaos:                    p.Chaos,
+		DialTimeout:              p.DialTimeout,
+		LogLevel:                 p.LogLevel,
+		Quiet:                    p.Quiet,
+		LogSampleRate:            p.LogSampleRate,
+		ConnectOnStart:           p.ConnectOnStart,
+		ForwardAgent:             p.ForwardAgent,
+		ClientVersion:            p.ClientVersion,
+		MaxConnectionLifetime:    p.MaxConnectionLifetime,
+		RekeyThreshold:           p.RekeyThreshold,
+		SSHFPResolver:            p.SSHFPResolver,
+		SSHFPRequireDNSSEC:       p.SSHFPRequireDNSSEC,
+	}
+	for _, route := range p.Routes {
+		config.Routes = append(config.Routes, RouteRule{Match: route.Match, Action: route.Action})
+	}
+	options := ManageOptions{TTL: p.TTL, Windows: p.Windows, Tenant: p.Tenant}
+	if p.Location != "" {
+		location, err := time.LoadLocation(p.Location)
+		if err != nil {
+			return config, options, err
+		}
+		options.Location = location
+	}
+	return config, options, nil
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostgresDialer 通过隧道建立PostgreSQL连接，实现了lib/pq的Dialer及DialerContext接口
+//
+// lib/pq:
+//
+//	connector, err := pq.NewConnector(cfg.ConnString())
+//	connector.Dialer(tunnel.NewPostgresDialer(t))
+//	db := sql.OpenDB(connector)
+//
+// pgx:
+//
+//	pgxConfig, err := pgx.ParseConfig(cfg.ConnString())
+//	pgxConfig.DialFunc = tunnel.NewPostgresDialer(t).DialContext
+type PostgresDialer struct {
+	dial DialFunc
+}
+
+// NewPostgresDialer 创建通过隧道建立PostgreSQL连接的Dialer
+func NewPostgresDialer(t Tunnel) *PostgresDialer {
+	return &PostgresDialer{dial: t.Dialer()}
+}
+
+func (d *PostgresDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dial(context.Background(), network, address)
+}
+
+func (d *PostgresDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.dial(ctx, network, address)
+}
+
+func (d *PostgresDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.dial(ctx, network, address)
+}
+
+// PostgresConfig PostgreSQL的连接配置，用于生成lib/pq及pgx都支持的连接字符串
+type PostgresConfig struct {
+	Host     string // 数据库的地址，通过隧道连接时为远端网络中的地址
+	Port     int    // 为0时使用5432
+	User     string
+	Password string
+	Database string
+	SSLMode  string            // 如disable、require、verify-full，为空时使用驱动的默认值
+	Params   map[string]string // 其他的连接参数，如application_name、connect_timeout
+}
+
+// ConnString 生成key=value形式的连接字符串
+func (c *PostgresConfig) ConnString() string {
+	port := c.Port
+	if port == 0 {
+		port = DefaultPorts["postgres"]
+	}
+	params := map[string]string{
+		"host":     c.Host,
+		"port":     strconv.Itoa(port),
+		"user":     c.User,
+		"password": c.Password,
+		"dbname":   c.Database,
+		"sslmode":  c.SSLMode,
+	}
+	for key, value := range c.Params {
+		params[key] = value
+	}
+	keys := make([]string, 0, len(params))
+	for key, value := range params {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+quotePostgresValue(params[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// quotePostgresValue 按libpq的规则转义连接字符串中的值
+func quotePostgresValue(value string) string {
+	if !strings.ContainsAny(value, " '\\\t\n") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
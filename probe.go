@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	probeBannerTimeout = 2 * time.Second // 等待服务端主动发送banner的时间
+	probeStepTimeout   = 5 * time.Second // TLS握手及http请求的超时时间
+	probeMaxBanner     = 256             // banner保留的最大长度
+)
+
+// ServiceProbe ProbeService识别出的服务
+type ServiceProbe struct {
+	Target string
+	// Service 识别出的服务，如ssh、smtp、ftp、pop3、imap、mysql、vnc、tls、http，无法识别时为unknown
+	Service string
+	// Banner 服务端主动发送的第一行、mysql的版本或http响应的状态行
+	Banner string
+	// TLS TLS握手成功时的连接状态，包括服务端的证书及协商的ALPN
+	TLS *tls.ConnectionState
+}
+
+func (p *ServiceProbe) String() string {
+	if p.Banner == "" {
+		return fmt.Sprintf("%s: %s", p.Target, p.Service)
+	}
+	return fmt.Sprintf("%s: %s (%q)", p.Target, p.Service, p.Banner)
+}
+
+// ProbeService 通过隧道连接target并识别在监听的服务，用于排查端口填错等连接失败的原因，target为空时使用隧道的远端地址
+// 依次尝试读取服务端主动发送的banner、TLS握手及发送http请求，每一步使用新的连接
+func ProbeService(ctx context.Context, t Tunnel, target string) (*ServiceProbe, error) {
+	if target == "" {
+		target = t.RemoteTarget().HostPort()
+	}
+	probe := &ServiceProbe{Target: target, Service: "unknown"}
+	dial := t.Dialer()
+
+	conn, err := dialProbe(ctx, dial, target, probeBannerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, probeMaxBanner)
+	n, _ := conn.Read(buf)
+	conn.Close()
+	if n > 0 {
+		probe.Service, probe.Banner = identifyBanner(buf[:n])
+		return probe, nil
+	}
+
+	if conn, err = dialProbe(ctx, dial, target, probeStepTimeout); err != nil {
+		return nil, err
+	}
+	host, _, _ := splitHostPort(target)
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	err = tlsConn.Handshake()
+	tlsConn.Close()
+	if err == nil {
+		state := tlsConn.ConnectionState()
+		probe.Service, probe.TLS = "tls", &state
+		return probe, nil
+	}
+
+	if conn, err = dialProbe(ctx, dial, target, probeStepTimeout); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = fmt.Fprintf(conn, "HEAD / HTTP/1.0\r\nHost: %s\r\n\r\n", host); err != nil {
+		return probe, nil
+	}
+	n, _ = conn.Read(buf)
+	if bytes.HasPrefix(buf[:n], []byte("HTTP/")) {
+		probe.Service, probe.Banner = "http", firstLine(buf[:n])
+	}
+	return probe, nil
+}
+
+// probeConn 超时或ctx结束时关闭的连接，ssh隧道中的连接不支持SetDeadline
+type probeConn struct {
+	net.Conn
+	stop   func() bool
+	cancel context.CancelFunc
+}
+
+func (c *probeConn) Close() error {
+	c.stop()
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// dialProbe 通过隧道连接target，timeout后或ctx结束时关闭连接，阻塞的读写随之返回
+func dialProbe(ctx context.Context, dial DialFunc, target string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", target)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s through tunnel failed, err: %w", target, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &probeConn{Conn: conn, stop: stop, cancel: cancel}, nil
+}
+
+// identifyBanner 按服务端主动发送的数据识别服务
+func identifyBanner(banner []byte) (service, line string) {
+	// mysql的握手包: 3字节长度、1字节序号、协议版本10及以NUL结尾的服务端版本
+	if len(banner) > 5 && banner[3] == 0 && banner[4] == 0x0a {
+		if end := bytes.IndexByte(banner[5:], 0); end > 0 {
+			return "mysql", string(banner[5 : 5+end])
+		}
+	}
+	line = firstLine(banner)
+	switch {
+	case strings.HasPrefix(line, "SSH-"):
+		return "ssh", line
+	case strings.HasPrefix(line, "220") && strings.Contains(strings.ToUpper(line), "FTP"):
+		return "ftp", line
+	case strings.HasPrefix(line, "220"):
+		return "smtp", line
+	case strings.HasPrefix(line, "+OK"):
+		return "pop3", line
+	case strings.HasPrefix(line, "* OK"):
+		return "imap", line
+	case strings.HasPrefix(line, "RFB "):
+		return "vnc", line
+	}
+	return "unknown", line
+}
+
+// firstLine 返回第一行，不可打印的字符替换为.
+func firstLine(data []byte) string {
+	if end := bytes.IndexAny(data, "\r\n"); end >= 0 {
+		data = data[:end]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '.'
+		}
+		return r
+	}, string(data))
+}
@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// ErrPanic 处理连接的协程发生panic，事件中的错误包装该错误及panic的值
+var ErrPanic = errors.New("panic in connection handler")
+
+// panicError 将recover得到的值转换为错误
+func panicError(value any) error {
+	if err, ok := value.(error); ok {
+		return fmt.Errorf("%w: %w", ErrPanic, err)
+	}
+	return fmt.Errorf("%w: %v", ErrPanic, value)
+}
+
+// recoverPanic 恢复处理连接的协程中的panic，记录堆栈并触发EventPanic，避免一个连接的异常导致整个进程退出
+// 必须直接以defer调用，cleanup不为空时在恢复后调用，用于关闭该协程处理的连接
+func (s *SshTunnel) recoverPanic(cleanup func(err error)) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	err := panicError(value)
+	s.log.Errorf(fmt.Sprintf("[!] %s\n%s", err.Error(), debug.Stack()))
+	s.emit(Event{Type: EventPanic, Err: err})
+	if cleanup != nil {
+		cleanup(err)
+	}
+}
+
+// recoverPanic 恢复转发连接的协程中的panic，并触发EventPanic
+func (m *MemTunnel) recoverPanic(cleanup func(err error)) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	err := panicError(value)
+	if cleanup != nil {
+		cleanup(err)
+	}
+	if m.eventHandler != nil {
+		m.eventHandler(Event{
+			Type:     EventPanic,
+			Tunnel:   m.name,
+			TunnelID: m.id,
+			Labels:   m.labels,
+			State:    m.GetState(),
+			Err:      err,
+			Time:     time.Now(),
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProtocolSSH ssh隧道的协议名
+const ProtocolSSH = "SSH"
+
+var (
+	ErrProtocolRegistered   = errors.New("tunnel protocol already registered")
+	ErrProtocolUnregistered = errors.New("tunnel protocol not registered")
+)
+
+// TunnelFactory 按配置创建隧道的工厂
+type TunnelFactory func(tunnelConfig *TunnelConfig) (Tunnel, error)
+
+// Capabilities 隧道协议支持的能力
+type Capabilities struct {
+	SupportsUDP     bool // 是否支持转发udp
+	SupportsReverse bool // 是否支持反向隧道，即在隧道服务端监听并转发回本地
+}
+
+type registration struct {
+	name         string // 注册时的协议名
+	factory      TunnelFactory
+	capabilities Capabilities
+}
+
+// Registry 隧道协议的注册表，可以并发使用，协议名不区分大小写
+// 测试或插件可以创建独立的注册表，避免修改全局的DefaultRegistry
+type Registry struct {
+	mu            sync.RWMutex
+	registrations map[string]registration // key为大写的协议名
+}
+
+// DefaultRegistry 全局的注册表，Register、NewTunnel等函数使用该注册表
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{registrations: map[string]registration{}}
+}
+
+// Register 注册隧道协议，重复注册时返回ErrProtocolRegistered
+func (r *Registry) Register(name string, factory TunnelFactory, capabilities Capabilities) error {
+	if name == "" || factory == nil {
+		return errors.New("tunnel protocol name and factory are required")
+	}
+	key := strings.ToUpper(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.registrations[key]; ok {
+		return fmt.Errorf("%w: %s", ErrProtocolRegistered, name)
+	}
+	r.registrations[key] = registration{name: name, factory: factory, capabilities: capabilities}
+	return nil
+}
+
+// Deregister 取消注册隧道协议，已经创建的隧道不受影响，协议未注册时返回ErrProtocolUnregistered
+func (r *Registry) Deregister(name string) error {
+	key := strings.ToUpper(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.registrations[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrProtocolUnregistered, name)
+	}
+	delete(r.registrations, key)
+	return nil
+}
+
+// Factory 按协议名查找隧道工厂
+func (r *Registry) Factory(name string) (TunnelFactory, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.registrations[strings.ToUpper(name)]
+	return reg.factory, ok
+}
+
+// Capabilities 获取隧道协议支持的能力，协议未注册时返回false
+func (r *Registry) Capabilities(name string) (Capabilities, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.registrations[strings.ToUpper(name)]
+	return reg.capabilities, ok
+}
+
+// Protocols 获取所有已注册的协议名
+func (r *Registry) Protocols() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.registrations))
+	for _, reg := range r.registrations {
+		names = append(names, reg.name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// NewTunnel 使用注册表中的协议创建隧道，创建后需要以协程的方式调用Start
+func (r *Registry) NewTunnel(protocol string, opts ...Option) (Tunnel, error) {
+	tunnelFactoryFunc, ok := r.Factory(protocol)
+	if !ok {
+		return nil, &ConfigError{Field: "Protocol", Value: protocol, Err: ErrUnsupportedProtocol}
+	}
+	return newTunnel(tunnelFactoryFunc, protocol, opts)
+}
+
+// Register 在DefaultRegistry中注册隧道协议
+func Register(name string, factory TunnelFactory, capabilities Capabilities) error {
+	return DefaultRegistry.Register(name, factory, capabilities)
+}
+
+// Deregister 从DefaultRegistry中取消注册隧道协议
+func Deregister(name string) error {
+	return DefaultRegistry.Deregister(name)
+}
+
+// GetCapabilities 获取DefaultRegistry中隧道协议支持的能力，协议未注册时返回false
+func GetCapabilities(name string) (Capabilities, bool) {
+	return DefaultRegistry.Capabilities(name)
+}
+
+// lookupFactory 在DefaultRegistry中查找隧道工厂，兼容直接添加到CommunicationTunnelFactories中的工厂
+func lookupFactory(name string) (TunnelFactory, bool) {
+	if factory, ok := DefaultRegistry.Factory(name); ok {
+		return factory, true
+	}
+	factory, ok := CommunicationTunnelFactories[name]
+	return factory, ok
+}
+
+// registeredProtocols 获取DefaultRegistry及CommunicationTunnelFactories中所有的协议名
+func registeredProtocols() []string {
+	names := DefaultRegistry.Protocols()
+	for name := range CommunicationTunnelFactories {
+		if _, ok := DefaultRegistry.Factory(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,52 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+// 通过隧道与远端进行TLS握手的超时时间
+var remoteTLSHandshakeTimeout = 10 * time.Second
+
+// remoteTLSConfig 连接远端的TLS配置，ServerName为空时使用远端地址的主机名
+func (s *SshTunnel) remoteTLSConfig(base *tls.Config, target string) *tls.Config {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = splitHostPort(target)
+		// 远端地址与ssh服务地址相同时被替换为了localhost，校验证书时使用原来的地址
+		if config.ServerName == getRelativeRemoteAddr(s.serverHost, s.serverHost) {
+			config.ServerName = s.serverHost
+		}
+	}
+	return config
+}
+
+// dialForward 直接转发时连接远端地址，配置了RemoteTLS时在隧道内与远端进行TLS握手
+// 配置了ChannelPoolSize时优先使用预先建立的连接
+func (s *SshTunnel) dialForward(target string, latency *ConnLatency) (net.Conn, *ssh.Client, error) {
+	remoteConn, serverConn, ok := s.takePooledChannel(target, latency)
+	var err error
+	if !ok {
+		remoteConn, serverConn, err = s.dialRemote(target, latency)
+	}
+	if err != nil || s.remoteTLS == nil {
+		return remoteConn, serverConn, err
+	}
+	tlsConn := tls.Client(remoteConn, s.remoteTLSConfig(s.remoteTLS, target))
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTLSHandshakeTimeout)
+	defer cancel()
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error TLS handshake with remote endpoint %s: %s", target, err.Error()))
+		remoteConn.Close()
+		s.releaseClient(serverConn)
+		return nil, nil, err
+	}
+	return tlsConn, serverConn, nil
+}
@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+// 在本地解析远端地址的默认超时时间
+var defaultResolveTimeout = 5 * time.Second
+
+// dialThroughServer 通过已经建立的ssh连接连接到远端地址
+func (s *SshTunnel) dialThroughServer(serverConn *ssh.Client, target string) (net.Conn, error) {
+	return serverConn.Dial("tcp", s.resolveTarget(target))
+}
+
+// resolveTarget 按配置决定远端地址中的主机名在哪里解析
+// 默认原样交给ssh服务端解析；ResolveLocally为true时在本地解析为ip，本地无法解析或超时时仍然交给ssh服务端解析
+// localhost总是指ssh服务端自身，不会在本地解析；Hosts中有映射的主机名总是使用映射的ip
+func (s *SshTunnel) resolveTarget(target string) string {
+	if rewritten := s.hosts.rewrite(target); rewritten != target {
+		return rewritten
+	}
+	if !s.resolveLocally {
+		return target
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "localhost" || net.ParseIP(host) != nil {
+		return target
+	}
+	lookup := s.resolver.LookupIPAddr
+	if s.dnsCache != nil {
+		lookup = s.dnsCache.lookup
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.resolveTimeout)
+	defer cancel()
+	addrs, err := lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		s.connLog.Infof(fmt.Sprintf("[*] Could not resolve %s locally, let the tunnel server resolve it", host))
+		return target
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), port)
+}
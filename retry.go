@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"math/rand"
+	"net"
+	"time"
+)
+
+var (
+	defaultRemoteDialBackoff = 100 * time.Millisecond // 默认第一次重试前的等待时间
+	maxRemoteDialBackoff     = 5 * time.Second        // 重试等待时间的上限
+)
+
+// retryableDialError 判断通过隧道连接远端地址的错误是否值得重试
+// 只重试ssh服务端连接远端地址失败(如远端服务重启期间的connection refused)，ssh连接本身的错误重试也不会成功
+func retryableDialError(err error) bool {
+	var openErr *ssh.OpenChannelError
+	return errors.As(err, &openErr) && openErr.Reason == ssh.ConnectionFailed
+}
+
+// remoteDialDelay 第attempt次重试前的等待时间，每次翻倍并加上随机的抖动
+func (s *SshTunnel) remoteDialDelay(attempt int) time.Duration {
+	delay := s.remoteDialBackoff << (attempt - 1)
+	if delay <= 0 || delay > maxRemoteDialBackoff {
+		delay = maxRemoteDialBackoff
+	}
+	if s.remoteDialJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(s.remoteDialJitter)))
+	}
+	return delay
+}
+
+// dialThroughServerWithRetry 通过ssh连接连接远端地址，失败时按RemoteDialRetries重试，隧道停止时不再重试
+func (s *SshTunnel) dialThroughServerWithRetry(serverConn *ssh.Client, target string) (net.Conn, error) {
+	remoteConn, err := s.dialThroughServer(serverConn, target)
+	for attempt := 1; err != nil && attempt <= s.remoteDialRetries && retryableDialError(err); attempt++ {
+		delay := s.remoteDialDelay(attempt)
+		s.log.Infof(fmt.Sprintf("[*] Retrying connection to %s in %s (%d/%d): %s", target, delay, attempt, s.remoteDialRetries, err.Error()))
+		select {
+		case <-s.stopped:
+			return nil, err
+		case <-time.After(delay):
+		}
+		remoteConn, err = s.dialThroughServer(serverConn, target)
+	}
+	return remoteConn, err
+}
@@ -0,0 +1,242 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SOCKS5(RFC 1928)协议中使用的常量
+const (
+	socks5Version         = 0x05
+	socks5NoAuth          = 0x00
+	socks5NoAcceptable    = 0xff
+	socks5Connect         = 0x01
+	socks5AddrIPv4        = 0x01
+	socks5AddrDomain      = 0x03
+	socks5AddrIPv6        = 0x04
+	socks5Succeeded       = 0x00
+	socks5Failure         = 0x01
+	socks5Unreachable     = 0x04
+	socks5Refused         = 0x05
+	socks5CmdUnsupported  = 0x07
+	socks5AddrUnsupported = 0x08
+)
+
+var (
+	reverseSOCKSRetryInterval = 5 * time.Second  // ssh连接断开或在服务端监听失败后重新监听的间隔
+	reverseSOCKSDialTimeout   = 30 * time.Second // 从本地连接目标地址的超时时间
+)
+
+var errSOCKSVersion = errors.New("unsupported socks version")
+
+// runReverseSOCKS 在ssh服务端监听remoteSOCKSAddr，连接通过SOCKS5指定目标地址，由本地连接目标地址并转发
+// ssh连接断开后重新建立连接并监听，直到隧道停止
+func (s *SshTunnel) runReverseSOCKS() {
+	for {
+		if err := s.serveReverseSOCKS(); err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error serving SOCKS5 on ssh server at %s: %s", s.remoteSOCKSAddr, err.Error()))
+		}
+		select {
+		case <-s.stopped:
+			return
+		case <-time.After(reverseSOCKSRetryInterval):
+		}
+	}
+}
+
+// serveReverseSOCKS 在ssh服务端监听并接受连接，监听关闭(ssh连接断开或隧道停止)后返回
+func (s *SshTunnel) serveReverseSOCKS() error {
+	serverConn, err := s.serverClient()
+	if err != nil {
+		return err
+	}
+	defer s.releaseClient(serverConn)
+	addr := s.remoteSOCKSAddr
+	if previous, ok := s.RemoteSOCKSAddr().(*net.TCPAddr); ok {
+		// 重新监听时使用服务端之前分配的端口，调用方不需要更新地址
+		host, _, _ := net.SplitHostPort(addr)
+		addr = net.JoinHostPort(host, strconv.Itoa(previous.Port))
+	}
+	listener, err := serverConn.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on ssh server failed, err: %w", err)
+	}
+	if !s.track(listener) {
+		listener.Close()
+		return nil
+	}
+	defer s.untrack(listener)
+	defer listener.Close()
+	s.stateMu.Lock()
+	s.socksAddr = listener.Addr()
+	s.stateMu.Unlock()
+	s.log.Infof(fmt.Sprintf("[*] Listening SOCKS5 on ssh server at %s", listener.Addr()))
+	s.emit(Event{Type: EventRemoteListening, Message: "listening SOCKS5 on ssh server", Addr: listener.Addr()})
+	for {
+		remoteConn, err := listener.Accept()
+		if err != nil {
+			if s.IsStopped() || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if !s.track(remoteConn) {
+			remoteConn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(remoteConn)
+			defer s.recoverPanic(func(error) { remoteConn.Close() })
+			s.handleReverseSOCKS(remoteConn)
+		}()
+	}
+}
+
+// RemoteSOCKSAddr 在ssh服务端实际监听的SOCKS5地址，RemoteSOCKSAddr的端口为0时包括服务端分配的端口，开始监听前为nil
+func (s *SshTunnel) RemoteSOCKSAddr() net.Addr {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.socksAddr
+}
+
+// handleReverseSOCKS 完成SOCKS5握手，从本地连接请求的目标地址并转发数据，只支持CONNECT命令及无认证
+func (s *SshTunnel) handleReverseSOCKS(remoteConn net.Conn) {
+	defer remoteConn.Close()
+	target, err := readSOCKS5Request(remoteConn)
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error reading SOCKS5 request from ssh server: %s", err.Error()))
+		return
+	}
+	dialer := &net.Dialer{Resolver: s.resolver, Timeout: reverseSOCKSDialTimeout}
+	s.connLog.Infof(fmt.Sprintf("[*] Connecting to %s from local network for SOCKS5 on ssh server", target))
+	localConn, err := dialer.Dial("tcp", s.hosts.rewrite(target))
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error connecting to %s for SOCKS5 on ssh server: %s", target, err.Error()))
+		writeSOCKS5Reply(remoteConn, socks5DialReply(err), nil)
+		return
+	}
+	defer localConn.Close()
+	if err = writeSOCKS5Reply(remoteConn, socks5Succeeded, localConn.LocalAddr()); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		copyConn(localConn, remoteConn)
+		localConn.Close()
+	}()
+	copyConn(remoteConn, localConn)
+	remoteConn.Close()
+	<-done
+}
+
+// copyConn 使用复用的缓冲区从src复制数据到dst
+func copyConn(dst io.Writer, src io.Reader) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	io.CopyBuffer(dst, src, *buf)
+}
+
+// readSOCKS5Request 读取客户端的认证方式及CONNECT请求，返回目标的host:port，不支持的请求直接回复错误
+func readSOCKS5Request(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", errSOCKSVersion
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socks5NoAcceptable)
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5NoAcceptable {
+		return "", errors.New("socks client does not support no authentication")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socks5Version {
+		return "", errSOCKSVersion
+	}
+	var host string
+	switch request[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socks5AddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeSOCKS5Reply(conn, socks5AddrUnsupported, nil)
+		return "", fmt.Errorf("unsupported socks address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	if request[1] != socks5Connect {
+		writeSOCKS5Reply(conn, socks5CmdUnsupported, nil)
+		return "", fmt.Errorf("unsupported socks command %d", request[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSOCKS5Reply 回复CONNECT请求的结果，bound为本地连接目标时使用的地址
+func writeSOCKS5Reply(conn net.Conn, reply byte, bound net.Addr) error {
+	ip, port := net.IPv4zero.To4(), 0
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		ip, port = tcpAddr.IP, tcpAddr.Port
+	}
+	addrType := byte(socks5AddrIPv6)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, addrType = ip4, socks5AddrIPv4
+	}
+	message := append([]byte{socks5Version, reply, 0x00, addrType}, ip...)
+	message = binary.BigEndian.AppendUint16(message, uint16(port))
+	_, err := conn.Write(message)
+	return err
+}
+
+// socks5DialReply 按本地连接目标地址的错误选择回复的状态
+func socks5DialReply(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5Refused
+	case errors.As(err, &dnsErr):
+		return socks5Unreachable
+	default:
+		return socks5Failure
+	}
+}
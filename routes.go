@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+)
+
+// 按目标地址路由的动作
+const (
+	RouteTunnel = ""       // 通过隧道转发，默认的动作
+	RouteDirect = "direct" // 不经过隧道，直接从本地连接目标地址
+	RouteDeny   = "deny"   // 拒绝连接
+)
+
+var errRouteDenied = errors.New("destination denied by route rule")
+
+// RouteRule 按目标地址决定如何转发的规则，只在http-proxy、connect及transparent模式中生效
+type RouteRule struct {
+	// Match 匹配目标地址中的主机，可以是*(所有地址)、ip、CIDR(如10.0.0.0/8)、主机名或*.example.com形式的通配
+	// CIDR只匹配ip形式的目标地址，不会为了匹配规则解析主机名
+	Match  string
+	Action string   // 匹配后的动作，见RouteXXX
+	Dialer DialFunc // 设置后使用该DialFunc建立连接(如另一个隧道的Dialer())，忽略Action
+}
+
+// route 编译后的路由规则
+type route struct {
+	rule    RouteRule
+	network *net.IPNet
+	ip      net.IP
+}
+
+// compileRoutes 解析路由规则，规则无效时返回*ConfigError
+func compileRoutes(rules []RouteRule) ([]route, error) {
+	routes := make([]route, 0, len(rules))
+	for _, rule := range rules {
+		r := route{rule: rule}
+		r.rule.Match = strings.ToLower(strings.TrimSuffix(rule.Match, "."))
+		switch rule.Action {
+		case RouteTunnel, RouteDirect, RouteDeny:
+		default:
+			return nil, &ConfigError{Field: "Routes", Value: rule.Action, Err: ErrBadOption}
+		}
+		if r.rule.Match == "" {
+			return nil, &ConfigError{Field: "Routes", Err: ErrMissingEndpoint}
+		}
+		if strings.Contains(r.rule.Match, "/") {
+			_, network, err := net.ParseCIDR(r.rule.Match)
+			if err != nil {
+				return nil, &ConfigError{Field: "Routes", Value: rule.Match, Err: ErrBadOption}
+			}
+			r.network = network
+		} else {
+			r.ip = net.ParseIP(r.rule.Match)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// matches 判断规则是否匹配目标主机
+func (r *route) matches(host string, ip net.IP) bool {
+	switch {
+	case r.rule.Match == "*":
+		return true
+	case r.network != nil:
+		return ip != nil && r.network.Contains(ip)
+	case r.ip != nil:
+		return ip != nil && r.ip.Equal(ip)
+	case strings.HasPrefix(r.rule.Match, "*."):
+		return strings.HasSuffix(host, r.rule.Match[1:])
+	default:
+		return host == r.rule.Match
+	}
+}
+
+// matchRoute 查找第一条匹配目标地址的规则，没有匹配的规则时返回nil
+func (s *SshTunnel) matchRoute(target string) *RouteRule {
+	host, _, _ := splitHostPort(target)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for i := range s.routes {
+		if s.routes[i].matches(host, ip) {
+			return &s.routes[i].rule
+		}
+	}
+	return nil
+}
+
+// dialRoute 按路由规则连接目标地址，不经过隧道时返回的ssh连接为nil
+func (s *SshTunnel) dialRoute(target string, latency *ConnLatency) (net.Conn, *ssh.Client, error) {
+	rule := s.matchRoute(target)
+	if rule == nil || (rule.Dialer == nil && rule.Action == RouteTunnel) {
+		return s.dialRemote(target, latency)
+	}
+	if rule.Dialer == nil && rule.Action == RouteDeny {
+		s.log.Infof(fmt.Sprintf("[!] Connection to %s denied by route rule %s", target, rule.Match))
+		return nil, nil, errRouteDenied
+	}
+	dialFunc := rule.Dialer
+	if dialFunc == nil {
+		dialer := &net.Dialer{Resolver: s.resolver}
+		dialFunc = dialer.DialContext
+	}
+	s.connLog.Infof(fmt.Sprintf("[*] Connecting to %s without tunnel by route rule %s", target, rule.Match))
+	conn, err := dialFunc(context.Background(), "tcp", target)
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error connecting to %s by route rule %s: %s", target, rule.Match, err.Error()))
+		return nil, nil, err
+	}
+	return conn, nil, nil
+}
@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runShutdownTimeout Run及Serve退出时等待已有连接结束的最长时间，之后强制关闭剩余的连接
+var runShutdownTimeout = 30 * time.Second
+
+// Run 启动configs中的隧道并阻塞，直到收到SIGINT/SIGTERM或ctx结束后优雅地关闭所有隧道，见Serve
+// 任意一个隧道启动失败时停止已经启动的隧道并返回错误
+func Run(ctx context.Context, configs ...TunnelConfig) error {
+	tunnels := make([]Tunnel, 0, len(configs))
+	for _, config := range configs {
+		tunnel, err := FastStartTunnel(config)
+		if err != nil {
+			for _, started := range tunnels {
+				started.Stop()
+			}
+			return err
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	return Serve(ctx, tunnels...)
+}
+
+// Serve 阻塞直到收到SIGINT/SIGTERM、ctx结束或任意一个隧道意外停止，之后优雅地关闭所有隧道
+// 关闭时不再接受新的连接，等待已有的连接结束，最多等待runShutdownTimeout
+// 收到信号或ctx结束时返回nil，隧道意外停止时返回其停止的原因，强制关闭了剩余的连接时返回context.DeadlineExceeded
+func Serve(ctx context.Context, tunnels ...Tunnel) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stopped := make(chan Tunnel, len(tunnels))
+	for _, tunnel := range tunnels {
+		go func(tunnel Tunnel) {
+			select {
+			case <-tunnel.Done():
+				stopped <- tunnel
+			case <-ctx.Done():
+			}
+		}(tunnel)
+	}
+	var err error
+	select {
+	case <-ctx.Done():
+	case tunnel := <-stopped:
+		err = fmt.Errorf("tunnel %s stopped unexpectedly", tunnel.GetName())
+		if cause := startError(tunnel); !errors.Is(cause, errTunnelNotStarted) {
+			err = fmt.Errorf("%w: %w", err, cause)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), runShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, len(tunnels))
+	for i, tunnel := range tunnels {
+		wg.Add(1)
+		go func(i int, tunnel Tunnel) {
+			defer wg.Done()
+			errs[i] = tunnel.Shutdown(shutdownCtx)
+		}(i, tunnel)
+	}
+	wg.Wait()
+	return errors.Join(append([]error{err}, errs...)...)
+}
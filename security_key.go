@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+)
+
+// SecurityKey 硬件安全密钥(FIDO2/U2F)上的sk-ecdsa-sha2-nistp256@openssh.com或sk-ssh-ed25519@openssh.com密钥
+// 私钥无法从设备中导出，签名需要由调用方通过libfido2等库与设备交互完成
+type SecurityKey struct {
+	PublicKey []byte // authorized_keys格式的公钥，如id_ed25519_sk.pub的内容
+
+	// Sign 使用安全密钥对data签名，返回的签名的Rest中需要包含设备返回的flags及counter
+	Sign func(data []byte) (*ssh.Signature, error)
+
+	// PresencePrompt 每次签名前调用，用于提示用户触摸安全密钥，可以为空
+	PresencePrompt func(publicKey ssh.PublicKey)
+}
+
+// securityKeySigner 基于安全密钥的ssh.Signer
+type securityKeySigner struct {
+	key       *SecurityKey
+	publicKey ssh.PublicKey
+}
+
+func (s *securityKeySigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *securityKeySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	if s.key.PresencePrompt != nil {
+		s.key.PresencePrompt(s.publicKey)
+	}
+	return s.key.Sign(data)
+}
+
+// signer 校验安全密钥的配置并返回对应的ssh.Signer
+func (k *SecurityKey) signer() (ssh.Signer, error) {
+	if k.Sign == nil {
+		return nil, errors.New("security key requires a Sign function")
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(k.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse security key public key failed, err: %w", err)
+	}
+	switch publicKey.Type() {
+	case ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoSKED25519:
+	default:
+		return nil, fmt.Errorf("not a security key public key: %s", publicKey.Type())
+	}
+	return &securityKeySigner{key: k, publicKey: publicKey}, nil
+}
@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Session 在ssh服务端上执行命令的会话，关闭时同时关闭会话使用的ssh连接
+type Session struct {
+	*ssh.Session
+	client *ssh.Client
+}
+
+// Close 关闭会话及其ssh连接
+func (s *Session) Close() error {
+	err := s.Session.Close()
+	s.client.Close()
+	return err
+}
+
+// NewSession 建立新的ssh连接并打开一个会话，开启了ForwardAgent时，会话中执行的命令可以使用本地的ssh-agent认证
+func (s *SshTunnel) NewSession() (*Session, error) {
+	client, err := s.connectToServerSsh()
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh server failed, err: %w", err)
+	}
+	if !s.trackClient(client) {
+		return nil, errTunnelStopped
+	}
+
+	if s.forwardAgent {
+		keyring, err := s.agent.agent()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		if err := agent.ForwardToAgent(client, keyring); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("forward ssh agent failed, err: %w", err)
+		}
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("open ssh session failed, err: %w", err)
+	}
+	if s.forwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			// 服务端禁止了agent转发时，会话仍然可以使用
+			s.log.Infof(fmt.Sprintf("[!] Request agent forwarding failed: %s", err.Error()))
+		}
+	}
+	return &Session{Session: session, client: client}, nil
+}
+
+// CommandResult 在ssh服务端执行命令的结果
+type CommandResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int // 命令的退出码，命令被信号终止时为-1
+}
+
+// RunCommand 在隧道已经建立的ssh连接上执行命令并收集输出，适用于执行前置检查，如确认跳板机可以访问远端地址
+// 命令以非0退出码结束不是错误，通过ExitCode判断；ctx结束时向命令发送KILL信号并关闭会话
+func (s *SshTunnel) RunCommand(ctx context.Context, command string) (*CommandResult, error) {
+	client, err := s.serverClient()
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh server failed, err: %w", err)
+	}
+	defer s.releaseClient(client)
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("open ssh session failed, err: %w", err)
+	}
+	defer session.Close()
+	s.channelOpened(client)
+	defer s.channelClosed(client)
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return nil, ctx.Err()
+	case <-s.stopped:
+		return nil, errTunnelStopped
+	}
+
+	result := &CommandResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		if exitErr.Signal() != "" {
+			result.ExitCode = -1
+		}
+	default:
+		return nil, fmt.Errorf("run command on ssh server failed, err: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,364 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// SFTP(版本3)协议中使用的常量
+const (
+	sftpVersion = 3
+
+	sftpPacketInit    = 1
+	sftpPacketVersion = 2
+	sftpPacketOpen    = 3
+	sftpPacketClose   = 4
+	sftpPacketRead    = 5
+	sftpPacketWrite   = 6
+	sftpPacketStatus  = 101
+	sftpPacketHandle  = 102
+	sftpPacketData    = 103
+
+	sftpOpenRead   = 0x01
+	sftpOpenWrite  = 0x02
+	sftpOpenCreate = 0x08
+	sftpOpenTrunc  = 0x10
+
+	sftpAttrPermissions = 0x04
+
+	sftpStatusOK         = 0
+	sftpStatusEOF        = 1
+	sftpStatusNoSuchFile = 2
+	sftpStatusDenied     = 3
+)
+
+var (
+	sftpChunkSize     = 32 * 1024 // 每次读写的数据大小，服务端普遍支持的上限
+	sftpMaxPacketSize = 256 * 1024
+)
+
+// SFTPStatusError SFTP服务端返回的错误状态，文件不存在及无权限时可以通过errors.Is判断fs.ErrNotExist、fs.ErrPermission
+type SFTPStatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *SFTPStatusError) Error() string {
+	return fmt.Sprintf("sftp status %d: %s", e.Code, e.Message)
+}
+
+func (e *SFTPStatusError) Unwrap() error {
+	switch e.Code {
+	case sftpStatusNoSuchFile:
+		return fs.ErrNotExist
+	case sftpStatusDenied:
+		return fs.ErrPermission
+	}
+	return nil
+}
+
+// SFTPClient 通过隧道已经建立的ssh连接传输文件的SFTP客户端，只支持上传及下载文件
+// 请求按顺序发送，可以并发使用，使用完后需要调用Close
+type SFTPClient struct {
+	tunnel  *SshTunnel
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+
+	mu        sync.Mutex // 保证请求及响应一一对应
+	nextID    uint32
+	closeOnce sync.Once
+}
+
+// NewSFTPClient 在隧道的ssh连接上打开sftp子系统，共用ssh连接时不会建立新的连接
+func (s *SshTunnel) NewSFTPClient() (*SFTPClient, error) {
+	client, err := s.serverClient()
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh server failed, err: %w", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		s.releaseClient(client)
+		return nil, fmt.Errorf("open ssh session failed, err: %w", err)
+	}
+	c := &SFTPClient{tunnel: s, client: client, session: session}
+	if c.stdin, err = session.StdinPipe(); err == nil {
+		c.stdout, err = session.StdoutPipe()
+	}
+	if err == nil {
+		err = session.RequestSubsystem("sftp")
+	}
+	if err == nil {
+		err = c.init()
+	}
+	if err != nil {
+		session.Close()
+		s.releaseClient(client)
+		return nil, fmt.Errorf("start sftp failed, err: %w", err)
+	}
+	if !s.track(c) {
+		session.Close()
+		s.releaseClient(client)
+		return nil, errTunnelStopped
+	}
+	s.channelOpened(client)
+	return c, nil
+}
+
+// Close 关闭sftp会话，不共用ssh连接时同时关闭ssh连接
+func (c *SFTPClient) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.session.Close()
+		c.tunnel.channelClosed(c.client)
+		c.tunnel.untrack(c)
+		c.tunnel.releaseClient(c.client)
+	})
+	return err
+}
+
+// UploadFile 将本地文件上传到remotePath，远端文件已存在时覆盖，权限与本地文件相同
+func (c *SFTPClient) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	handle, err := c.open(remotePath, sftpOpenWrite|sftpOpenCreate|sftpOpenTrunc, uint32(info.Mode().Perm()))
+	if err != nil {
+		return fmt.Errorf("open remote file %s failed, err: %w", remotePath, err)
+	}
+	buf := make([]byte, sftpChunkSize)
+	var offset uint64
+	for {
+		n, readErr := file.Read(buf)
+		if n > 0 {
+			if err = c.write(handle, offset, buf[:n]); err != nil {
+				c.closeHandle(handle)
+				return fmt.Errorf("write remote file %s failed, err: %w", remotePath, err)
+			}
+			offset += uint64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			c.closeHandle(handle)
+			return readErr
+		}
+	}
+	return c.closeHandle(handle)
+}
+
+// DownloadFile 将远端文件下载到localPath，本地文件已存在时覆盖，失败时删除不完整的本地文件
+func (c *SFTPClient) DownloadFile(remotePath, localPath string) (err error) {
+	handle, err := c.open(remotePath, sftpOpenRead, 0)
+	if err != nil {
+		return fmt.Errorf("open remote file %s failed, err: %w", remotePath, err)
+	}
+	defer c.closeHandle(handle)
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(localPath)
+		}
+	}()
+	var offset uint64
+	for {
+		data, err := c.read(handle, offset, uint32(sftpChunkSize))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read remote file %s failed, err: %w", remotePath, err)
+		}
+		if _, err = file.Write(data); err != nil {
+			return err
+		}
+		offset += uint64(len(data))
+	}
+}
+
+// UploadFile 打开sftp会话上传一个文件，见SFTPClient.UploadFile
+func (s *SshTunnel) UploadFile(localPath, remotePath string) error {
+	c, err := s.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.UploadFile(localPath, remotePath)
+}
+
+// DownloadFile 打开sftp会话下载一个文件，见SFTPClient.DownloadFile
+func (s *SshTunnel) DownloadFile(remotePath, localPath string) error {
+	c, err := s.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.DownloadFile(remotePath, localPath)
+}
+
+// init 协商协议版本
+func (c *SFTPClient) init() error {
+	if err := c.writePacket(sftpPacketInit, binary.BigEndian.AppendUint32(nil, sftpVersion)); err != nil {
+		return err
+	}
+	packetType, payload, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if packetType != sftpPacketVersion || len(payload) < 4 {
+		return fmt.Errorf("unexpected sftp packet %d", packetType)
+	}
+	if version := binary.BigEndian.Uint32(payload); version != sftpVersion {
+		return fmt.Errorf("unsupported sftp version %d", version)
+	}
+	return nil
+}
+
+func (c *SFTPClient) open(path string, flags, perm uint32) ([]byte, error) {
+	request := appendSFTPString(nil, []byte(path))
+	request = binary.BigEndian.AppendUint32(request, flags)
+	if perm != 0 {
+		request = binary.BigEndian.AppendUint32(request, sftpAttrPermissions)
+		request = binary.BigEndian.AppendUint32(request, perm)
+	} else {
+		request = binary.BigEndian.AppendUint32(request, 0)
+	}
+	packetType, payload, err := c.request(sftpPacketOpen, request)
+	if err != nil {
+		return nil, err
+	}
+	if packetType != sftpPacketHandle {
+		return nil, fmt.Errorf("unexpected sftp packet %d", packetType)
+	}
+	handle, _, ok := readSFTPString(payload)
+	if !ok {
+		return nil, errors.New("malformed sftp handle")
+	}
+	return handle, nil
+}
+
+func (c *SFTPClient) closeHandle(handle []byte) error {
+	_, _, err := c.request(sftpPacketClose, appendSFTPString(nil, handle))
+	return err
+}
+
+func (c *SFTPClient) write(handle []byte, offset uint64, data []byte) error {
+	request := appendSFTPString(nil, handle)
+	request = binary.BigEndian.AppendUint64(request, offset)
+	request = appendSFTPString(request, data)
+	_, _, err := c.request(sftpPacketWrite, request)
+	return err
+}
+
+// read 读取offset处的数据，到达文件末尾时返回io.EOF
+func (c *SFTPClient) read(handle []byte, offset uint64, length uint32) ([]byte, error) {
+	request := appendSFTPString(nil, handle)
+	request = binary.BigEndian.AppendUint64(request, offset)
+	request = binary.BigEndian.AppendUint32(request, length)
+	packetType, payload, err := c.request(sftpPacketRead, request)
+	var statusErr *SFTPStatusError
+	if errors.As(err, &statusErr) && statusErr.Code == sftpStatusEOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if packetType != sftpPacketData {
+		return nil, fmt.Errorf("unexpected sftp packet %d", packetType)
+	}
+	data, _, ok := readSFTPString(payload)
+	if !ok {
+		return nil, errors.New("malformed sftp data")
+	}
+	return data, nil
+}
+
+// request 发送请求并等待响应，返回去掉请求id后的响应，状态不为成功时返回*SFTPStatusError
+func (c *SFTPClient) request(packetType byte, request []byte) (byte, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := c.nextID
+	if err := c.writePacket(packetType, append(binary.BigEndian.AppendUint32(nil, id), request...)); err != nil {
+		return 0, nil, err
+	}
+	responseType, payload, err := c.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) < 4 || binary.BigEndian.Uint32(payload) != id {
+		return 0, nil, errors.New("unexpected sftp response id")
+	}
+	payload = payload[4:]
+	if responseType != sftpPacketStatus {
+		return responseType, payload, nil
+	}
+	if len(payload) < 4 {
+		return 0, nil, errors.New("malformed sftp status")
+	}
+	code := binary.BigEndian.Uint32(payload)
+	if code == sftpStatusOK {
+		return responseType, nil, nil
+	}
+	message, _, _ := readSFTPString(payload[4:])
+	return 0, nil, &SFTPStatusError{Code: code, Message: string(message)}
+}
+
+func (c *SFTPClient) writePacket(packetType byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	packet = append(packet, packetType)
+	_, err := c.stdin.Write(append(packet, payload...))
+	return err
+}
+
+func (c *SFTPClient) readPacket() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.stdout, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > uint32(sftpMaxPacketSize) {
+		return 0, nil, fmt.Errorf("invalid sftp packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.stdout, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+func appendSFTPString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readSFTPString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	length := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < length {
+		return nil, nil, false
+	}
+	return b[4 : 4+length], b[4+length:], true
+}
@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"sync"
+)
+
+// sharedClient 所有本地连接共用的ssh连接
+type sharedClient struct {
+	mu       sync.Mutex
+	client   *ssh.Client
+	channels map[*ssh.Client]int  // 配置了MaxConnectionLifetime时每个共用过的ssh连接上打开的通道数
+	retired  map[*ssh.Client]bool // 已经达到MaxConnectionLifetime，等待通道结束后关闭的ssh连接
+}
+
+// serverClient 获取用于转发的ssh连接，默认复用已经建立的连接，配置了DedicatedConnection时每次建立新的连接
+func (s *SshTunnel) serverClient() (*ssh.Client, error) {
+	if !s.shareConnection {
+		config, err := s.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		return s.newServerClient(config)
+	}
+	s.shared.mu.Lock()
+	if client := s.shared.client; client != nil {
+		s.shared.mu.Unlock()
+		return client, nil
+	}
+	s.shared.mu.Unlock()
+	// 在锁外从CredentialProvider获取凭据，凭据服务较慢时不阻塞其他连接使用已经建立的ssh连接
+	config, err := s.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	if s.shared.client != nil {
+		return s.shared.client, nil
+	}
+	client, err := s.newServerClient(config)
+	if err != nil {
+		return nil, err
+	}
+	s.shared.client = client
+	s.scheduleRetire(client)
+	go func() {
+		// ssh连接断开后，下一个本地连接重新建立连接
+		client.Wait()
+		s.shared.mu.Lock()
+		if s.shared.client == client {
+			s.shared.client = nil
+		}
+		s.forgetClient(client)
+		s.shared.mu.Unlock()
+		s.log.Infof("[*] Shared ssh connection closed")
+	}()
+	return client, nil
+}
+
+// connectEagerly 启动时建立并认证ssh连接，共用ssh连接时之后的本地连接直接使用该连接
+func (s *SshTunnel) connectEagerly() error {
+	client, err := s.serverClient()
+	if err != nil {
+		return err
+	}
+	s.releaseClient(client)
+	return nil
+}
+
+// warmUp 在后台建立ssh连接，失败时只记录日志
+func (s *SshTunnel) warmUp() {
+	if err := s.connectEagerly(); err != nil {
+		s.log.Warnf(fmt.Sprintf("[!] Error warming up ssh connection: %s", err.Error()))
+		return
+	}
+	s.log.Infof("[*] Warmed up ssh connection")
+}
+
+// newServerClient 建立新的ssh连接，隧道停止时关闭
+func (s *SshTunnel) newServerClient(config *ssh.ClientConfig) (*ssh.Client, error) {
+	client, err := s.connectWithConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if !s.trackClient(client) {
+		return nil, errTunnelStopped
+	}
+	scheduleChaosDrop(s.chaos, client.Close)
+	return client, nil
+}
+
+// releaseClient 转发连接结束后释放ssh连接，共用的ssh连接不关闭
+func (s *SshTunnel) releaseClient(client *ssh.Client) {
+	if client == nil || s.shareConnection {
+		return
+	}
+	client.Close()
+}
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TunnelSnapshot 隧道某一时刻的状态，可以直接序列化为JSON，用于管理接口及命令行工具输出隧道的状态
+type TunnelSnapshot struct {
+	Name           string            `json:"name"`
+	ID             string            `json:"id"`
+	State          TunnelState       `json:"state"`
+	LocalEndpoint  string            `json:"local_endpoint"`
+	LocalAddr      string            `json:"local_addr,omitempty"` // 本地实际监听的地址，隧道开始监听前为空
+	RemoteEndpoint string            `json:"remote_endpoint"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Stats          *TunnelStats      `json:"stats,omitempty"` // 隧道的统计信息，内存隧道没有统计信息
+	// LastError 最近一次导致状态变化的错误(如健康检查失败)或启动失败的原因，没有错误时为空
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Snapshot 获取隧道当前的状态、端点、统计信息及最近的错误
+func (s *SshTunnel) Snapshot() TunnelSnapshot {
+	stats := s.Stats()
+	snapshot := TunnelSnapshot{
+		Name:           s.name,
+		ID:             s.id,
+		State:          s.GetState(),
+		LocalEndpoint:  s.GetLocalEndpoint(),
+		LocalAddr:      s.Addr(),
+		RemoteEndpoint: s.GetRemoteEndpoint(),
+		Labels:         stats.Labels,
+		Stats:          &stats,
+	}
+	s.stateMu.Lock()
+	snapshot.setLastError(s.lastErr, s.lastErrAt, s.bindErr)
+	s.stateMu.Unlock()
+	return snapshot
+}
+
+// MarshalJSON 将隧道序列化为Snapshot的结果
+func (s *SshTunnel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+// Snapshot 获取隧道当前的状态、端点及最近的错误
+func (m *MemTunnel) Snapshot() TunnelSnapshot {
+	snapshot := TunnelSnapshot{
+		Name:           m.name,
+		ID:             m.id,
+		State:          m.GetState(),
+		LocalEndpoint:  m.GetLocalEndpoint(),
+		LocalAddr:      m.Addr(),
+		RemoteEndpoint: m.GetRemoteEndpoint(),
+		Labels:         copyLabels(m.labels),
+	}
+	m.mu.Lock()
+	snapshot.setLastError(m.lastErr, m.lastErrAt, m.bindErr)
+	m.mu.Unlock()
+	return snapshot
+}
+
+// MarshalJSON 将隧道序列化为Snapshot的结果
+func (m *MemTunnel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Snapshot())
+}
+
+// setLastError 设置最近的错误，没有导致状态变化的错误时使用启动失败的原因
+func (t *TunnelSnapshot) setLastError(lastErr error, at time.Time, bindErr error) {
+	if lastErr != nil {
+		t.LastError, t.LastErrorAt = lastErr.Error(), &at
+	} else if bindErr != nil {
+		t.LastError = bindErr.Error()
+	}
+}
+
+// ManagedSnapshot 管理器中一个隧道某一时刻的状态
+type ManagedSnapshot struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Tenant  string     `json:"tenant,omitempty"`
+	Added   time.Time  `json:"added"`
+	Expires *time.Time `json:"expires,omitempty"` // TTL到期的时间，未配置TTL时为空
+	// Tunnel 正在运行的隧道的状态，不在激活时间窗口内时为空
+	Tunnel *TunnelSnapshot `json:"tunnel,omitempty"`
+}
+
+// ManagerSnapshot 管理器某一时刻的状态，可以直接序列化为JSON
+type ManagerSnapshot struct {
+	Tunnels []ManagedSnapshot `json:"tunnels"` // 按加入的时间排列
+}
+
+// Snapshot 获取管理器中所有隧道的状态
+func (m *TunnelManager) Snapshot() ManagerSnapshot {
+	return snapshotManaged(m.List())
+}
+
+// MarshalJSON 将管理器序列化为Snapshot的结果
+func (m *TunnelManager) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Snapshot())
+}
+
+// Snapshot 获取该租户所有隧道的状态
+func (t *TenantManager) Snapshot() ManagerSnapshot {
+	return snapshotManaged(t.List())
+}
+
+// MarshalJSON 将该租户的隧道序列化为Snapshot的结果
+func (t *TenantManager) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Snapshot())
+}
+
+func snapshotManaged(tunnels []ManagedTunnel) ManagerSnapshot {
+	snapshot := ManagerSnapshot{Tunnels: make([]ManagedSnapshot, 0, len(tunnels))}
+	for _, managed := range tunnels {
+		item := ManagedSnapshot{ID: managed.ID, Name: managed.Name, Tenant: managed.Tenant, Added: managed.Added}
+		if !managed.Expires.IsZero() {
+			item.Expires = &managed.Expires
+		}
+		if managed.Tunnel != nil {
+			tunnelSnapshot := managed.Tunnel.Snapshot()
+			item.Tunnel = &tunnelSnapshot
+		}
+		snapshot.Tunnels = append(snapshot.Tunnels, item)
+	}
+	return snapshot
+}
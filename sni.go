@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 等待客户端发送ClientHello的最长时间
+var sniPeekTimeout = 5 * time.Second
+
+// readOnlyConn 只能读取的连接，用于在不响应客户端的情况下解析ClientHello
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// peekServerName 读取TLS ClientHello中的SNI，返回的连接会重放已经读取的数据
+func peekServerName(conn net.Conn) (string, net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(sniPeekTimeout)); err != nil {
+		return "", nil, err
+	}
+	peeked := new(bytes.Buffer)
+	var serverName string
+	var helloReceived bool
+	err := tls.Server(readOnlyConn{reader: io.TeeReader(conn, peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			helloReceived = true
+			return nil, nil
+		},
+	}).Handshake()
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", nil, err
+	}
+	replayConn := &bufferedConn{Conn: conn, reader: io.MultiReader(peeked, conn)}
+	if !helloReceived {
+		return "", replayConn, err
+	}
+	return serverName, replayConn, nil
+}
+
+// routeByServerName 根据SNI查找对应的远端地址，支持*.example.com形式的通配
+// 路由中未指定端口时使用defaultPort
+func routeByServerName(routes map[string]string, serverName string, defaultPort int) (string, bool) {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	target, ok := routes[serverName]
+	if !ok {
+		// 从最长的后缀开始匹配通配的路由
+		labels := strings.Split(serverName, ".")
+		for i := 1; i < len(labels) && !ok; i++ {
+			target, ok = routes["*."+strings.Join(labels[i:], ".")]
+		}
+	}
+	if !ok {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, strconv.Itoa(defaultPort))
+	}
+	return target, true
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认重新解析SRV记录的间隔
+var defaultSRVRefreshInterval = 5 * time.Minute
+
+// isSRVName 判断隧道地址是否为SRV记录名，如_ssh._tcp.bastions.example.com
+func isSRVName(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "_") && strings.Contains(endpoint, "._tcp.")
+}
+
+// lookupSRVEndpoints 解析SRV记录，返回按优先级排列(同优先级按权重随机)的host:port
+func lookupSRVEndpoints(resolver *net.Resolver, name string) ([]string, error) {
+	_, records, err := resolver.LookupSRV(context.Background(), "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup srv record %s failed, err: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no srv record found for %s", name)
+	}
+	endpoints := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return endpoints, nil
+}
+
+// refreshSRVEndpoints 定期重新解析SRV记录并更新隧道地址列表，直到隧道停止
+// 解析失败时继续使用之前的地址列表
+func (s *SshTunnel) refreshSRVEndpoints() {
+	interval := s.srvRefreshInterval
+	if interval <= 0 {
+		interval = defaultSRVRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+		serverEndpoints, err := resolveServerEndpoints(s.tunnelEndpoints, s.remoteAddr, s.resolver)
+		if err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error refreshing tunnel endpoints: %s", err.Error()))
+			continue
+		}
+		s.failover.update(serverEndpoints)
+	}
+}
@@ -3,6 +3,7 @@
 package tunnel
 
 import (
+	"crypto/tls"
 	"fmt"
 	logger "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -10,59 +11,345 @@ import (
 	"math/rand"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	minLocalPort = 50000
 	maxLocalPort = 65000
+
+	// 双栈拨号时，优先尝试的地址族未连接成功后，等待多久开始尝试另一个地址族
+	dualStackFallbackDelay = 300 * time.Millisecond
 )
 
 // SshTunnel Tunnel 接口的实现.
 type SshTunnel struct {
 	name                 string
+	id                   string // 隧道唯一的ID
 	sshUsername          string
 	sshPassword          string
 	tunneledProtocol     string
-	localTunnelEndpoint  string // 本地监听的ip和端口
-	serverTunnelEndpoint string // 隧道监听的地址和端口
-	remoteEndpoint       string // 最终的远端地址
+	localTunnelEndpoint  string            // 本地监听的ip和端口
+	listener             net.Listener      // 本地的监听，可能由调用方提供
+	localAddrs           []string          // 额外的本地监听地址
+	forwards             map[string]string // 本地监听地址到远端地址的转发
+	forwardOnly          bool              // 只有Forwards中的转发，没有转发到RemoteAddr的监听
+	remoteSOCKSAddr      string            // 在ssh服务端监听的SOCKS5地址
+	maxLifetime          time.Duration     // 共用的ssh连接的最长使用时间
+	localListeners       []localListener   // 所有的本地监听
+	reusePort            bool              // 监听时是否设置SO_REUSEPORT
+	serverTunnelEndpoint string            // 隧道监听的地址和端口
+	remoteEndpoint       string            // 最终的远端地址
+	configMu             sync.RWMutex
 	config               *ssh.ClientConfig
-	localConns           []net.Conn    // 调用方和本地隧道监听端口之间已经建立的连接
-	sshConns             []*ssh.Client // 本地隧道服务和真实的隧道（如ssh地址）已经建立的连接
-	remoteConns          []net.Conn    // ssh服务端和真实的远端地址之间建立的连接
-	willClose            bool          // 隧道当前状态是否要变为关闭状态，用于在异常发生时判断隧道是手动关闭还是发生异常了
-	isClosed             bool          // 用于标记隧道是否关闭
+	authMethods          []ssh.AuthMethod // 调用方注入的认证方式，优先于内置的认证方式
+	connMu               sync.Mutex
+	stopOnce             sync.Once
+	closers              closerSet          // 隧道停止时需要关闭的本地连接、ssh连接及远端连接
+	activeConns          int                // 正在处理的本地连接数
+	drained              chan struct{}      // 排空时所有本地连接结束后关闭
+	idleShutdown         time.Duration      // 本地连接数为0持续该时间后停止隧道，为0时不自动停止
+	idleTimer            *time.Timer        // 空闲超时的计时器，有本地连接时停止
+	idleDisconnect       time.Duration      // 本地连接数为0持续该时间后断开共用的ssh连接，为0时不断开
+	disconnectTimer      *time.Timer        // 空闲断开ssh连接的计时器，有本地连接时停止
+	shareConnection      bool               // 所有本地连接是否共用一个ssh连接
+	shared               sharedClient       // 共用的ssh连接
+	willClose            atomic.Bool        // 隧道是否正在停止，用于在异常发生时判断隧道是手动关闭还是发生异常了
+	resetOnClose         bool               // 关闭本地连接时是否直接发送RST
+	lingerTimeout        time.Duration      // 关闭本地连接时的SO_LINGER时间
+	localMode            string             // 本地端点的工作模式
+	sniRoutes            map[string]string  // 按SNI路由的远端地址
+	routes               []route            // 按目标地址路由的规则
+	pacPath              string             // 提供PAC文件的路径，为空时不提供
+	rewriteHost          string             // 改写http请求Host头部使用的远端地址，为空时不改写
+	localTLS             *tls.Config        // 本地端点的TLS配置，为空时不使用TLS
+	remoteTLS            *tls.Config        // 连接远端的TLS配置，为空时不使用TLS
+	serverHost           string             // ssh服务的主机
+	remotePort           int                // 远端的端口，SNI路由中未指定端口时使用
+	balancer             *remoteBalancer    // 多个远端地址时的负载均衡
+	failover             *endpointFailover  // 多个隧道地址之间的故障切换
+	tunnelEndpoints      []string           // 配置的隧道地址，可能包含SRV记录名
+	remoteAddr           string             // 配置的远端地址，隧道地址只有端口时作为隧道的主机
+	resolveLocally       bool               // 远端地址中的主机名是否先在本地解析
+	resolveTimeout       time.Duration      // 在本地解析远端地址的超时时间
+	resolver             *net.Resolver      // 解析主机名使用的解析器
+	dnsCache             *dnsCache          // 隧道地址及远端地址的解析缓存，未配置DNSCacheTTL时为nil
+	hosts                staticHosts        // 在dns解析之前查找的主机名映射
+	h2Relay              *h2Relay           // 连接ssh服务的HTTP/2中继，为空时直接连接
+	tunnelDialer         DialFunc           // 连接ssh服务的DialFunc，为空时直接连接
+	bandwidth            *BandwidthLimiter  // 远端连接的限速器，为空时不限制
+	transferQuota        int64              // 转发流量的配额，为0时不限制
+	transferred          atomic.Int64       // 已经转发的流量
+	quotaReached         atomic.Bool        // 转发的流量是否已经达到配额
+	channelPool          *channelPool       // 预先建立的远端连接，为空时不预先建立
+	connectPolicy        string             // 建立ssh连接的时机
+	remoteDialRetries    int                // 连接远端地址失败时的重试次数
+	remoteDialBackoff    time.Duration      // 第一次重试前的等待时间
+	remoteDialJitter     time.Duration      // 重试等待时间的随机抖动
+	credentialProvider   CredentialProvider // 凭据的提供者
+	agent                *agentSource       // 本地的ssh-agent，未使用agent时为空
+	forwardAgent         bool               // 会话中是否转发ssh-agent
+	srvRefreshInterval   time.Duration      // 重新解析SRV记录的间隔
+	healthCheckInterval  time.Duration      // 健康检查的间隔
+	healthCheckPath      string             // http健康检查的路径
+	healthCheckTimeout   time.Duration      // http健康检查的超时时间
+	healthCheckTLS       bool               // 健康检查时是否校验远端的证书
+	eventHandler         EventHandler       // 隧道事件的回调
+	labels               map[string]string  // 隧道的标签，创建后不再修改
+	latency              latencyRecorder    // 转发连接的耗时统计
+	forwarders           concurrencyGauge   // 正在运行的转发协程
+	channels             concurrencyGauge   // 已经打开的ssh通道
+	warnThreshold        int                // 转发协程或ssh通道数量的告警阈值
+	chaos                *ChaosConfig       // 故障注入的配置
+	captureDir           string             // 保存pcap文件的目录
+	traceBytes           int                // 每个连接每个方向记录的字节数
+	mirrorAddr           string             // 镜像流量的地址
+	auditSink            AuditSink          // 审计记录的输出
+	log                  logger.FieldLogger // 隧道的日志
+	connLog              logger.FieldLogger // 每个连接的过程日志，按LogSampleRate采样
+	stateMu              sync.Mutex
+	state                TunnelState   // 隧道当前的状态
+	stopped              chan struct{} // 隧道停止时关闭
+	bound                chan struct{} // 本地端点监听成功或失败后关闭
+	bindErr              error         // 本地端点监听或启动时连接ssh服务失败的错误
+	socksAddr            net.Addr      // 在ssh服务端实际监听的SOCKS5地址
+	lastErr              error         // 最近一次导致状态变化的错误，如健康检查失败
+	lastErrAt            time.Time
 }
 
 func init() {
-	CommunicationTunnelFactories["SSH"] = SshTunnelFactory
+	if err := Register(ProtocolSSH, SshTunnelFactory, Capabilities{SupportsReverse: true}); err != nil {
+		panic(err)
+	}
 }
 
 // SshTunnelFactory ssh隧道实现
 func SshTunnelFactory(tunnelConfig *TunnelConfig) (Tunnel, error) {
+	if err := tunnelConfig.validateFields(); err != nil {
+		return nil, err
+	}
 	clientConfig := &ssh.ClientConfig{
 		User: tunnelConfig.Username,
 		Auth: []ssh.AuthMethod{
 			ssh.Password(tunnelConfig.Password),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         tunnelConfig.DialTimeout,
+		ClientVersion:   tunnelConfig.ClientVersion,
+	}
+	clientConfig.RekeyThreshold = tunnelConfig.RekeyThreshold
+	if tunnelConfig.AuthKeyFile != "" {
+		signer, err := loadKeyFile(tunnelConfig.AuthKeyFile, tunnelConfig.AuthKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.Auth = append([]ssh.AuthMethod{ssh.PublicKeys(signer)}, clientConfig.Auth...)
+	}
+	if tunnelConfig.SecurityKey != nil {
+		signer, err := tunnelConfig.SecurityKey.signer()
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.Auth = append([]ssh.AuthMethod{ssh.PublicKeys(signer)}, clientConfig.Auth...)
 	}
 
-	sshServerAddr, sshPort, err := getSSHServerAddrAndPort(tunnelConfig)
+	tunnelEndpoints := tunnelConfig.TunnelEndpoints
+	if len(tunnelEndpoints) == 0 {
+		tunnelEndpoints = []string{tunnelConfig.TunnelEndpoint}
+	}
+	resolver := tunnelConfig.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	serverEndpoints, err := resolveServerEndpoints(tunnelEndpoints, tunnelConfig.RemoteAddr, resolver)
 	if err != nil {
 		return nil, err
 	}
-	localPortNum := getRandomListeningPort()
-	relativeRemoteAddr := getRelativeRemoteAddr(sshServerAddr, tunnelConfig.RemoteAddr)
+	var sshServerAddr string
+	if len(tunnelEndpoints) == 1 && !isSRVName(tunnelEndpoints[0]) {
+		// 有多个隧道地址时，远端地址相对于每个隧道地址都不同，只能使用真实的远端地址
+		sshServerAddr, _, _ = splitHostPort(serverEndpoints[0])
+	}
+	localTunnelEndpoint := tunnelConfig.LocalAddr
+	listener := tunnelConfig.Listener
+	if listener == nil && isSystemdAddr(localTunnelEndpoint) {
+		if listener, err = systemdListenerFromAddr(localTunnelEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	if listener != nil {
+		localTunnelEndpoint = listener.Addr().String()
+	} else if localTunnelEndpoint == "" {
+		localTunnelEndpoint = fmt.Sprintf("localhost:%d", getRandomListeningPort())
+	}
+	var log logger.FieldLogger = logger.StandardLogger()
+	if tunnelConfig.Logger != nil {
+		log = tunnelConfig.Logger
+	}
+	name, id := tunnelIdentity(tunnelConfig)
+	log = withLogLevel(log, tunnelConfig.LogLevel, tunnelConfig.Quiet)
+	log = log.WithFields(logger.Fields{"tunnel": name, "tunnel_id": id})
+	labels := copyLabels(tunnelConfig.Labels)
+	if len(labels) > 0 {
+		log = log.WithFields(labelFields(labels))
+	}
+	remoteAddr, _, _ := splitHostPort(tunnelConfig.RemoteAddr)
+	relativeRemoteAddr := getRelativeRemoteAddr(sshServerAddr, remoteAddr)
 	tunnel := &SshTunnel{
-		name:                 tunnelConfig.Protocol,
+		name:                 name,
+		id:                   id,
 		sshUsername:          tunnelConfig.Username,
 		sshPassword:          tunnelConfig.Password,
-		localTunnelEndpoint:  fmt.Sprintf("localhost:%d", localPortNum),
-		serverTunnelEndpoint: fmt.Sprintf("%s:%d", sshServerAddr, sshPort),
-		remoteEndpoint:       fmt.Sprintf("%s:%d", relativeRemoteAddr, tunnelConfig.RemotePort),
+		localTunnelEndpoint:  localTunnelEndpoint,
+		localAddrs:           tunnelConfig.LocalAddrs,
+		shareConnection:      !tunnelConfig.DedicatedConnection || len(tunnelConfig.LocalAddrs) > 0 || len(tunnelConfig.Forwards) > 0 || tunnelConfig.ChannelPoolSize > 0 || tunnelConfig.RemoteSOCKSAddr != "",
+		forwardOnly:          tunnelConfig.RemoteAddr == "" && len(tunnelConfig.RemoteAddrs) == 0 && len(tunnelConfig.Forwards) > 0,
+		remoteSOCKSAddr:      tunnelConfig.RemoteSOCKSAddr,
+		maxLifetime:          tunnelConfig.MaxConnectionLifetime,
+		listener:             listener,
+		reusePort:            tunnelConfig.ReusePort,
+		serverTunnelEndpoint: serverEndpoints[0],
+		failover:             newEndpointFailover(serverEndpoints, tunnelConfig.FailbackInterval, resolver, log),
+		resolver:             resolver,
+		credentialProvider:   tunnelConfig.CredentialProvider,
+		forwardAgent:         tunnelConfig.ForwardAgent,
+		tunnelEndpoints:      tunnelEndpoints,
+		remoteAddr:           tunnelConfig.RemoteAddr,
+		resolveLocally:       tunnelConfig.ResolveLocally,
+		srvRefreshInterval:   tunnelConfig.SRVRefreshInterval,
+		remoteEndpoint:       net.JoinHostPort(relativeRemoteAddr, strconv.Itoa(tunnelConfig.RemotePort)),
 		config:               clientConfig,
 		tunneledProtocol:     tunnelConfig.TunneledProtocol,
+		resetOnClose:         tunnelConfig.ResetOnClose,
+		lingerTimeout:        tunnelConfig.LingerTimeout,
+		localMode:            tunnelConfig.LocalMode,
+		sniRoutes:            tunnelConfig.SNIRoutes,
+		remotePort:           tunnelConfig.RemotePort,
+		healthCheckInterval:  tunnelConfig.HealthCheckInterval,
+		healthCheckPath:      tunnelConfig.HealthCheckPath,
+		healthCheckTimeout:   tunnelConfig.HealthCheckTimeout,
+		healthCheckTLS:       tunnelConfig.HealthCheckTLS,
+		connectPolicy:        tunnelConfig.ConnectPolicy,
+		remoteDialRetries:    tunnelConfig.RemoteDialRetries,
+		remoteDialBackoff:    tunnelConfig.RemoteDialBackoff,
+		remoteDialJitter:     tunnelConfig.RemoteDialJitter,
+		eventHandler:         tunnelConfig.EventHandler,
+		labels:               labels,
+		chaos:                tunnelConfig.Chaos,
+		captureDir:           tunnelConfig.CaptureDir,
+		traceBytes:           tunnelConfig.TraceBytes,
+		mirrorAddr:           tunnelConfig.MirrorAddr,
+		auditSink:            tunnelConfig.AuditSink,
+		forwarders:           concurrencyGauge{name: "forwarder goroutines"},
+		channels:             concurrencyGauge{name: "ssh channels"},
+		warnThreshold:        tunnelConfig.ConcurrencyWarnThreshold,
+		log:                  log,
+		connLog:              newSampledLogger(log, tunnelConfig.LogSampleRate),
+		state:                StateStarting,
+		stopped:              make(chan struct{}),
+		bound:                make(chan struct{}),
+	}
+	clientConfig.BannerCallback = tunnel.bannerCallback(tunnelConfig.BannerCallback)
+	tunnel.pacPath = tunnelConfig.PACPath
+	tunnel.idleShutdown = tunnelConfig.IdleShutdown
+	tunnel.idleDisconnect = tunnelConfig.IdleDisconnect
+	tunnel.tunnelDialer = tunnelConfig.TunnelDialer
+	tunnel.resolveTimeout = defaultResolveTimeout
+	if tunnelConfig.DialTimeout > 0 {
+		tunnel.resolveTimeout = tunnelConfig.DialTimeout
+	}
+	tunnel.bandwidth = tunnelConfig.BandwidthLimiter
+	tunnel.transferQuota = tunnelConfig.TransferQuota
+	tunnel.failover.dialer = tunnelConfig.TunnelDialer
+	tunnel.hosts = newStaticHosts(tunnelConfig.Hosts)
+	tunnel.failover.hosts = tunnel.hosts
+	if tunnelConfig.DNSCacheTTL > 0 {
+		tunnel.dnsCache = newDNSCache(resolver, tunnelConfig.DNSCacheTTL, tunnelConfig.DNSNegativeCacheTTL)
+	}
+	if tunnelConfig.HostKeyPolicy != HostKeyInsecure {
+		clientConfig.HostKeyCallback = tunnel.hostKeyCallback(newHostKeyCheck(tunnelConfig))
+	}
+	if tunnelConfig.UseAgent || tunnelConfig.ForwardAgent {
+		tunnel.agent = newAgentSource(tunnelConfig.AgentSocket)
+	}
+	if tunnelConfig.UseAgent {
+		clientConfig.Auth = append([]ssh.AuthMethod{ssh.PublicKeysCallback(tunnel.agent.signers)}, clientConfig.Auth...)
+	}
+	if len(tunnelConfig.AuthMethods) > 0 {
+		tunnel.authMethods = tunnelConfig.AuthMethods
+		clientConfig.Auth = tunnel.withAuthMethods(clientConfig.Auth)
+	}
+	if tunnelConfig.HostKeyCallback != nil {
+		clientConfig.HostKeyCallback = tunnelConfig.HostKeyCallback
+	}
+	if tunnelConfig.LocalTLS || tunnelConfig.LocalTLSConfig != nil {
+		if tunnel.localTLS, err = newLocalTLSConfig(tunnelConfig, localTunnelEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	if tunnelConfig.RemoteTLS || tunnelConfig.RemoteTLSConfig != nil {
+		tunnel.remoteTLS = tunnelConfig.RemoteTLSConfig
+		if tunnel.remoteTLS == nil {
+			tunnel.remoteTLS = &tls.Config{}
+		}
+	}
+	tunnel.serverHost = sshServerAddr
+	if tunnelConfig.H2Relay != "" {
+		tunnel.h2Relay = newH2Relay(tunnelConfig.H2Relay, tunnelConfig.H2RelayHeader, tunnelConfig.H2RelayTLSConfig)
+		tunnel.failover.relay = tunnel.h2Relay
+	}
+	if tunnelConfig.RewriteHTTP && (tunnelConfig.RemoteAddr != "" || len(tunnelConfig.RemoteAddrs) > 0) {
+		rewriteAddr := tunnelConfig.RemoteAddr
+		if rewriteAddr == "" {
+			rewriteAddr = tunnelConfig.RemoteAddrs[0]
+		}
+		host, port, hasPort := splitHostPort(rewriteAddr)
+		if !hasPort {
+			port = strconv.Itoa(tunnelConfig.RemotePort)
+		}
+		portNum, _ := strconv.Atoi(port)
+		tunnel.rewriteHost = rewriteHostPort(host, portNum)
+	}
+	if tunnel.routes, err = compileRoutes(tunnelConfig.Routes); err != nil {
+		return nil, err
+	}
+	if tunnel.remoteDialBackoff <= 0 {
+		tunnel.remoteDialBackoff = defaultRemoteDialBackoff
+	}
+	if tunnel.healthCheckTimeout <= 0 {
+		tunnel.healthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if tunnelConfig.ConnectOnStart && tunnel.connectPolicy == ConnectLazy {
+		tunnel.connectPolicy = ConnectEager
+	}
+	if tunnelConfig.ChannelPoolSize > 0 {
+		tunnel.channelPool = newChannelPool(tunnel.remoteEndpoint, tunnelConfig.ChannelPoolSize, tunnelConfig.ChannelPoolIdleTimeout)
+	}
+	if len(tunnelConfig.Forwards) > 0 {
+		tunnel.forwards = make(map[string]string, len(tunnelConfig.Forwards))
+		for localAddr, remoteAddr := range tunnelConfig.Forwards {
+			host, port, _ := splitHostPort(remoteAddr)
+			tunnel.forwards[localAddr] = net.JoinHostPort(getRelativeRemoteAddr(sshServerAddr, host), port)
+		}
+	}
+	if len(tunnelConfig.RemoteAddrs) > 0 {
+		targets := make([]string, 0, len(tunnelConfig.RemoteAddrs))
+		for _, remoteAddr := range tunnelConfig.RemoteAddrs {
+			host, port, hasPort := splitHostPort(remoteAddr)
+			if !hasPort {
+				port = strconv.Itoa(tunnelConfig.RemotePort)
+			}
+			targets = append(targets, net.JoinHostPort(getRelativeRemoteAddr(sshServerAddr, host), port))
+		}
+		balancer, err := newRemoteBalancer(tunnelConfig.LoadBalance, targets)
+		if err != nil {
+			return nil, err
+		}
+		tunnel.balancer = balancer
+		tunnel.remoteEndpoint = targets[0]
 	}
 	return tunnel, nil
 }
@@ -70,108 +357,474 @@ func SshTunnelFactory(tunnelConfig *TunnelConfig) (Tunnel, error) {
 // 获取远端地址，ssh服务地址可能和远端地址相同
 func getRelativeRemoteAddr(sshServerAddr, remoteAddr string) string {
 	if sshServerAddr == remoteAddr {
+		if ip := net.ParseIP(remoteAddr); ip != nil && ip.To4() == nil {
+			// ipv6地址对应的服务可能只监听了ipv6，localhost可能会被解析为ipv4的地址
+			return "::1"
+		}
 		return "localhost"
 	}
 	return remoteAddr
 }
 
-func getSSHServerAddrAndPort(tunnelConfig *TunnelConfig) (string, int, error) {
-	sshEndpoint := tunnelConfig.TunnelEndpoint
+// resolveServerEndpoints 解析所有的隧道地址，SRV记录名会被展开为记录中的地址
+// 隧道地址只有端口时，使用defaultHost作为隧道的主机
+func resolveServerEndpoints(tunnelEndpoints []string, defaultHost string, resolver *net.Resolver) ([]string, error) {
+	serverEndpoints := make([]string, 0, len(tunnelEndpoints))
+	for _, tunnelEndpoint := range tunnelEndpoints {
+		if isSRVName(tunnelEndpoint) {
+			srvEndpoints, err := lookupSRVEndpoints(resolver, tunnelEndpoint)
+			if err != nil {
+				return nil, err
+			}
+			serverEndpoints = append(serverEndpoints, srvEndpoints...)
+			continue
+		}
+		serverAddr, serverPort, err := getSSHServerAddrAndPort(tunnelEndpoint, defaultHost)
+		if err != nil {
+			return nil, err
+		}
+		serverEndpoints = append(serverEndpoints, net.JoinHostPort(serverAddr, strconv.Itoa(serverPort)))
+	}
+	return serverEndpoints, nil
+}
+
+func getSSHServerAddrAndPort(sshEndpoint string, defaultHost string) (string, int, error) {
 	if portNum, err := strconv.Atoi(sshEndpoint); err == nil {
-		return tunnelConfig.RemoteAddr, portNum, nil
+		return defaultHost, portNum, nil
+	}
+	parser := EndpointParser{DefaultScheme: "ssh"}
+	endpoint, err := parser.Parse(sshEndpoint)
+	if err != nil {
+		return "", -1, err
 	}
-	return splitAddrAndPort(sshEndpoint, tunnelConfig.TunneledProtocol)
+	return endpoint.Host, endpoint.Port, nil
 }
 
 func (s *SshTunnel) GetName() string {
 	return s.name
 }
 
+func (s *SshTunnel) GetID() string {
+	return s.id
+}
+
 func (s *SshTunnel) GetLocalEndpoint() string {
-	return fmt.Sprintf("%s://%s", s.tunneledProtocol, s.localTunnelEndpoint)
+	endpoint := s.localTunnelEndpoint
+	// 监听随机端口或只有Forwards时使用实际监听的地址
+	if _, port, _ := splitHostPort(endpoint); port == "0" || s.forwardOnly {
+		if addr := s.Addr(); addr != "" {
+			endpoint = addr
+		}
+	}
+	scheme := s.tunneledProtocol
+	if s.localTLS != nil && strings.EqualFold(scheme, "http") {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, endpoint)
 }
 
 func (s *SshTunnel) GetRemoteEndpoint() string {
 	return fmt.Sprintf("%s://%s", s.tunneledProtocol, s.remoteEndpoint)
 }
 
+// LocalAddr 本地实际监听的地址，隧道开始监听前为nil
+func (s *SshTunnel) LocalAddr() net.Addr {
+	if listener := s.Listener(); listener != nil {
+		return listener.Addr()
+	}
+	return nil
+}
+
+// RemoteTarget 透过隧道后最终要连接的地址，多个远端地址时为第一个地址
+func (s *SshTunnel) RemoteTarget() *Endpoint {
+	return newTargetEndpoint(s.tunneledProtocol, s.remoteEndpoint)
+}
+
+func (s *SshTunnel) GetState() TunnelState {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// setState 更新隧道的状态，状态发生变化时触发EventStateChanged事件
+// 隧道停止后状态不再变化
+func (s *SshTunnel) setState(state TunnelState, err error) {
+	s.stateMu.Lock()
+	if err != nil && s.state != StateStopped {
+		s.lastErr, s.lastErrAt = err, time.Now()
+	}
+	// 停止后状态不再变化，排空时只能变为停止
+	if s.state == state || s.state == StateStopped || (s.state == StateDraining && state != StateStopped) {
+		s.stateMu.Unlock()
+		return
+	}
+	previous := s.state
+	s.state = state
+	s.stateMu.Unlock()
+
+	message := fmt.Sprintf("tunnel state changed from %s to %s", previous, state)
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] %s: %s", message, err.Error()))
+	} else {
+		s.log.Infof(fmt.Sprintf("[*] %s", message))
+	}
+	s.emit(Event{Type: EventStateChanged, Message: message, Err: err})
+}
+
+// emit 触发隧道事件
+func (s *SshTunnel) emit(event Event) {
+	if s.eventHandler == nil {
+		return
+	}
+	event.Tunnel = s.name
+	event.TunnelID = s.id
+	event.Labels = s.labels
+	event.State = s.GetState()
+	event.Time = time.Now()
+	s.eventHandler(event)
+}
+
 // Start 必须以协程的方式运行
 func (s *SshTunnel) Start(tunnelReady chan bool) {
-	logger.Infof(fmt.Sprintf("Starting local tunnel endpoint at %s", s.localTunnelEndpoint))
-	logger.Infof(fmt.Sprintf("Setting server tunnel endpoint at %s", s.serverTunnelEndpoint))
-	logger.Infof(fmt.Sprintf("Setting remote endpoint at %s", s.remoteEndpoint))
+	s.log.Infof(fmt.Sprintf("Starting local tunnel endpoint at %s", s.localTunnelEndpoint))
+	s.log.Infof(fmt.Sprintf("Setting server tunnel endpoint at %s", s.serverTunnelEndpoint))
+	s.log.Infof(fmt.Sprintf("Setting remote endpoint at %s", s.remoteEndpoint))
 
+	failStart := func(err error) {
+		s.markBound(err)
+		s.setState(StateStopped, err)
+		tunnelReady <- false
+	}
 	// 监听本地的隧道端点
-	listener, err := net.Listen("tcp", s.localTunnelEndpoint)
+	listeners, err := s.listenAll()
 	if err != nil {
-		logger.Infof(fmt.Sprintf("[!] Error setting SSH tunnel listener: %s", err.Error()))
-		tunnelReady <- false
+		s.log.Infof(fmt.Sprintf("[!] Error setting SSH tunnel listener: %s", err.Error()))
+		failStart(err)
 		return
 	}
-	defer listener.Close()
+	if s.connectPolicy == ConnectEager {
+		if err = s.connectEagerly(); err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error connecting to server SSH endpoint on start: %s", err.Error()))
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			failStart(err)
+			return
+		}
+	}
+	defer listeners[0].Close()
+	s.stateMu.Lock()
+	s.listener = listeners[0].Listener
+	s.localListeners = listeners
+	s.stateMu.Unlock()
+	s.markBound(nil)
+	s.setState(StateRunning, nil)
+	if s.healthCheckInterval > 0 {
+		go s.runHealthCheck()
+	}
+	if s.channelPool != nil {
+		go s.runChannelPool()
+	}
+	if s.connectPolicy == ConnectBackground {
+		go s.warmUp()
+	}
+	if s.remoteSOCKSAddr != "" {
+		go s.runReverseSOCKS()
+	}
+	if s.idleShutdown > 0 || s.idleDisconnect > 0 {
+		s.startIdleTimers()
+	}
+	if s.dnsCache != nil {
+		go s.dnsCache.run(s.stopped)
+	}
+	for _, tunnelEndpoint := range s.tunnelEndpoints {
+		if isSRVName(tunnelEndpoint) {
+			go s.refreshSRVEndpoints()
+			break
+		}
+	}
 	// 通知调用方，隧道已经准备好
 	tunnelReady <- true
+	for _, extraListener := range listeners[1:] {
+		go s.serve(extraListener)
+	}
+	s.serve(listeners[0])
+}
+
+// serve 接受本地监听上的连接并转发，监听关闭后返回
+func (s *SshTunnel) serve(listener localListener) {
+	if s.localTLS != nil {
+		// 握手在第一次读写时进行，不会阻塞接受连接
+		listener.Listener = tls.NewListener(listener.Listener, s.localTLS)
+	}
+	var backoff acceptBackoff
 	for {
 		// 监听本地连接，如果有新连接就负责转发
-		logger.Infof("[*] Listening on local tunnel endpoint")
+		s.connLog.Infof("[*] Listening on local tunnel endpoint")
 		localConn, err := listener.Accept()
 		if err != nil {
-			logger.Infof(fmt.Sprintf("[!] Error accepting local SSH tunnel connection: %s", err.Error()))
-			continue
+			if s.handleAcceptError(listener, &backoff, err) {
+				continue
+			}
+			return
 		}
-		logger.Infof("[*] Accepted connection on local SSH tunnel endpoint")
-		s.localConns = append(s.localConns, localConn)
-		go s.forwardConnection(localConn)
+		backoff.reset()
+		s.connLog.Infof("[*] Accepted connection on local SSH tunnel endpoint")
+		if err := setCloseBehavior(localConn, s.resetOnClose, s.lingerTimeout); err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error setting close behavior of local connection: %s", err.Error()))
+		}
+		if !s.track(localConn) {
+			// 隧道已经停止，不再转发新的连接
+			localConn.Close()
+			return
+		}
+		s.connStarted()
+		go func(localConn net.Conn) {
+			defer s.untrack(localConn)
+			defer s.connFinished()
+			defer s.recoverPanic(func(error) { localConn.Close() })
+			latency := &ConnLatency{Accepted: time.Now()}
+			switch {
+			case listener.target != "":
+				s.forwardTo(localConn, listener.target, latency)
+			case s.localMode == LocalModeHTTPProxy:
+				s.serveHTTPProxy(localConn, latency)
+			case s.localMode == LocalModeConnect:
+				s.serveConnect(localConn, latency)
+			case s.localMode == LocalModeTransparent:
+				s.serveTransparent(localConn, listener.Addr(), latency)
+			default:
+				s.forwardConnection(localConn, latency)
+			}
+		}(localConn)
+	}
+}
+
+// listen 按工作模式监听本地的隧道端点
+func (s *SshTunnel) listen() (net.Listener, error) {
+	if s.listener != nil {
+		return s.listener, nil
+	}
+	if s.localMode == LocalModeTransparent {
+		return listenTransparent(s.localTunnelEndpoint)
 	}
+	if s.reusePort {
+		return listenReusePort(s.localTunnelEndpoint)
+	}
+	return net.Listen("tcp", s.localTunnelEndpoint)
 }
 
 // 转发连接的数据
-func (s *SshTunnel) forwardConnection(localConn net.Conn) {
-	logger.Infof("[*] Forwarding connection to server")
-	// 连接到ssh服务端
-	logger.Infof("[*] try to connect to ssh server")
-	serverConn, err := s.connectToServerSsh()
+func (s *SshTunnel) forwardConnection(localConn net.Conn, latency *ConnLatency) {
+	s.connLog.Infof("[*] Forwarding connection to server")
+	if s.rewriteHost != "" {
+		localConn = s.newHTTPRewriter(localConn)
+	}
+	remoteEndpoint := ""
+	if len(s.sniRoutes) > 0 {
+		serverName, replayConn, err := peekServerName(localConn)
+		if err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error reading TLS ClientHello: %s", err.Error()))
+		}
+		if replayConn == nil {
+			localConn.Close()
+			return
+		}
+		localConn = replayConn
+		if target, ok := routeByServerName(s.sniRoutes, serverName, s.remotePort); ok {
+			s.connLog.Infof(fmt.Sprintf("[*] Routing TLS server name %s to %s", serverName, target))
+			remoteEndpoint = target
+		}
+	}
+	if remoteEndpoint == "" && s.balancer != nil {
+		s.forwardBalanced(localConn, latency)
+		return
+	}
+	if remoteEndpoint == "" {
+		remoteEndpoint = s.remoteEndpoint
+	}
+	remoteConn, serverConn, err := s.dialForward(remoteEndpoint, latency)
 	if err != nil {
-		logger.Infof(fmt.Sprintf("[!] Error connecting to server SSH endpoint: %s", err.Error()))
 		localConn.Close()
 		return
 	}
-	s.sshConns = append(s.sshConns, serverConn)
+	s.transfer(localConn, remoteConn, serverConn, remoteEndpoint, latency)
+}
+
+// forwardBalanced 按负载均衡策略选择远端地址转发，连接失败时依次尝试其他的远端地址
+func (s *SshTunnel) forwardBalanced(localConn net.Conn, latency *ConnLatency) {
+	for range s.balancer.targets {
+		index := s.balancer.pick()
+		target := s.balancer.targets[index]
+		s.connLog.Infof(fmt.Sprintf("[*] Balancing connection to %s", target))
+		remoteConn, serverConn, err := s.dialForward(target, latency)
+		if err != nil {
+			s.balancer.release(index)
+			continue
+		}
+		balancedConn := &releaseOnCloseConn{Conn: remoteConn, release: func() { s.balancer.release(index) }}
+		s.transfer(localConn, balancedConn, serverConn, target, latency)
+		return
+	}
+	s.log.Infof("[!] All remote endpoints are unavailable")
+	localConn.Close()
+}
+
+// dialRemote 建立ssh连接，并基于ssh隧道连接到指定的远端地址，latency不为空时记录各阶段的耗时
+func (s *SshTunnel) dialRemote(remoteEndpoint string, latency *ConnLatency) (net.Conn, *ssh.Client, error) {
+	if err := s.checkTransferQuota(); err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Rejecting connection to %s: %s", remoteEndpoint, err.Error()))
+		return nil, nil, err
+	}
+	// 连接到ssh服务端
+	s.connLog.Infof("[*] try to connect to ssh server")
+	start := time.Now()
+	if latency != nil && latency.SSHDial == 0 {
+		latency.Setup = start.Sub(latency.Accepted)
+	}
+	serverConn, err := s.serverClient()
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error connecting to server SSH endpoint: %s", err.Error()))
+		return nil, nil, err
+	}
 
 	// 基于ssh隧道直接向最终的服务地址建立连接
-	logger.Infof("[*] try to connect to final endpoint by ssh tunnel")
-	remoteConn, err := serverConn.Dial("tcp", s.remoteEndpoint)
+	s.connLog.Infof("[*] try to connect to final endpoint by ssh tunnel")
+	dialed := time.Now()
+	remoteConn, err := s.dialThroughServerWithRetry(serverConn, remoteEndpoint)
 	if err != nil {
-		logger.Infof(fmt.Sprintf("[!] Error connecting to remote endpoint: %s", err.Error()))
-		localConn.Close()
-		serverConn.Close()
-		return
+		s.log.Infof(fmt.Sprintf("[!] Error connecting to remote endpoint: %s", err.Error()))
+		s.releaseClient(serverConn)
+		return nil, nil, err
+	}
+	remoteConn = s.trackChannel(remoteConn, serverConn)
+	if !s.track(remoteConn) {
+		remoteConn.Close()
+		s.releaseClient(serverConn)
+		return nil, nil, errTunnelStopped
 	}
-	s.remoteConns = append(s.remoteConns, remoteConn)
+	if latency != nil {
+		latency.SSHDial = dialed.Sub(start)
+		latency.RemoteDial = time.Since(dialed)
+	}
+	remoteConn = &quotaConn{Conn: withChaos(remoteConn, s.chaos), tunnel: s}
+	return withBandwidthLimit(remoteConn, s.bandwidth), serverConn, nil
+}
 
-	logger.Infof("[*] Opened remote connection through tunnel, start forward traffic")
-	forwarderFunc := func(writer, reader net.Conn) {
+// transfer 转发本地连接和远程连接之间的流量，任意一个方向结束时关闭所有连接，两个方向都结束后返回
+func (s *SshTunnel) transfer(localConn, remoteConn net.Conn, serverConn *ssh.Client, target string, latency *ConnLatency) {
+	s.connLog.Infof("[*] Opened remote connection through tunnel, start forward traffic")
+	record := s.newAuditRecord(localConn, serverConn, target, latency)
+	if latency != nil {
+		opened := time.Now()
+		remoteConn = &firstByteConn{Conn: remoteConn, onFirstByte: func() {
+			latency.FirstByte = time.Since(opened)
+			s.recordLatency(*latency)
+		}}
+	}
+	if s.captureDir != "" {
+		pcap, err := newPcapWriter(s.captureDir, localConn.RemoteAddr(), remoteConn.RemoteAddr())
+		if err != nil {
+			s.log.Infof(fmt.Sprintf("[!] Error capturing traffic: %s", err.Error()))
+		} else {
+			remoteConn = &captureConn{Conn: remoteConn, pcap: pcap}
+		}
+	}
+	if s.mirrorAddr != "" {
+		remoteConn = newMirrorConn(remoteConn, s.mirrorAddr, s.log)
+	}
+	if s.traceBytes > 0 {
+		remoteConn = newTraceConn(remoteConn, s.log, s.traceBytes, localConn.RemoteAddr().String())
+	}
+	// 先结束的方向决定连接结束的原因，另一个方向随后因为连接被关闭而结束
+	var endOnce sync.Once
+	forwarderFunc := func(writer, reader net.Conn, closedReason string) int64 {
+		s.forwarderStarted()
+		defer s.forwarderFinished()
 		defer writer.Close()
 		defer reader.Close()
 
-		if _, err = io.Copy(writer, reader); err != nil {
-			if !s.willClose {
+		buf := copyBuffers.Get().(*[]byte)
+		n, err := io.CopyBuffer(writer, reader, *buf)
+		copyBuffers.Put(buf)
+		if err != nil {
+			endOnce.Do(func() {
+				record.Reason = AuditReasonError
+				if s.willClose.Load() {
+					record.Reason = AuditReasonStopped
+				}
+				record.Error = err.Error()
+			})
+			if !s.willClose.Load() {
 				// 如果不是调用方手动关闭的，需要显示具体的错误日志
-				logger.Infof(fmt.Sprintf("[!] I/O copy error when forwarding through tunnel: %s", err.Error()))
+				s.log.Infof(fmt.Sprintf("[!] I/O copy error when forwarding through tunnel: %s", err.Error()))
 			}
 			localConn.Close()
 			remoteConn.Close()
-			serverConn.Close()
-			s.isClosed = true
+			s.releaseClient(serverConn)
+		} else {
+			endOnce.Do(func() { record.Reason = closedReason })
 		}
+		return n
 	}
 	// 转发本地连接和远程连接之间的流量
-	go forwarderFunc(localConn, remoteConn)
-	go forwarderFunc(remoteConn, localConn)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer s.recoverPanic(func(error) {
+			localConn.Close()
+			remoteConn.Close()
+		})
+		record.BytesReceived = forwarderFunc(localConn, remoteConn, AuditReasonRemoteClosed)
+	}()
+	record.BytesSent = forwarderFunc(remoteConn, localConn, AuditReasonLocalClosed)
+	<-done
+	s.audit(record)
 }
 
 func (s *SshTunnel) connectToServerSsh() (*ssh.Client, error) {
-	return ssh.Dial("tcp", s.serverTunnelEndpoint, s.config)
+	config, err := s.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return s.connectWithConfig(config)
+}
+
+// connectWithConfig 使用已经获取凭据的配置连接ssh服务，按failover的顺序尝试各个隧道地址
+func (s *SshTunnel) connectWithConfig(config *ssh.ClientConfig) (*ssh.Client, error) {
+	return s.failover.dial(func(endpoint string) (*ssh.Client, error) {
+		return s.dialSSH(endpoint, config)
+	})
+}
+
+// dialSSH 连接到ssh服务并完成认证，主机名同时解析出ipv4和ipv6地址时，按Happy Eyeballs的方式同时尝试
+// 配置了H2Relay时通过中继连接ssh服务，配置了TunnelDialer时通过TunnelDialer连接
+func (s *SshTunnel) dialSSH(endpoint string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if s.h2Relay != nil {
+		conn, err = s.h2Relay.dial(endpoint, config.Timeout)
+	} else if s.tunnelDialer != nil {
+		conn, err = dialTimeout(s.tunnelDialer, s.hosts.rewrite(endpoint), config.Timeout)
+	} else {
+		dialer := net.Dialer{Timeout: config.Timeout, FallbackDelay: dualStackFallbackDelay, Resolver: s.resolver}
+		// 只替换连接的地址，主机公钥仍然按原来的主机名校验
+		dialAddr := s.hosts.rewrite(endpoint)
+		if s.dnsCache != nil {
+			conn, err = s.dnsCache.dialTCP(&dialer, dialAddr, dualStackFallbackDelay)
+		} else {
+			conn, err = dialer.Dial("tcp", dialAddr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, endpoint, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
 }
 
 // 获取随机监听的端口
@@ -179,18 +832,33 @@ func getRandomListeningPort() int {
 	return rand.Intn(maxLocalPort-minLocalPort) + minLocalPort
 }
 
-// Stop 停止隧道
+// Stop 停止隧道，可以多次及并发调用，返回后不会再接受或转发新的连接
 func (s *SshTunnel) Stop() {
-	logger.Infof("close conns established by tunnl")
-	s.willClose = true
-	for _, conn := range s.localConns {
-		conn.Close()
+	s.stopOnce.Do(s.stop)
+}
+
+func (s *SshTunnel) stop() {
+	s.log.Infof("close conns established by tunnl")
+	// 先关闭监听，停止后track不再记录新的连接
+	s.stateMu.Lock()
+	s.connMu.Lock()
+	s.willClose.Store(true)
+	close(s.stopped)
+	closers := s.closers
+	s.closers = nil
+	s.stopIdleTimers()
+	s.connMu.Unlock()
+	s.closeListeners()
+	s.stateMu.Unlock()
+	for closer := range closers {
+		closer.Close()
 	}
-	for _, client := range s.sshConns {
-		client.Close()
+	s.failover.stop()
+	if s.h2Relay != nil {
+		s.h2Relay.close()
 	}
-	for _, conn := range s.remoteConns {
-		conn.Close()
+	if s.agent != nil {
+		s.agent.close()
 	}
-	s.isClosed = true
+	s.setState(StateStopped, nil)
 }
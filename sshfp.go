@@ -0,0 +1,254 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// dnsTypeSSHFP SSHFP记录的类型(RFC 4255)
+const dnsTypeSSHFP dnsmessage.Type = 44
+
+var (
+	defaultSSHFPTimeout = 5 * time.Second // 查询SSHFP记录的超时时间
+	resolvConfPath      = "/etc/resolv.conf"
+)
+
+// sshfpAlgorithms 主机公钥类型对应的SSHFP算法编号
+var sshfpAlgorithms = map[string]uint8{
+	ssh.KeyAlgoRSA:      1,
+	ssh.KeyAlgoDSA:      2,
+	ssh.KeyAlgoECDSA256: 3,
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoED25519:  4,
+}
+
+// sshfpRecord SSHFP记录的内容
+type sshfpRecord struct {
+	algorithm   uint8
+	hashType    uint8 // 1为SHA-1，2为SHA-256
+	fingerprint []byte
+}
+
+// matches 判断主机公钥是否与记录一致
+func (r sshfpRecord) matches(key ssh.PublicKey) bool {
+	switch r.hashType {
+	case 1:
+		sum := sha1.Sum(key.Marshal())
+		return bytes.Equal(r.fingerprint, sum[:])
+	case 2:
+		sum := sha256.Sum256(key.Marshal())
+		return bytes.Equal(r.fingerprint, sum[:])
+	}
+	return false
+}
+
+// sshfpVerifier 按主机名的SSHFP记录校验主机公钥，用于主机公钥经常变化、不方便维护known_hosts的环境
+type sshfpVerifier struct {
+	server        string // dns服务器的地址
+	requireDNSSEC bool   // 只接受dns服务器已经通过DNSSEC验证(AD标记)的记录
+}
+
+// check 校验hostname的主机公钥，没有对应算法的记录时返回ErrHostKeyUnknown，记录不一致时返回ErrHostKeyMismatch
+func (v *sshfpVerifier) check(hostname string, key ssh.PublicKey) error {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	if net.ParseIP(host) != nil {
+		return fmt.Errorf("%w: SSHFP requires a host name, got %s", ErrHostKeyUnknown, host)
+	}
+	algorithm, ok := sshfpAlgorithms[key.Type()]
+	if !ok {
+		return fmt.Errorf("%w: no SSHFP algorithm for %s key of %s", ErrHostKeyUnknown, key.Type(), host)
+	}
+	server := v.server
+	if server == "" {
+		if server, err = systemNameserver(); err != nil {
+			return err
+		}
+	}
+	records, authenticated, err := lookupSSHFP(server, host, defaultSSHFPTimeout)
+	if err != nil {
+		return fmt.Errorf("lookup SSHFP records of %s failed, err: %w", host, err)
+	}
+	if v.requireDNSSEC && !authenticated {
+		return fmt.Errorf("%w: SSHFP records of %s are not validated by DNSSEC", ErrHostKeyUnknown, host)
+	}
+	found := false
+	for _, record := range records {
+		if record.algorithm != algorithm {
+			continue
+		}
+		found = true
+		if record.matches(key) {
+			return nil
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: no SSHFP record for %s key of %s", ErrHostKeyUnknown, key.Type(), host)
+	}
+	return fmt.Errorf("%w: %s key of %s is %s, which matches no SSHFP record, possible man-in-the-middle attack",
+		ErrHostKeyMismatch, key.Type(), host, ssh.FingerprintSHA256(key))
+}
+
+// systemNameserver 返回/etc/resolv.conf中的第一个dns服务器
+func systemNameserver() (string, error) {
+	file, err := os.Open(resolvConfPath)
+	if err != nil {
+		return "", fmt.Errorf("read dns config failed, set SSHFPResolver instead, err: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver in %s, set SSHFPResolver instead", resolvConfPath)
+}
+
+// lookupSSHFP 向server查询host的SSHFP记录，authenticated为响应中的AD标记
+// 请求中设置EDNS0的DO标记，响应被截断时通过tcp重新查询
+func lookupSSHFP(server, host string, timeout time.Duration) (records []sshfpRecord, authenticated bool, err error) {
+	name, err := dnsmessage.NewName(dnsFQDN(host))
+	if err != nil {
+		return nil, false, err
+	}
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, err := buildSSHFPQuery(id, name)
+	if err != nil {
+		return nil, false, err
+	}
+	response, err := exchangeDNS("udp", server, query, timeout)
+	if err == nil {
+		var header dnsmessage.Header
+		var parser dnsmessage.Parser
+		if header, err = parser.Start(response); err == nil && header.Truncated {
+			response, err = exchangeDNS("tcp", server, query, timeout)
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return parseSSHFPResponse(id, response)
+}
+
+func dnsFQDN(host string) string {
+	if strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
+
+func buildSSHFPQuery(id uint16, name dnsmessage.Name) ([]byte, error) {
+	// 设置AD标记表示可以理解响应中的AD标记(RFC 6840)
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: dnsTypeSSHFP, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var optHeader dnsmessage.ResourceHeader
+	if err := optHeader.SetEDNS0(4096, dnsmessage.RCodeSuccess, true); err != nil {
+		return nil, err
+	}
+	if err := builder.OPTResource(optHeader, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+// exchangeDNS 发送查询并读取响应，tcp时消息前有2字节的长度
+func exchangeDNS(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if network == "tcp" {
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		var length [2]byte
+		if _, err = io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err = io.ReadFull(conn, response)
+		return response, err
+	}
+	response := make([]byte, 65535)
+	n, err := conn.Read(response)
+	return response[:n], err
+}
+
+func parseSSHFPResponse(id uint16, response []byte) ([]sshfpRecord, bool, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, false, err
+	}
+	if header.ID != id || !header.Response {
+		return nil, false, errors.New("unexpected dns response")
+	}
+	if header.RCode == dnsmessage.RCodeNameError {
+		return nil, header.AuthenticData, nil
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, false, fmt.Errorf("dns query failed, rcode: %s", header.RCode)
+	}
+	if err = parser.SkipAllQuestions(); err != nil {
+		return nil, false, err
+	}
+	var records []sshfpRecord
+	for {
+		answer, err := parser.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if answer.Type != dnsTypeSSHFP {
+			if err = parser.SkipAnswer(); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		resource, err := parser.UnknownResource()
+		if err != nil {
+			return nil, false, err
+		}
+		if len(resource.Data) < 3 {
+			continue
+		}
+		records = append(records, sshfpRecord{algorithm: resource.Data[0], hashType: resource.Data[1], fingerprint: resource.Data[2:]})
+	}
+	return records, header.AuthenticData, nil
+}
@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// staticHosts 类似/etc/hosts的主机名到ip的映射，在dns解析之前查找，主机名不区分大小写
+type staticHosts map[string]net.IP
+
+func newStaticHosts(hosts map[string]string) staticHosts {
+	if len(hosts) == 0 {
+		return nil
+	}
+	static := make(staticHosts, len(hosts))
+	for host, ip := range hosts {
+		static[normalizeHostname(host)] = net.ParseIP(ip)
+	}
+	return static
+}
+
+// checkStaticHosts 检查映射中的主机名及ip，返回第一个无效的主机名
+func checkStaticHosts(hosts map[string]string) (string, error) {
+	for host, ip := range hosts {
+		if normalizeHostname(host) == "" {
+			return host, errors.New("empty host name")
+		}
+		if net.ParseIP(ip) == nil {
+			return host, fmt.Errorf("invalid ip %q", ip)
+		}
+	}
+	return "", nil
+}
+
+func normalizeHostname(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// lookup 返回主机名映射的ip
+func (h staticHosts) lookup(host string) (net.IP, bool) {
+	if h == nil {
+		return nil, false
+	}
+	ip, ok := h[normalizeHostname(host)]
+	return ip, ok
+}
+
+// rewrite 将host:port中有映射的主机名替换为ip，没有映射时原样返回
+func (h staticHosts) rewrite(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, ok := h.lookup(host); ok {
+		return net.JoinHostPort(ip.String(), port)
+	}
+	return addr
+}
@@ -0,0 +1,144 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnLatency 一个转发连接建立过程中各阶段的耗时，用于判断是跳板机还是远端服务较慢
+type ConnLatency struct {
+	Accepted   time.Time     `json:"accepted"`    // 接受本地连接的时间
+	Setup      time.Duration `json:"setup"`       // 接受连接到开始连接隧道的时间，如读取http代理请求或TLS ClientHello
+	SSHDial    time.Duration `json:"ssh_dial"`    // 获取ssh连接的时间，包括tcp连接、握手及认证
+	RemoteDial time.Duration `json:"remote_dial"` // 通过ssh连接打开到远端地址的通道的时间
+	FirstByte  time.Duration `json:"first_byte"`  // 连接到远端地址后到收到远端第一个字节的时间
+}
+
+// Total 接受本地连接到收到远端第一个字节的总耗时
+func (l ConnLatency) Total() time.Duration {
+	return l.Setup + l.SSHDial + l.RemoteDial + l.FirstByte
+}
+
+// LatencySummary 一个阶段的耗时统计
+type LatencySummary struct {
+	Avg time.Duration `json:"avg"`
+	Max time.Duration `json:"max"`
+}
+
+// LatencyStats 所有已经收到远端数据的转发连接的耗时统计
+// 序列化为JSON时耗时的单位为纳秒
+type LatencyStats struct {
+	Count      int64          `json:"count"` // 统计的连接数
+	Setup      LatencySummary `json:"setup"`
+	SSHDial    LatencySummary `json:"ssh_dial"`
+	RemoteDial LatencySummary `json:"remote_dial"`
+	FirstByte  LatencySummary `json:"first_byte"`
+	Total      LatencySummary `json:"total"`
+	Last       ConnLatency    `json:"last"` // 最近一个连接的耗时
+}
+
+// TunnelStats 隧道的统计信息
+type TunnelStats struct {
+	Name       string            `json:"name"`
+	ID         string            `json:"id"`
+	Labels     map[string]string `json:"labels,omitempty"` // 隧道的标签
+	Latency    LatencyStats      `json:"latency"`
+	Forwarders int64             `json:"forwarders"` // 正在转发流量的协程数，每个转发连接两个
+	Channels   int64             `json:"channels"`   // 通过ssh连接打开且还未关闭的通道数
+	// Transferred 通过隧道转发的总字节数(两个方向合计)，配置了TransferQuota时用于计算配额，ResetTransferQuota后重新计算
+	Transferred int64 `json:"transferred"`
+}
+
+// latencySum 一个阶段耗时的累计值
+type latencySum struct {
+	sum time.Duration
+	max time.Duration
+}
+
+func (l *latencySum) add(d time.Duration) {
+	l.sum += d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+func (l *latencySum) summary(count int64) LatencySummary {
+	if count == 0 {
+		return LatencySummary{}
+	}
+	return LatencySummary{Avg: l.sum / time.Duration(count), Max: l.max}
+}
+
+// latencyRecorder 累计转发连接的耗时
+type latencyRecorder struct {
+	mu                                           sync.Mutex
+	count                                        int64
+	setup, sshDial, remoteDial, firstByte, total latencySum
+	last                                         ConnLatency
+}
+
+func (r *latencyRecorder) record(latency ConnLatency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	r.setup.add(latency.Setup)
+	r.sshDial.add(latency.SSHDial)
+	r.remoteDial.add(latency.RemoteDial)
+	r.firstByte.add(latency.FirstByte)
+	r.total.add(latency.Total())
+	r.last = latency
+}
+
+func (r *latencyRecorder) stats() LatencyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return LatencyStats{
+		Count:      r.count,
+		Setup:      r.setup.summary(r.count),
+		SSHDial:    r.sshDial.summary(r.count),
+		RemoteDial: r.remoteDial.summary(r.count),
+		FirstByte:  r.firstByte.summary(r.count),
+		Total:      r.total.summary(r.count),
+		Last:       r.last,
+	}
+}
+
+// firstByteConn 第一次读取到数据时回调，用于统计远端返回第一个字节的时间
+type firstByteConn struct {
+	net.Conn
+	once        sync.Once
+	onFirstByte func()
+}
+
+func (c *firstByteConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.once.Do(c.onFirstByte)
+	}
+	return n, err
+}
+
+// recordLatency 记录连接的耗时并触发事件
+func (s *SshTunnel) recordLatency(latency ConnLatency) {
+	s.latency.record(latency)
+	s.emit(Event{
+		Type:    EventConnLatency,
+		Message: fmt.Sprintf("setup %s, ssh dial %s, remote dial %s, first byte %s", latency.Setup, latency.SSHDial, latency.RemoteDial, latency.FirstByte),
+		Latency: &latency,
+	})
+}
+
+// Stats 获取隧道的统计信息
+func (s *SshTunnel) Stats() TunnelStats {
+	return TunnelStats{
+		Name:        s.name,
+		ID:          s.id,
+		Labels:      copyLabels(s.labels),
+		Latency:     s.latency.stats(),
+		Forwarders:  s.forwarders.active.Load(),
+		Channels:    s.channels.active.Load(),
+		Transferred: s.transferred.Load(),
+	}
+}
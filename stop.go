@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+)
+
+var errTunnelStopped = errors.New("tunnel is stopped")
+
+// closerSet 隧道停止时需要关闭的连接
+type closerSet map[io.Closer]struct{}
+
+// track 记录隧道停止时需要关闭的连接，隧道正在停止时返回false，调用方需要自行关闭
+func (s *SshTunnel) track(closer io.Closer) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.IsStopped() {
+		return false
+	}
+	if s.closers == nil {
+		s.closers = closerSet{}
+	}
+	s.closers[closer] = struct{}{}
+	return true
+}
+
+// untrack 连接已经关闭，隧道停止时不再需要关闭
+func (s *SshTunnel) untrack(closer io.Closer) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	delete(s.closers, closer)
+}
+
+// trackClient 记录ssh连接，ssh连接断开后自动移除，隧道正在停止时关闭ssh连接并返回false
+func (s *SshTunnel) trackClient(client *ssh.Client) bool {
+	if !s.track(client) {
+		client.Close()
+		return false
+	}
+	go func() {
+		client.Wait()
+		s.untrack(client)
+	}()
+	return true
+}
+
+// IsStopped 隧道是否已经停止
+func (s *SshTunnel) IsStopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done 返回隧道停止时关闭的channel
+func (s *SshTunnel) Done() <-chan struct{} {
+	return s.stopped
+}
@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LocalAddrSystemd 作为LocalAddr时使用systemd socket激活传入的监听，
+// 也可以是systemd:name的形式，按socket单元中的FileDescriptorName选择监听
+const LocalAddrSystemd = "systemd"
+
+// systemd传入的第一个文件描述符，见sd_listen_fds(3)
+const listenFdsStart = 3
+
+var (
+	ErrNoSystemdListener = errors.New("no listener passed by systemd")
+
+	systemdOnce      sync.Once
+	systemdListeners []net.Listener
+	systemdNames     []string
+	systemdErr       error
+)
+
+// SystemdListeners 获取systemd socket激活时传入的监听(LISTEN_FDS)，按socket单元中的顺序排列
+// 也可以是旧进程通过HandOff交接的监听，读取后会清除LISTEN_PID、LISTEN_FDS及LISTEN_FDNAMES等环境变量，避免被子进程继承，多次调用返回相同的监听
+func SystemdListeners() ([]net.Listener, error) {
+	systemdOnce.Do(loadSystemdListeners)
+	return systemdListeners, systemdErr
+}
+
+// SystemdListener 获取FileDescriptorName为name的监听，name为空时返回第一个监听
+func SystemdListener(name string) (net.Listener, error) {
+	listeners, err := SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	for i, listener := range listeners {
+		if name == "" || systemdNames[i] == name {
+			return listener, nil
+		}
+	}
+	if name == "" {
+		return nil, ErrNoSystemdListener
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoSystemdListener, name)
+}
+
+func loadSystemdListeners() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		os.Unsetenv(handoffParentPidEnv)
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	parentPid, parentErr := strconv.Atoi(os.Getenv(handoffParentPidEnv))
+	if (err != nil || pid != os.Getpid()) && (parentErr != nil || parentPid != os.Getppid()) {
+		// 不是由systemd或HandOff为当前进程传入的
+		return
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			systemdErr = fmt.Errorf("use systemd socket %s failed, err: %w", name, err)
+			return
+		}
+		systemdListeners = append(systemdListeners, listener)
+		systemdNames = append(systemdNames, name)
+	}
+}
+
+// isSystemdAddr 本地地址是否指定使用systemd传入的监听
+func isSystemdAddr(addr string) bool {
+	return addr == LocalAddrSystemd || strings.HasPrefix(addr, LocalAddrSystemd+":")
+}
+
+// systemdListenerFromAddr 按systemd或systemd:name形式的本地地址获取监听
+func systemdListenerFromAddr(addr string) (net.Listener, error) {
+	return SystemdListener(strings.TrimPrefix(strings.TrimPrefix(addr, LocalAddrSystemd), ":"))
+}
@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"fmt"
+	logger "github.com/sirupsen/logrus"
+	"net"
+	"regexp"
+	"sync"
+)
+
+// maxTraceBytes 每个方向最多记录的字节数
+const maxTraceBytes = 64 * 1024
+
+// 记录前需要隐藏的凭据，只隐藏第一个分组中的值，保持数据长度不变以便对照偏移
+var traceRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:authorization|proxy-authorization|cookie|set-cookie|x-api-key|x-auth-token):[ \t]*([^\r\n]*)`),
+	regexp.MustCompile(`(?i)(?:password|passwd|pwd|token|secret|api_key|apikey|access_token)=([^&\s"]*)`),
+	regexp.MustCompile(`(?i)"(?:password|passwd|token|secret|api_key|access_token)"[ \t]*:[ \t]*"([^"]*)"`),
+	regexp.MustCompile(`(?im)^(?:PASS|AUTH)[ \t]+([^\r\n]*)`),
+}
+
+// redactCredentials 将数据中明显的凭据替换为*
+func redactCredentials(data []byte) []byte {
+	redacted := append([]byte(nil), data...)
+	for _, pattern := range traceRedactPatterns {
+		for _, match := range pattern.FindAllSubmatchIndex(redacted, -1) {
+			for i := match[2]; i < match[3]; i++ {
+				redacted[i] = '*'
+			}
+		}
+	}
+	return redacted
+}
+
+// traceBuffer 一个方向上记录的数据，达到上限后输出
+type traceBuffer struct {
+	direction string
+	data      []byte
+	logged    bool
+}
+
+// traceConn 以hexdump的形式记录每个方向的前若干字节，写入的数据为本地发往远端的，读取的数据为远端返回的
+type traceConn struct {
+	net.Conn
+	log      logger.FieldLogger
+	limit    int
+	name     string
+	mu       sync.Mutex
+	outbound traceBuffer
+	inbound  traceBuffer
+	once     sync.Once
+}
+
+// newTraceConn 记录连接前limit字节的数据，limit超过maxTraceBytes时按maxTraceBytes记录
+func newTraceConn(conn net.Conn, log logger.FieldLogger, limit int, name string) *traceConn {
+	if limit > maxTraceBytes {
+		limit = maxTraceBytes
+	}
+	return &traceConn{
+		Conn:     conn,
+		log:      log,
+		limit:    limit,
+		name:     name,
+		outbound: traceBuffer{direction: "local -> remote"},
+		inbound:  traceBuffer{direction: "remote -> local"},
+	}
+}
+
+func (c *traceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(&c.inbound, p[:n])
+	}
+	return n, err
+}
+
+func (c *traceConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.record(&c.outbound, p[:n])
+	}
+	return n, err
+}
+
+func (c *traceConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.flush(&c.outbound)
+		c.flush(&c.inbound)
+	})
+	return err
+}
+
+func (c *traceConn) record(buffer *traceBuffer, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if buffer.logged {
+		return
+	}
+	if remaining := c.limit - len(buffer.data); len(data) > remaining {
+		data = data[:remaining]
+	}
+	buffer.data = append(buffer.data, data...)
+	if len(buffer.data) >= c.limit {
+		c.flush(buffer)
+	}
+}
+
+// flush 输出一个方向记录的数据，每个方向只输出一次
+func (c *traceConn) flush(buffer *traceBuffer) {
+	if buffer.logged || len(buffer.data) == 0 {
+		return
+	}
+	buffer.logged = true
+	c.log.Infof(fmt.Sprintf("[*] Trace %s %s, first %d bytes:\n%s",
+		c.name, buffer.direction, len(buffer.data), hex.Dump(redactCredentials(buffer.data))))
+}
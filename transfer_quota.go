@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrTransferQuotaExceeded 隧道转发的流量达到TransferQuota，不再建立新的连接
+var ErrTransferQuotaExceeded = errors.New("transfer quota exceeded")
+
+// quotaConn 统计隧道转发流量的连接
+type quotaConn struct {
+	net.Conn
+	tunnel *SshTunnel
+}
+
+func (c *quotaConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.tunnel.addTransferred(n)
+	return n, err
+}
+
+func (c *quotaConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.tunnel.addTransferred(n)
+	return n, err
+}
+
+// addTransferred 累计转发的流量，第一次达到配额时记录日志并触发EventTransferQuotaExceeded
+func (s *SshTunnel) addTransferred(n int) {
+	if n <= 0 {
+		return
+	}
+	total := s.transferred.Add(int64(n))
+	if s.transferQuota > 0 && total >= s.transferQuota && s.quotaReached.CompareAndSwap(false, true) {
+		message := fmt.Sprintf("transferred %d bytes, quota %d bytes", total, s.transferQuota)
+		s.log.Infof(fmt.Sprintf("[!] Tunnel reached its transfer quota, rejecting new connections: %s", message))
+		s.emit(Event{Type: EventTransferQuotaExceeded, Message: message, Err: ErrTransferQuotaExceeded})
+	}
+}
+
+// checkTransferQuota 转发的流量达到配额时返回ErrTransferQuotaExceeded
+func (s *SshTunnel) checkTransferQuota() error {
+	if s.quotaReached.Load() {
+		return ErrTransferQuotaExceeded
+	}
+	return nil
+}
+
+// ResetTransferQuota 清零已经转发的流量，达到配额的隧道重新接受新的连接，用于按计费周期重置配额
+func (s *SshTunnel) ResetTransferQuota() {
+	s.transferred.Store(0)
+	s.quotaReached.Store(false)
+}
@@ -0,0 +1,23 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+)
+
+// serveTransparent 处理被iptables REDIRECT/TPROXY到本地端点的连接，按连接原始的目标地址通过隧道转发
+func (s *SshTunnel) serveTransparent(localConn net.Conn, listenAddr net.Addr, latency *ConnLatency) {
+	originalDst, err := getOriginalDst(localConn, listenAddr)
+	if err != nil {
+		s.log.Infof(fmt.Sprintf("[!] Error getting original destination of transparent connection: %s", err.Error()))
+		localConn.Close()
+		return
+	}
+	s.connLog.Infof(fmt.Sprintf("[*] Forwarding transparent connection to %s", originalDst))
+	remoteConn, serverConn, err := s.dialRoute(originalDst, latency)
+	if err != nil {
+		localConn.Close()
+		return
+	}
+	s.transfer(localConn, remoteConn, serverConn, originalDst, latency)
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	logger "github.com/sirupsen/logrus"
+	"net"
+	"syscall"
+)
+
+// linux/netfilter_ipv4.h 及 linux/netfilter_ipv6/ip6_tables.h 中定义的选项
+const (
+	soOriginalDst     = 80
+	ip6tSoOriginalDst = 80
+)
+
+// listenTransparent 监听透明代理模式下的本地端点
+// 会尝试为监听的socket设置IP_TRANSPARENT以支持TPROXY，没有权限时仍然可以处理iptables REDIRECT过来的连接
+func listenTransparent(address string) (net.Listener, error) {
+	listenConfig := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				if err := syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1); err != nil {
+					logger.Infof(fmt.Sprintf("[!] Could not set IP_TRANSPARENT, TPROXY is unavailable: %s", err.Error()))
+				}
+			})
+		},
+	}
+	return listenConfig.Listen(context.Background(), "tcp", address)
+}
+
+// getOriginalDst 获取连接被重定向之前的原始目标地址
+// REDIRECT的连接通过SO_ORIGINAL_DST获取，TPROXY的连接本地地址就是原始的目标地址
+func getOriginalDst(conn net.Conn, listenAddr net.Addr) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("transparent mode requires a tcp connection")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var originalDst string
+	var sockErr error
+	isIPv6 := tcpConn.LocalAddr().(*net.TCPAddr).IP.To4() == nil
+	err = rawConn.Control(func(fd uintptr) {
+		portBytes := make([]byte, 2)
+		if isIPv6 {
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, ip6tSoOriginalDst)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			binary.NativeEndian.PutUint16(portBytes, info.Addr.Port)
+			ip := net.IP(info.Addr.Addr[:])
+			originalDst = net.JoinHostPort(ip.String(), fmt.Sprintf("%d", binary.BigEndian.Uint16(portBytes)))
+			return
+		}
+		// sockaddr_in 与 ipv6_mreq 的大小相同，借用GetsockoptIPv6Mreq读取
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		ip := net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7])
+		port := binary.BigEndian.Uint16(mreq.Multiaddr[2:4])
+		originalDst = net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr == nil {
+		return originalDst, nil
+	}
+
+	// 不是REDIRECT过来的连接，此时如果本地地址不是监听的地址，说明是TPROXY过来的连接
+	localAddr := tcpConn.LocalAddr().(*net.TCPAddr)
+	if tcpListenAddr, ok := listenAddr.(*net.TCPAddr); ok && tcpListenAddr.Port == localAddr.Port {
+		return "", fmt.Errorf("get original destination failed, err: %w", sockErr)
+	}
+	return localAddr.String(), nil
+}
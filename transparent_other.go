@@ -0,0 +1,18 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparentUnsupported = errors.New("transparent proxy mode is only supported on linux")
+
+func listenTransparent(address string) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func getOriginalDst(conn net.Conn, listenAddr net.Addr) (string, error) {
+	return "", errTransparentUnsupported
+}
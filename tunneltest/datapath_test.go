@@ -0,0 +1,79 @@
+package tunneltest_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+	"tunnel"
+)
+
+// 转发数据路径的几种方式：每个本地连接单独建立ssh连接(以前的默认方式)、共用ssh连接(默认)及共用ssh连接并预先建立通道
+// 对比方式:
+//
+//	go test -run '^$' -bench . -benchmem ./tunneltest
+var datapathModes = []struct {
+	name   string
+	modify func(config *tunnel.TunnelConfig)
+}{
+	{"dedicated", func(config *tunnel.TunnelConfig) { config.DedicatedConnection = true }},
+	{"shared", nil},
+	{"shared+pool", func(config *tunnel.TunnelConfig) { config.ChannelPoolSize = 4 }},
+}
+
+// BenchmarkConnectionSetup 每次建立一个本地连接，测量从连接本地端点到收到回显的第一个字节的耗时
+func BenchmarkConnectionSetup(b *testing.B) {
+	for _, mode := range datapathModes {
+		b.Run(mode.name, func(b *testing.B) {
+			instance, _ := startTunnel(b, mode.modify)
+			addr := instance.LocalAddr().String()
+			// 等待预先建立的通道
+			time.Sleep(100 * time.Millisecond)
+			buf := make([]byte, 1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					b.Fatalf("dial local endpoint: %v", err)
+				}
+				if _, err = conn.Write(buf); err == nil {
+					_, err = io.ReadFull(conn, buf)
+				}
+				conn.Close()
+				if err != nil {
+					b.Fatalf("round trip: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkThroughput 通过一个本地连接持续发送数据并读取回显，每次操作转发32KB
+func BenchmarkThroughput(b *testing.B) {
+	const chunk = 32 << 10
+	for _, mode := range datapathModes {
+		b.Run(mode.name, func(b *testing.B) {
+			instance, _ := startTunnel(b, mode.modify)
+			conn, err := net.Dial("tcp", instance.LocalAddr().String())
+			if err != nil {
+				b.Fatalf("dial local endpoint: %v", err)
+			}
+			defer conn.Close()
+			payload := make([]byte, chunk)
+			b.SetBytes(chunk)
+			b.ReportAllocs()
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := conn.Write(payload); err != nil {
+						return
+					}
+				}
+			}()
+			if _, err = io.CopyN(io.Discard, conn, int64(b.N)*chunk); err != nil {
+				b.Fatalf("read echo: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package tunneltest_test
+
+import (
+	"net"
+	"testing"
+	"time"
+	"tunnel"
+)
+
+func TestHealthCheckReusesConnection(t *testing.T) {
+	checks := make(chan error, 16)
+	instance, server := startTunnel(t, func(config *tunnel.TunnelConfig) {
+		config.HealthCheckInterval = 20 * time.Millisecond
+		config.EventHandler = func(event tunnel.Event) {
+			if event.Type != tunnel.EventHealthCheck {
+				return
+			}
+			select {
+			case checks <- event.Err:
+			default:
+			}
+		}
+	})
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-checks:
+			if err != nil {
+				t.Fatalf("health check %d failed: %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("health check %d not run", i)
+		}
+	}
+	conn, err := net.Dial("tcp", instance.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial local endpoint: %v", err)
+	}
+	roundTrip(t, conn, "hello after health checks")
+	conn.Close()
+	if got := server.Connections(); got != 1 {
+		t.Fatalf("ssh server saw %d connections, want 1 shared by health checks and forwarding", got)
+	}
+
+	// ssh服务关闭后健康检查失败，隧道状态变为StateDown
+	server.Close()
+	deadline := time.After(5 * time.Second)
+	for instance.GetState() != tunnel.StateDown {
+		select {
+		case <-checks:
+		case <-deadline:
+			t.Fatalf("state = %v after ssh server closed, want %v", instance.GetState(), tunnel.StateDown)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package tunneltest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"tunnel"
+	"tunnel/tunneltest"
+)
+
+// managedConfig 构建通过测试ssh服务转发到echo服务的隧道配置，供管理器启动
+func managedConfig(t *testing.T, id string) (tunnel.TunnelConfig, *tunneltest.Server) {
+	t.Helper()
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatalf("start ssh server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	echo, err := tunneltest.NewEchoTarget()
+	if err != nil {
+		t.Fatalf("start echo target: %v", err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	config, err := server.TunnelConfig("tcp://" + echo.Addr)
+	if err != nil {
+		t.Fatalf("build tunnel config: %v", err)
+	}
+	config.ID = id
+	config.Quiet = true
+	config.LocalAddr = "127.0.0.1:0"
+	return *config, server
+}
+
+func TestManagerRejectsDuplicateID(t *testing.T) {
+	config, _ := managedConfig(t, "dup")
+	manager := tunnel.NewTunnelManager(nil)
+	t.Cleanup(manager.StopAll)
+
+	// 并发加入相同ID的隧道，只能有一个成功
+	const attempts = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.Start(config, tunnel.ManageOptions{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	started := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			started++
+		case !strings.Contains(err.Error(), "already managed"):
+			t.Fatalf("Start error = %v, want already managed", err)
+		}
+	}
+	if started != 1 {
+		t.Fatalf("%d tunnels started with the same ID, want 1", started)
+	}
+	if list := manager.List(); len(list) != 1 || list[0].Tunnel == nil {
+		t.Fatalf("List = %+v, want one running tunnel", list)
+	}
+
+	// 使用已占用的本地地址再次加入时应在启动隧道前被拒绝，而不是返回监听失败
+	running, _ := manager.Get("dup")
+	config.LocalAddr = running.LocalAddr().String()
+	if _, err := manager.Start(config, tunnel.ManageOptions{}); err == nil || !strings.Contains(err.Error(), "already managed") {
+		t.Fatalf("Start with a managed ID error = %v, want already managed", err)
+	}
+	if err := manager.Stop("dup"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if _, err := manager.Start(config, tunnel.ManageOptions{}); err != nil {
+		t.Fatalf("Start after Stop: %v", err)
+	}
+}
+
+// savedIDs 读取状态文件中保存的隧道ID
+func savedIDs(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read state file: %v", err)
+	}
+	var state struct {
+		Tunnels []struct {
+			ID string `json:"id"`
+		} `json:"tunnels"`
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("parse state file: %v", err)
+	}
+	var ids []string
+	for _, saved := range state.Tunnels {
+		ids = append(ids, saved.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestManagerPersistRoundTrip(t *testing.T) {
+	config, server := managedConfig(t, "web")
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	first := tunnel.NewTunnelManager(nil)
+	if err := first.Persist(path, nil); err != nil {
+		t.Fatalf("Persist on missing file: %v", err)
+	}
+	for _, id := range []string{"web", "db"} {
+		config.ID = id
+		if _, err := first.Start(config, tunnel.ManageOptions{Tenant: "team"}); err != nil {
+			t.Fatalf("Start %s: %v", id, err)
+		}
+	}
+	first.Close()
+	if got := savedIDs(t, path); strings.Join(got, ",") != "db,web" {
+		t.Fatalf("saved IDs = %v, want [db web]", got)
+	}
+
+	// 密码不会保存，恢复时通过prepare重新设置，db的prepare失败
+	errPrepare := errors.New("credentials unavailable")
+	failDB := true
+	prepare := func(config *tunnel.TunnelConfig) error {
+		if config.ID == "db" && failDB {
+			return errPrepare
+		}
+		config.Password = server.Password
+		return nil
+	}
+	second := tunnel.NewTunnelManager(nil)
+	if err := second.Persist(path, prepare); !errors.Is(err, errPrepare) {
+		t.Fatalf("Persist error = %v, want %v", err, errPrepare)
+	}
+	restored, ok := second.Tenant("team").Get("web")
+	if !ok {
+		t.Fatal("web not restored")
+	}
+	conn, err := net.Dial("tcp", restored.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial restored tunnel: %v", err)
+	}
+	roundTrip(t, conn, "hello after restore")
+	conn.Close()
+	if _, ok := second.Tenant("team").Get("db"); ok {
+		t.Fatal("db restored although prepare failed")
+	}
+	// 恢复失败的隧道仍然保留在状态文件中，之后的保存不会删除它
+	config.ID = "cache"
+	if _, err = second.Start(config, tunnel.ManageOptions{}); err != nil {
+		t.Fatalf("Start cache: %v", err)
+	}
+	second.Close()
+	if got := savedIDs(t, path); strings.Join(got, ",") != "cache,db,web" {
+		t.Fatalf("saved IDs = %v, want [cache db web]", got)
+	}
+
+	failDB = false
+	third := tunnel.NewTunnelManager(nil)
+	t.Cleanup(third.Close)
+	if err = third.Persist(path, prepare); err != nil {
+		t.Fatalf("Persist retry: %v", err)
+	}
+	if list := third.List(); len(list) != 3 {
+		t.Fatalf("List = %+v, want 3 restored tunnels", list)
+	}
+	if _, ok := third.Tenant("team").Get("db"); !ok {
+		t.Fatal("db not restored on retry")
+	}
+}
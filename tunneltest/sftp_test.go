@@ -0,0 +1,132 @@
+package tunneltest_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"tunnel"
+)
+
+// sshTunnel 启动隧道并返回*tunnel.SshTunnel，用于sftp传输
+func sshTunnel(t *testing.T) *tunnel.SshTunnel {
+	t.Helper()
+	instance, _ := startTunnel(t, nil)
+	sshTunnel, ok := instance.(*tunnel.SshTunnel)
+	if !ok {
+		t.Fatalf("tunnel type = %T, want *tunnel.SshTunnel", instance)
+	}
+	return sshTunnel
+}
+
+func TestSFTPRoundTrip(t *testing.T) {
+	sshTunnel := sshTunnel(t)
+	tests := []struct {
+		name string
+		size int
+		perm fs.FileMode
+	}{
+		{name: "empty file", size: 0, perm: 0600},
+		{name: "single chunk", size: 1000, perm: 0644},
+		// 大于一次读写的数据大小(32KiB)，且不是其整数倍
+		{name: "multiple chunks", size: 3*32*1024 + 123, perm: 0640},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := make([]byte, tt.size)
+			rand.Read(content)
+			local := filepath.Join(dir, "local")
+			if err := os.WriteFile(local, content, tt.perm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(local, tt.perm); err != nil {
+				t.Fatal(err)
+			}
+
+			remote := filepath.Join(dir, "remote")
+			if err := sshTunnel.UploadFile(local, remote); err != nil {
+				t.Fatalf("UploadFile: %v", err)
+			}
+			uploaded, err := os.ReadFile(remote)
+			if err != nil {
+				t.Fatalf("read uploaded file: %v", err)
+			}
+			if !bytes.Equal(uploaded, content) {
+				t.Fatalf("uploaded %d bytes, want %d bytes of the local file", len(uploaded), len(content))
+			}
+			info, err := os.Stat(remote)
+			if err != nil {
+				t.Fatalf("stat uploaded file: %v", err)
+			}
+			if info.Mode().Perm() != tt.perm {
+				t.Fatalf("uploaded file mode = %v, want %v", info.Mode().Perm(), tt.perm)
+			}
+
+			downloaded := filepath.Join(dir, "downloaded")
+			if err = sshTunnel.DownloadFile(remote, downloaded); err != nil {
+				t.Fatalf("DownloadFile: %v", err)
+			}
+			data, err := os.ReadFile(downloaded)
+			if err != nil {
+				t.Fatalf("read downloaded file: %v", err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Fatalf("downloaded %d bytes, want %d bytes of the remote file", len(data), len(content))
+			}
+		})
+	}
+}
+
+func TestSFTPClientReuse(t *testing.T) {
+	client, err := sshTunnel(t).NewSFTPClient()
+	if err != nil {
+		t.Fatalf("NewSFTPClient: %v", err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	for i, content := range []string{"first", "second, overwriting the first"} {
+		local := filepath.Join(dir, "local")
+		if err = os.WriteFile(local, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = client.UploadFile(local, filepath.Join(dir, "remote")); err != nil {
+			t.Fatalf("UploadFile %d: %v", i, err)
+		}
+		downloaded := filepath.Join(dir, "downloaded")
+		if err = client.DownloadFile(filepath.Join(dir, "remote"), downloaded); err != nil {
+			t.Fatalf("DownloadFile %d: %v", i, err)
+		}
+		if data, _ := os.ReadFile(downloaded); string(data) != content {
+			t.Fatalf("downloaded %q, want %q", data, content)
+		}
+	}
+}
+
+func TestSFTPMissingRemoteFile(t *testing.T) {
+	sshTunnel := sshTunnel(t)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+
+	err := sshTunnel.DownloadFile(filepath.Join(dir, "missing"), local)
+	var statusErr *tunnel.SFTPStatusError
+	if !errors.As(err, &statusErr) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("DownloadFile error = %v, want SFTPStatusError for a missing file", err)
+	}
+	if _, err = os.Stat(local); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("local file left after failed download, stat err = %v", err)
+	}
+
+	// 上传到不存在的目录同样返回状态错误
+	if err = os.WriteFile(local, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = sshTunnel.UploadFile(local, filepath.Join(dir, "missing", "remote"))
+	if !errors.As(err, &statusErr) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("UploadFile error = %v, want SFTPStatusError for a missing directory", err)
+	}
+}
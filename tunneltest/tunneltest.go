@@ -0,0 +1,391 @@
+// Package tunneltest 提供测试ssh隧道使用的进程内ssh服务及远端服务，不需要真实的跳板机
+package tunneltest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"tunnel"
+)
+
+// 默认的账号和密码
+const (
+	DefaultUsername = "tunnel"
+	DefaultPassword = "tunnel"
+)
+
+// Server 监听127.0.0.1随机端口的ssh服务，支持密码及公钥认证，处理direct-tcpip(即本地转发)的通道及tcpip-forward(即远程转发)的请求
+// session通道只支持sftp子系统，直接读写本机的文件，测试中应使用临时目录中的绝对路径
+type Server struct {
+	Addr     string // 监听的地址
+	Username string
+	Password string
+
+	listener       net.Listener
+	hostKey        ssh.Signer
+	authorizedKeys []ssh.PublicKey
+	connections    int64
+	mu             sync.Mutex
+	conns          map[net.Conn]struct{}
+	closed         bool
+	banner         string
+	wg             sync.WaitGroup
+}
+
+// NewServer 启动ssh服务，authorizedKeys为允许公钥认证的公钥，为空时只允许密码认证
+func NewServer(authorizedKeys ...ssh.PublicKey) (*Server, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen ssh server failed, err: %w", err)
+	}
+	s := &Server{
+		Addr:           listener.Addr().String(),
+		Username:       DefaultUsername,
+		Password:       DefaultPassword,
+		listener:       listener,
+		hostKey:        hostKey,
+		authorizedKeys: authorizedKeys,
+		conns:          map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// HostKey ssh服务的主机公钥
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// SetBanner 设置认证前发送给客户端的banner，为空时不发送
+func (s *Server) SetBanner(banner string) {
+	s.mu.Lock()
+	s.banner = banner
+	s.mu.Unlock()
+}
+
+// Connections 已经认证成功的ssh连接数
+func (s *Server) Connections() int {
+	return int(atomic.LoadInt64(&s.connections))
+}
+
+// TunnelConfig 返回通过该ssh服务连接到remote的隧道配置，remote为host:port或带协议的端点
+func (s *Server) TunnelConfig(remote string) (*tunnel.TunnelConfig, error) {
+	return tunnel.BuildTunnelConfig(tunnel.ProtocolSSH, s.Addr, remote, s.Username, s.Password)
+}
+
+// Close 关闭ssh服务及所有连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serverConfig() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == s.Username && string(password) == s.Password {
+				return nil, nil
+			}
+			return nil, errors.New("invalid username or password")
+		},
+	}
+	if len(s.authorizedKeys) > 0 {
+		config.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, authorizedKey := range s.authorizedKeys {
+				if meta.User() == s.Username && bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unauthorized public key")
+		}
+	}
+	config.BannerCallback = func(meta ssh.ConnMetadata) string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.banner
+	}
+	config.AddHostKey(s.hostKey)
+	return config
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	config := s.serverConfig()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handleConn(conn, config)
+		}()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	atomic.AddInt64(&s.connections, 1)
+	go handleGlobalRequests(serverConn, reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			go handleDirectTCPIP(newChannel)
+		case "session":
+			go handleSession(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// handleSession 处理session通道，只接受sftp子系统的请求，其他请求(如shell、exec)被拒绝
+func handleSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	for req := range requests {
+		var payload struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		server.Serve()
+		server.Close()
+		return
+	}
+}
+
+// handleDirectTCPIP 连接到通道请求的目标地址并转发数据
+func handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "malformed direct-tcpip request")
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	pipe(channel, target)
+}
+
+// handleGlobalRequests 处理tcpip-forward及cancel-tcpip-forward请求，ssh连接断开后关闭所有的监听
+func handleGlobalRequests(serverConn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	listeners := map[string]net.Listener{}
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+	for req := range reqs {
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		switch req.Type {
+		case "tcpip-forward":
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			port := uint32(listener.Addr().(*net.TCPAddr).Port)
+			listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+			var reply []byte
+			if payload.Port == 0 {
+				// 监听随机端口时回复实际监听的端口
+				reply = ssh.Marshal(struct{ Port uint32 }{port})
+			}
+			req.Reply(true, reply)
+			go forwardTCPIP(serverConn, listener, payload.Addr, port)
+		case "cancel-tcpip-forward":
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+			listener, ok := listeners[key]
+			if ok {
+				listener.Close()
+				delete(listeners, key)
+			}
+			req.Reply(ok, nil)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// forwardTCPIP 接受服务端监听上的连接，通过forwarded-tcpip通道交给客户端
+func forwardTCPIP(serverConn *ssh.ServerConn, listener net.Listener, addr string, port uint32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			origin := conn.RemoteAddr().(*net.TCPAddr)
+			payload := ssh.Marshal(struct {
+				Addr       string
+				Port       uint32
+				OriginAddr string
+				OriginPort uint32
+			}{addr, port, origin.IP.String(), uint32(origin.Port)})
+			channel, requests, err := serverConn.OpenChannel("forwarded-tcpip", payload)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			pipe(channel, conn)
+		}()
+	}
+}
+
+// pipe 在ssh通道和tcp连接之间双向转发数据，两个方向都结束后关闭
+func pipe(channel ssh.Channel, conn net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	io.Copy(conn, channel)
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	<-done
+	channel.Close()
+	conn.Close()
+}
+
+// Target 测试使用的远端服务
+type Target struct {
+	Addr     string // 监听的地址
+	listener net.Listener
+	server   *http.Server
+}
+
+// Close 关闭远端服务
+func (t *Target) Close() error {
+	if t.server != nil {
+		return t.server.Close()
+	}
+	return t.listener.Close()
+}
+
+// NewEchoTarget 启动将收到的数据原样返回的tcp服务
+func NewEchoTarget() (*Target, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen echo target failed, err: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return &Target{Addr: listener.Addr().String(), listener: listener}, nil
+}
+
+// NewHTTPTarget 启动http服务，handler为空时对所有请求返回200及请求的路径
+func NewHTTPTarget(handler http.Handler) (*Target, error) {
+	if handler == nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.URL.Path)
+		})
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen http target failed, err: %w", err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	return &Target{Addr: listener.Addr().String(), listener: listener, server: server}, nil
+}
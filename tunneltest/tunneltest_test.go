@@ -0,0 +1,157 @@
+package tunneltest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"tunnel"
+	"tunnel/tunneltest"
+)
+
+// startTunnel 启动通过测试ssh服务转发到echo服务的隧道，modify不为空时用于修改隧道的配置，测试结束时停止
+func startTunnel(t testing.TB, modify func(config *tunnel.TunnelConfig)) (tunnel.Tunnel, *tunneltest.Server) {
+	t.Helper()
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatalf("start ssh server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	echo, err := tunneltest.NewEchoTarget()
+	if err != nil {
+		t.Fatalf("start echo target: %v", err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	config, err := server.TunnelConfig("tcp://" + echo.Addr)
+	if err != nil {
+		t.Fatalf("build tunnel config: %v", err)
+	}
+	config.Quiet = true
+	// 由系统分配端口，避免随机选择的端口与其他连接冲突
+	config.LocalAddr = "127.0.0.1:0"
+	if modify != nil {
+		modify(config)
+	}
+	instance, err := tunnel.FastStartTunnel(*config)
+	if err != nil {
+		t.Fatalf("start tunnel: %v", err)
+	}
+	t.Cleanup(instance.Stop)
+	return instance, server
+}
+
+// roundTrip 通过conn发送message并读取echo服务的回复
+func roundTrip(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+	if _, err := io.WriteString(conn, message); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	reply := make([]byte, len(message))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(reply) != message {
+		t.Fatalf("reply = %q, want %q", reply, message)
+	}
+}
+
+func TestForwardRoundTrip(t *testing.T) {
+	instance, server := startTunnel(t, nil)
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", instance.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("dial local endpoint: %v", err)
+		}
+		roundTrip(t, conn, "hello through the tunnel")
+		conn.Close()
+	}
+	if server.Connections() == 0 {
+		t.Fatal("ssh server saw no connections")
+	}
+}
+
+func TestStopIsIdempotent(t *testing.T) {
+	instance, _ := startTunnel(t, nil)
+	addr := instance.LocalAddr().String()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance.Stop()
+		}()
+	}
+	wg.Wait()
+	instance.Stop()
+
+	if !instance.IsStopped() {
+		t.Fatal("tunnel not stopped")
+	}
+	select {
+	case <-instance.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done not closed after Stop")
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("local endpoint still accepts connections after Stop")
+	}
+}
+
+func TestShutdownDrainsConnections(t *testing.T) {
+	instance, _ := startTunnel(t, nil)
+	conn, err := net.Dial("tcp", instance.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial local endpoint: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "before shutdown")
+
+	done := make(chan error, 1)
+	go func() { done <- instance.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned with an open connection: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	// 排空期间已经建立的连接继续工作
+	roundTrip(t, conn, "while draining")
+
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the last connection closed")
+	}
+	if !instance.IsStopped() {
+		t.Fatal("tunnel not stopped after Shutdown")
+	}
+}
+
+func TestShutdownTimeoutClosesConnections(t *testing.T) {
+	instance, _ := startTunnel(t, nil)
+	conn, err := net.Dial("tcp", instance.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial local endpoint: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "before shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := instance.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection still open after Shutdown timed out")
+	}
+}
@@ -0,0 +1,266 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 隧道配置错误的原因，可以通过errors.Is判断
+var (
+	ErrMissingEndpoint     = errors.New("endpoint is required")
+	ErrMissingField        = errors.New("field is required")
+	ErrUnsupportedProtocol = errors.New("unsupported tunnel protocol")
+	ErrBadPort             = errors.New("port must be between 1 and 65535")
+	ErrBadOption           = errors.New("unsupported option value")
+)
+
+// ConfigError 隧道配置错误
+type ConfigError struct {
+	Field string // 配置错误的字段，如TunnelEndpoint
+	Value string // 字段的值
+	Err   error  // 错误的原因，为ErrXXX之一
+}
+
+func (e *ConfigError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("invalid tunnel config %s: %s", e.Field, e.Err.Error())
+	}
+	return fmt.Sprintf("invalid tunnel config %s %q: %s", e.Field, e.Value, e.Err.Error())
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Validate 检查隧道配置，包括协议是否已在DefaultRegistry中注册，配置错误时返回*ConfigError
+func (c *TunnelConfig) Validate() error {
+	if _, ok := lookupFactory(c.Protocol); !ok {
+		return &ConfigError{Field: "Protocol", Value: c.Protocol, Err: ErrUnsupportedProtocol}
+	}
+	return c.validateFields()
+}
+
+// validateFields 检查协议以外的配置，隧道工厂创建隧道前会调用，工厂可能注册在独立的Registry中，不检查协议
+func (c *TunnelConfig) validateFields() error {
+	tunnelEndpoints := c.TunnelEndpoints
+	if len(tunnelEndpoints) == 0 {
+		if c.TunnelEndpoint == "" {
+			return &ConfigError{Field: "TunnelEndpoint", Err: ErrMissingEndpoint}
+		}
+		tunnelEndpoints = []string{c.TunnelEndpoint}
+	}
+	for _, endpoint := range tunnelEndpoints {
+		if endpoint == "" {
+			return &ConfigError{Field: "TunnelEndpoints", Err: ErrMissingEndpoint}
+		}
+		if err := checkAddrPort(endpoint, false); err != nil {
+			return &ConfigError{Field: "TunnelEndpoint", Value: endpoint, Err: err}
+		}
+	}
+
+	switch c.LocalMode {
+	case LocalModeForward:
+		// 直接转发时需要远端地址，其他模式按请求或连接确定远端地址
+		if c.RemoteAddr == "" && len(c.RemoteAddrs) == 0 {
+			if len(c.Forwards) > 0 {
+				break
+			}
+			return &ConfigError{Field: "RemoteAddr", Err: ErrMissingEndpoint}
+		}
+		if c.RemotePort == 0 && len(c.RemoteAddrs) == 0 {
+			return &ConfigError{Field: "RemotePort", Value: "0", Err: ErrBadPort}
+		}
+	case LocalModeHTTPProxy, LocalModeConnect, LocalModeTransparent:
+	default:
+		return &ConfigError{Field: "LocalMode", Value: c.LocalMode, Err: ErrBadOption}
+	}
+	if c.RemotePort < 0 || c.RemotePort > 65535 {
+		return &ConfigError{Field: "RemotePort", Value: strconv.Itoa(c.RemotePort), Err: ErrBadPort}
+	}
+	for _, addr := range c.RemoteAddrs {
+		if err := checkAddrPort(addr, false); err != nil {
+			return &ConfigError{Field: "RemoteAddrs", Value: addr, Err: err}
+		}
+		if _, _, hasPort := splitHostPort(addr); !hasPort && c.RemotePort == 0 {
+			return &ConfigError{Field: "RemoteAddrs", Value: addr, Err: ErrBadPort}
+		}
+	}
+	for serverName, target := range c.SNIRoutes {
+		if err := checkAddrPort(target, false); err != nil {
+			return &ConfigError{Field: "SNIRoutes", Value: serverName, Err: err}
+		}
+	}
+	switch c.LoadBalance {
+	case "", LoadBalanceRoundRobin, LoadBalanceLeastConn:
+	default:
+		return &ConfigError{Field: "LoadBalance", Value: c.LoadBalance, Err: ErrBadOption}
+	}
+
+	if c.Listener == nil && c.LocalAddr != "" && !isSystemdAddr(c.LocalAddr) {
+		if _, _, hasPort := splitHostPort(c.LocalAddr); !hasPort {
+			return &ConfigError{Field: "LocalAddr", Value: c.LocalAddr, Err: ErrBadPort}
+		}
+		if err := checkAddrPort(c.LocalAddr, true); err != nil {
+			return &ConfigError{Field: "LocalAddr", Value: c.LocalAddr, Err: err}
+		}
+	}
+	switch c.ConnectPolicy {
+	case ConnectLazy, ConnectEager, ConnectBackground:
+	default:
+		return &ConfigError{Field: "ConnectPolicy", Value: c.ConnectPolicy, Err: ErrBadOption}
+	}
+	if c.HostKeyCallback != nil && c.HostKeyPolicy != HostKeyInsecure {
+		return &ConfigError{Field: "HostKeyCallback", Value: c.HostKeyPolicy, Err: ErrBadOption}
+	}
+	switch c.HostKeyPolicy {
+	case HostKeyInsecure, HostKeySSHFP:
+	case HostKeyTOFU, HostKeyKnownHosts:
+		if c.KnownHostsFile == "" {
+			return &ConfigError{Field: "KnownHostsFile", Err: ErrMissingField}
+		}
+	default:
+		return &ConfigError{Field: "HostKeyPolicy", Value: c.HostKeyPolicy, Err: ErrBadOption}
+	}
+	if c.SSHFPResolver != "" {
+		if _, _, hasPort := splitHostPort(c.SSHFPResolver); !hasPort {
+			return &ConfigError{Field: "SSHFPResolver", Value: c.SSHFPResolver, Err: ErrBadPort}
+		}
+	}
+	if err := checkClientVersion(c.ClientVersion); err != nil {
+		return &ConfigError{Field: "ClientVersion", Value: c.ClientVersion, Err: err}
+	}
+	if c.PACPath != "" && (!strings.HasPrefix(c.PACPath, "/") || (c.LocalMode != LocalModeHTTPProxy && c.LocalMode != LocalModeConnect)) {
+		return &ConfigError{Field: "PACPath", Value: c.PACPath, Err: ErrBadOption}
+	}
+	if host, err := checkStaticHosts(c.Hosts); err != nil {
+		return &ConfigError{Field: "Hosts", Value: host, Err: err}
+	}
+	if c.ResolveLocally && c.ResolveRemotely {
+		return &ConfigError{Field: "ResolveLocally", Value: "true", Err: ErrBadOption}
+	}
+	if c.DNSCacheTTL < 0 || c.DNSNegativeCacheTTL < 0 {
+		return &ConfigError{Field: "DNSCacheTTL", Value: c.DNSCacheTTL.String(), Err: ErrBadOption}
+	}
+	if c.IdleShutdown < 0 {
+		return &ConfigError{Field: "IdleShutdown", Value: c.IdleShutdown.String(), Err: ErrBadOption}
+	}
+	if c.IdleDisconnect < 0 || (c.IdleDisconnect > 0 && (c.ChannelPoolSize > 0 || c.RemoteSOCKSAddr != "")) {
+		return &ConfigError{Field: "IdleDisconnect", Value: c.IdleDisconnect.String(), Err: ErrBadOption}
+	}
+	if c.TransferQuota < 0 {
+		return &ConfigError{Field: "TransferQuota", Value: strconv.FormatInt(c.TransferQuota, 10), Err: ErrBadOption}
+	}
+	if c.MaxConnectionLifetime < 0 {
+		return &ConfigError{Field: "MaxConnectionLifetime", Value: c.MaxConnectionLifetime.String(), Err: ErrBadOption}
+	}
+	if c.RekeyThreshold > 0 && c.RekeyThreshold < minRekeyThreshold {
+		return &ConfigError{Field: "RekeyThreshold", Value: strconv.FormatUint(c.RekeyThreshold, 10), Err: ErrBadOption}
+	}
+	if err := checkLogLevel(c.LogLevel); err != nil {
+		return &ConfigError{Field: "LogLevel", Value: c.LogLevel, Err: err}
+	}
+	if c.LogSampleRate < 0 {
+		return &ConfigError{Field: "LogSampleRate", Value: strconv.Itoa(c.LogSampleRate), Err: ErrBadOption}
+	}
+	if c.RemoteDialRetries < 0 {
+		return &ConfigError{Field: "RemoteDialRetries", Value: strconv.Itoa(c.RemoteDialRetries), Err: ErrBadOption}
+	}
+	if c.ChannelPoolSize < 0 {
+		return &ConfigError{Field: "ChannelPoolSize", Value: strconv.Itoa(c.ChannelPoolSize), Err: ErrBadOption}
+	}
+	if c.ChannelPoolSize > 0 && (c.LocalMode != LocalModeForward || c.RemoteAddr == "" || len(c.RemoteAddrs) > 0) {
+		return &ConfigError{Field: "ChannelPoolSize", Value: strconv.Itoa(c.ChannelPoolSize), Err: ErrBadOption}
+	}
+	if c.RemoteSOCKSAddr != "" {
+		host, _, hasPort := splitHostPort(c.RemoteSOCKSAddr)
+		if !hasPort {
+			return &ConfigError{Field: "RemoteSOCKSAddr", Value: c.RemoteSOCKSAddr, Err: ErrBadPort}
+		}
+		if host == "" {
+			// 监听所有地址时需要明确指定0.0.0.0
+			return &ConfigError{Field: "RemoteSOCKSAddr", Value: c.RemoteSOCKSAddr, Err: ErrBadOption}
+		}
+		if err := checkAddrPort(c.RemoteSOCKSAddr, true); err != nil {
+			return &ConfigError{Field: "RemoteSOCKSAddr", Value: c.RemoteSOCKSAddr, Err: err}
+		}
+	}
+	if c.H2Relay != "" && c.TunnelDialer != nil {
+		return &ConfigError{Field: "TunnelDialer", Value: c.H2Relay, Err: ErrBadOption}
+	}
+	if c.H2Relay != "" {
+		if err := checkH2Relay(c.H2Relay); err != nil {
+			return &ConfigError{Field: "H2Relay", Value: c.H2Relay, Err: err}
+		}
+	}
+	if (c.LocalTLS || c.LocalTLSConfig != nil) && c.LocalMode == LocalModeTransparent {
+		return &ConfigError{Field: "LocalTLS", Value: c.LocalMode, Err: ErrBadOption}
+	}
+	if (c.RemoteTLS || c.RemoteTLSConfig != nil) && c.LocalMode != LocalModeForward {
+		return &ConfigError{Field: "RemoteTLS", Value: c.LocalMode, Err: ErrBadOption}
+	}
+	if c.HealthCheckTLS && !strings.EqualFold(c.TunneledProtocol, "https") {
+		return &ConfigError{Field: "HealthCheckTLS", Value: c.TunneledProtocol, Err: ErrBadOption}
+	}
+	// 只有Forwards时没有可以改写成的远端地址
+	if c.RewriteHTTP && (c.LocalMode != LocalModeForward || !strings.EqualFold(c.TunneledProtocol, "http") ||
+		(c.RemoteAddr == "" && len(c.RemoteAddrs) == 0)) {
+		return &ConfigError{Field: "RewriteHTTP", Value: c.TunneledProtocol, Err: ErrBadOption}
+	}
+	if len(c.Routes) > 0 {
+		if c.LocalMode == LocalModeForward {
+			return &ConfigError{Field: "Routes", Value: c.LocalMode, Err: ErrBadOption}
+		}
+		if _, err := compileRoutes(c.Routes); err != nil {
+			return err
+		}
+	}
+	for localAddr, remoteAddr := range c.Forwards {
+		if c.LocalMode != LocalModeForward {
+			return &ConfigError{Field: "Forwards", Value: localAddr, Err: ErrBadOption}
+		}
+		if err := checkLocalAddr(localAddr); err != nil {
+			return &ConfigError{Field: "Forwards", Value: localAddr, Err: err}
+		}
+		if _, _, hasPort := splitHostPort(remoteAddr); !hasPort {
+			return &ConfigError{Field: "Forwards", Value: remoteAddr, Err: ErrBadPort}
+		}
+		if err := checkAddrPort(remoteAddr, false); err != nil {
+			return &ConfigError{Field: "Forwards", Value: remoteAddr, Err: err}
+		}
+	}
+	for _, addr := range c.LocalAddrs {
+		if c.LocalMode == LocalModeTransparent {
+			return &ConfigError{Field: "LocalAddrs", Value: addr, Err: ErrBadOption}
+		}
+		if err := checkLocalAddr(addr); err != nil {
+			return &ConfigError{Field: "LocalAddrs", Value: addr, Err: err}
+		}
+	}
+	return nil
+}
+
+// checkLocalAddr 检查额外的本地监听地址，unix:开头的地址不检查
+func checkLocalAddr(addr string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		return nil
+	}
+	if _, _, hasPort := splitHostPort(addr); !hasPort {
+		return ErrBadPort
+	}
+	return checkAddrPort(addr, true)
+}
+
+// checkAddrPort 检查host:port中的端口，未指定端口时不检查，allowZero为true时允许由系统分配端口的0
+func checkAddrPort(addr string, allowZero bool) error {
+	_, port, hasPort := splitHostPort(addr)
+	if !hasPort {
+		return nil
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 0 || number > 65535 || (number == 0 && !allowZero) {
+		return ErrBadPort
+	}
+	return nil
+}
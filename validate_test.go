@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// validConfig 返回可以通过校验的直接转发配置
+func validConfig() TunnelConfig {
+	return TunnelConfig{
+		Protocol:         ProtocolSSH,
+		TunnelEndpoint:   "10.0.0.1:22",
+		Username:         "root",
+		Password:         "secret",
+		RemoteAddr:       "192.168.1.111",
+		RemotePort:       80,
+		TunneledProtocol: "http",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(c *TunnelConfig)
+		wantField string
+		wantErr   error
+	}{
+		{name: "valid forward", modify: func(c *TunnelConfig) {}},
+		{name: "valid http proxy without remote", modify: func(c *TunnelConfig) {
+			c.LocalMode, c.RemoteAddr, c.RemotePort = LocalModeHTTPProxy, "", 0
+		}},
+		{name: "valid forwards only", modify: func(c *TunnelConfig) {
+			c.RemoteAddr, c.RemotePort = "", 0
+			c.Forwards = map[string]string{"127.0.0.1:5432": "db:5432"}
+		}},
+		{name: "unregistered protocol", modify: func(c *TunnelConfig) { c.Protocol = "FTP" },
+			wantField: "Protocol", wantErr: ErrUnsupportedProtocol},
+		{name: "missing tunnel endpoint", modify: func(c *TunnelConfig) { c.TunnelEndpoint = "" },
+			wantField: "TunnelEndpoint", wantErr: ErrMissingEndpoint},
+		{name: "empty failover endpoint", modify: func(c *TunnelConfig) { c.TunnelEndpoints = []string{"10.0.0.1:22", ""} },
+			wantField: "TunnelEndpoints", wantErr: ErrMissingEndpoint},
+		{name: "tunnel endpoint port out of range", modify: func(c *TunnelConfig) { c.TunnelEndpoint = "10.0.0.1:70000" },
+			wantField: "TunnelEndpoint", wantErr: ErrBadPort},
+		{name: "missing remote addr", modify: func(c *TunnelConfig) { c.RemoteAddr = "" },
+			wantField: "RemoteAddr", wantErr: ErrMissingEndpoint},
+		{name: "missing remote port", modify: func(c *TunnelConfig) { c.RemotePort = 0 },
+			wantField: "RemotePort", wantErr: ErrBadPort},
+		{name: "negative remote port", modify: func(c *TunnelConfig) { c.LocalMode, c.RemotePort = LocalModeHTTPProxy, -1 },
+			wantField: "RemotePort", wantErr: ErrBadPort},
+		{name: "remote addrs without port", modify: func(c *TunnelConfig) { c.RemotePort, c.RemoteAddrs = 0, []string{"10.0.0.2"} },
+			wantField: "RemoteAddrs", wantErr: ErrBadPort},
+		{name: "unknown local mode", modify: func(c *TunnelConfig) { c.LocalMode = "socks" },
+			wantField: "LocalMode", wantErr: ErrBadOption},
+		{name: "local addr without port", modify: func(c *TunnelConfig) { c.LocalAddr = "127.0.0.1" },
+			wantField: "LocalAddr", wantErr: ErrBadPort},
+		{name: "unknown load balance", modify: func(c *TunnelConfig) { c.LoadBalance = "random" },
+			wantField: "LoadBalance", wantErr: ErrBadOption},
+		{name: "known hosts policy without file", modify: func(c *TunnelConfig) { c.HostKeyPolicy = HostKeyKnownHosts },
+			wantField: "KnownHostsFile", wantErr: ErrMissingField},
+		{name: "negative idle shutdown", modify: func(c *TunnelConfig) { c.IdleShutdown = -time.Second },
+			wantField: "IdleShutdown", wantErr: ErrBadOption},
+		{name: "resolve locally and remotely", modify: func(c *TunnelConfig) { c.ResolveLocally, c.ResolveRemotely = true, true },
+			wantField: "ResolveLocally", wantErr: ErrBadOption},
+		{name: "channel pool in proxy mode", modify: func(c *TunnelConfig) { c.LocalMode, c.ChannelPoolSize = LocalModeHTTPProxy, 2 },
+			wantField: "ChannelPoolSize", wantErr: ErrBadOption},
+		{name: "remote socks without host", modify: func(c *TunnelConfig) { c.RemoteSOCKSAddr = ":1080" },
+			wantField: "RemoteSOCKSAddr", wantErr: ErrBadOption},
+		{name: "rewrite http with forwards only", modify: func(c *TunnelConfig) {
+			c.RemoteAddr, c.RemotePort, c.RewriteHTTP = "", 0, true
+			c.Forwards = map[string]string{"127.0.0.1:8080": "web:80"}
+		}, wantField: "RewriteHTTP", wantErr: ErrBadOption},
+		{name: "rewrite http for tcp", modify: func(c *TunnelConfig) { c.TunneledProtocol, c.RewriteHTTP = "tcp", true },
+			wantField: "RewriteHTTP", wantErr: ErrBadOption},
+		{name: "forward remote without port", modify: func(c *TunnelConfig) {
+			c.Forwards = map[string]string{"127.0.0.1:5432": "db"}
+		}, wantField: "Forwards", wantErr: ErrBadPort},
+		{name: "routes in forward mode", modify: func(c *TunnelConfig) { c.Routes = []RouteRule{{Match: "*"}} },
+			wantField: "Routes", wantErr: ErrBadOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.modify(&config)
+			err := config.Validate()
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("Validate() = %v, want *ConfigError", err)
+			}
+			if configErr.Field != tt.wantField || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() = %v (field %s), want field %s with %v", err, configErr.Field, tt.wantField, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *ConfigError
+		want string
+	}{
+		{&ConfigError{Field: "TunnelEndpoint", Err: ErrMissingEndpoint}, "invalid tunnel config TunnelEndpoint: endpoint is required"},
+		{&ConfigError{Field: "RemotePort", Value: "0", Err: ErrBadPort}, `invalid tunnel config RemotePort "0": port must be between 1 and 65535`},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// 工厂创建隧道前校验配置，配置错误时不会创建隧道
+func TestFactoryRejectsInvalidConfig(t *testing.T) {
+	config := validConfig()
+	config.RemotePort = 70000
+	if _, err := FastStartTunnel(config); !errors.Is(err, ErrBadPort) {
+		t.Fatalf("FastStartTunnel() = %v, want %v", err, ErrBadPort)
+	}
+}
@@ -0,0 +1,174 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// VaultCredentialProvider 从HashiCorp Vault获取隧道的凭据
+//
+// 配置了SecretPath时从KV引擎(v1或v2)读取凭据，读取secret中的username、password、private_key字段；
+// 配置了SSHRole时通过SSH引擎为私钥签发证书，没有私钥时会生成一次性的密钥对，此时不需要保存任何长期有效的私钥
+type VaultCredentialProvider struct {
+	Address    string       // Vault的地址，如https://vault.example.com:8200
+	Token      string       // 访问Vault的token
+	Namespace  string       // Vault企业版的namespace，可以为空
+	HTTPClient *http.Client // 为空时使用超时时间为30s的客户端
+
+	SecretPath string // KV secret的路径，如secret/data/bastion(v2)或secret/bastion(v1)
+
+	SSHMount      string // SSH引擎的挂载路径，默认为ssh
+	SSHRole       string // 签发证书使用的角色
+	Username      string // KV secret中没有username时使用的账号，同时作为证书的principal
+	SSHPrincipals string // 证书的principals，多个以逗号分隔，为空时使用账号
+}
+
+// vaultResponse Vault接口的响应
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+func (v *VaultCredentialProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	credentials := &Credentials{Username: v.Username}
+	if v.SecretPath != "" {
+		if err := v.readSecret(ctx, credentials); err != nil {
+			return nil, err
+		}
+	}
+	if v.SSHRole != "" {
+		if err := v.signKey(ctx, credentials); err != nil {
+			return nil, err
+		}
+	}
+	return credentials, nil
+}
+
+// readSecret 从KV引擎读取凭据
+func (v *VaultCredentialProvider) readSecret(ctx context.Context, credentials *Credentials) error {
+	resp, err := v.request(ctx, http.MethodGet, v.SecretPath, nil)
+	if err != nil {
+		return err
+	}
+	data := resp.Data
+	// KV v2的数据在data.data中
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	if username, ok := data["username"].(string); ok && username != "" {
+		credentials.Username = username
+	}
+	if password, ok := data["password"].(string); ok {
+		credentials.Password = password
+	}
+	if privateKey, ok := data["private_key"].(string); ok {
+		credentials.PrivateKey = []byte(privateKey)
+	}
+	return nil
+}
+
+// signKey 通过SSH引擎为私钥签发证书
+func (v *VaultCredentialProvider) signKey(ctx context.Context, credentials *Credentials) error {
+	if len(credentials.PrivateKey) == 0 {
+		privateKey, err := generateEphemeralKey()
+		if err != nil {
+			return err
+		}
+		credentials.PrivateKey = privateKey
+	}
+	signer, err := ssh.ParsePrivateKey(credentials.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("parse private key failed, err: %w", err)
+	}
+
+	principals := v.SSHPrincipals
+	if principals == "" {
+		principals = credentials.Username
+	}
+	mount := v.SSHMount
+	if mount == "" {
+		mount = "ssh"
+	}
+	body := map[string]interface{}{
+		"public_key":       string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		"valid_principals": principals,
+		"cert_type":        "user",
+	}
+	resp, err := v.request(ctx, http.MethodPost, fmt.Sprintf("%s/sign/%s", strings.Trim(mount, "/"), v.SSHRole), body)
+	if err != nil {
+		return err
+	}
+	signedKey, ok := resp.Data["signed_key"].(string)
+	if !ok || signedKey == "" {
+		return errors.New("vault did not return a signed key")
+	}
+	credentials.Certificate = []byte(signedKey)
+	return nil
+}
+
+// request 调用Vault的接口
+func (v *VaultCredentialProvider) request(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimRight(v.Address, "/"), strings.TrimLeft(path, "/"))
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = credentialHTTPClient
+	}
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request vault failed, err: %w", err)
+	}
+	defer httpResp.Body.Close()
+	resp := &vaultResponse{}
+	if err = json.NewDecoder(httpResp.Body).Decode(resp); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("decode vault response failed, err: %w", err)
+	}
+	if httpResp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("vault %s %s failed, status: %s, errors: %s", method, path, httpResp.Status, strings.Join(resp.Errors, "; "))
+	}
+	return resp, nil
+}
+
+// generateEphemeralKey 生成一次性的ed25519私钥，PEM格式
+func generateEphemeralKey() ([]byte, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}